@@ -21,7 +21,6 @@ GRPC_CLIENT_PATH="$MULTI_SET_ROOT/cmd/grpc-client"
 GRPC_SERVER_PATH="$MULTI_SET_ROOT/cmd/grpc-server"
 KAFKA_PRODUCER_PATH="$MULTI_SET_ROOT/cmd/kafka-producer"
 KAFKA_CONSUMER_PATH="$MULTI_SET_ROOT/cmd/kafka-consumer"
-HTTP_REST_PATH="$MULTI_SET_ROOT/cmd/http-rest"
 
 # Original service paths for go.mod dependencies
 ORIG_DB_SQL_PATH="$PROJECT_ROOT/../services/db-sql-multi"
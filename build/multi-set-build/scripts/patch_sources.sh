@@ -121,38 +121,8 @@ func getEnvOrDefault(key, defaultValue string) string {\
 ' "$HTTP_MAIN"
 fi
 
-# Update external handlers to use environment variables
-EXT_HANDLERS="$PROJECT_ROOT/http-rest/internal/handlers/external_handlers.go"
-if [ ! -f "$EXT_HANDLERS.backup" ]; then
-    echo -e "${YELLOW}Backing up and patching $EXT_HANDLERS${RESET}"
-    cp "$EXT_HANDLERS" "$EXT_HANDLERS.backup"
-
-    # Add import and helper function
-    sed -i '/^import (/a\
-	"os"' "$EXT_HANDLERS"
-    
-    sed -i '/^type TriggerResponse/i\
-// getEnvOrDefault returns environment variable value or default\
-func getEnvOrDefault(key, defaultValue string) string {\
-	if value := os.Getenv(key); value != "" {\
-		return value\
-	}\
-	return defaultValue\
-}\
-' "$EXT_HANDLERS"
-    
-    # Replace hardcoded URLs with environment-based ones
-    sed -i 's|"http://localhost:8081/trigger-crud"|"http://localhost:" + getEnvOrDefault("PORT_DB", "8081") + "/trigger-crud"|' "$EXT_HANDLERS"
-    sed -i 's|"http://localhost:8082/trigger-produce"|"http://localhost:" + getEnvOrDefault("PORT_KAFKA", "8082") + "/trigger-produce"|' "$EXT_HANDLERS"
-    sed -i 's|"http://localhost:8083/trigger-simple"|"http://localhost:" + getEnvOrDefault("PORT_GRPC_CLIENT", "8083") + "/trigger-simple"|' "$EXT_HANDLERS"
-    sed -i 's|"http://localhost:8083/trigger-stream"|"http://localhost:" + getEnvOrDefault("PORT_GRPC_CLIENT", "8083") + "/trigger-stream"|' "$EXT_HANDLERS"
-    
-    # Update the services array in TriggerAllServices
-    sed -i 's|{"database-sql", "http://localhost:8081/trigger-crud"}|{"database-sql", "http://localhost:" + getEnvOrDefault("PORT_DB", "8081") + "/trigger-crud"}|' "$EXT_HANDLERS"
-    sed -i 's|{"kafka-go", "http://localhost:8082/trigger-produce"}|{"kafka-go", "http://localhost:" + getEnvOrDefault("PORT_KAFKA", "8082") + "/trigger-produce"}|' "$EXT_HANDLERS"
-    sed -i 's|{"grpc-unary", "http://localhost:8083/trigger-simple"}|{"grpc-unary", "http://localhost:" + getEnvOrDefault("PORT_GRPC_CLIENT", "8083") + "/trigger-simple"}|' "$EXT_HANDLERS"
-    sed -i 's|{"grpc-stream", "http://localhost:8083/trigger-stream"}|{"grpc-stream", "http://localhost:" + getEnvOrDefault("PORT_GRPC_CLIENT", "8083") + "/trigger-stream"}|' "$EXT_HANDLERS"
-fi
+# http-rest trigger targets need no patching: the registry's built-in
+# defaults already read PORT_DB, PORT_KAFKA and PORT_GRPC_CLIENT.
 
 echo -e "${GREEN}✓ All source files patched successfully${RESET}"
 echo -e "${YELLOW}Note: Original files backed up with .backup extension${RESET}"
@@ -19,7 +19,6 @@ FILES=(
     "$PROJECT_ROOT/grpc-svc/cmd/server/main.go"
     "$PROJECT_ROOT/kafka-segmentio/cmd/producer/main.go"
     "$PROJECT_ROOT/http-rest/cmd/api/main.go"
-    "$PROJECT_ROOT/http-rest/internal/handlers/external_handlers.go"
 )
 
 for file in "${FILES[@]}"; do
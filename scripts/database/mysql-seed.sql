@@ -0,0 +1,21 @@
+-- MySQL sample data for APM Examples
+-- Load after the db-sql-multi migrations have created the tables. Safe to
+-- run again: existing rows are left alone.
+
+INSERT IGNORE INTO users (name, email) VALUES
+    ('John Doe', 'john@example.com'),
+    ('Jane Smith', 'jane@example.com'),
+    ('Bob Johnson', 'bob@example.com');
+
+-- Orders have no natural key, so each is skipped if its user already has it.
+INSERT INTO orders (user_id, amount, status)
+SELECT u.id, s.amount, s.status
+FROM users u
+JOIN (
+    SELECT 'john@example.com' AS email, 99.99 AS amount, 'completed' AS status
+    UNION ALL SELECT 'jane@example.com', 149.50, 'pending'
+    UNION ALL SELECT 'bob@example.com', 75.25, 'completed'
+) s ON s.email = u.email
+WHERE NOT EXISTS (
+    SELECT 1 FROM orders o WHERE o.user_id = u.id AND o.amount = s.amount
+);
@@ -0,0 +1,22 @@
+-- PostgreSQL sample data for APM Examples
+-- Load after the db-sql-multi migrations have created the tables. Safe to
+-- run again: existing rows are left alone.
+
+INSERT INTO users (name, email) VALUES
+    ('John Doe', 'john@example.com'),
+    ('Jane Smith', 'jane@example.com'),
+    ('Bob Johnson', 'bob@example.com')
+ON CONFLICT (email) DO NOTHING;
+
+-- Orders have no natural key, so each is skipped if its user already has it.
+INSERT INTO orders (user_id, amount, status)
+SELECT u.id, s.amount, s.status
+FROM users u
+JOIN (VALUES
+    ('john@example.com', 99.99, 'completed'),
+    ('jane@example.com', 149.50, 'pending'),
+    ('bob@example.com', 75.25, 'completed')
+) AS s (email, amount, status) ON s.email = u.email
+WHERE NOT EXISTS (
+    SELECT 1 FROM orders o WHERE o.user_id = u.id AND o.amount = s.amount
+);
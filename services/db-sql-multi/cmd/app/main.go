@@ -2,16 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"db-sql-multi/internal/config"
 	"db-sql-multi/internal/db"
+	"db-sql-multi/internal/handlers"
 	"db-sql-multi/internal/repo"
 	"db-sql-multi/internal/service"
 )
@@ -23,6 +28,7 @@ type response struct {
 
 func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	slog.SetDefault(logger) // migrations log through the default logger
 	cfg := config.Load()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
@@ -36,10 +42,23 @@ func main() {
 	defer pair.My.Close()
 	defer pair.Pg.Close()
 
+	myRepo, err := repo.New(cfg.MySQL.Driver, pair.My)
+	if err != nil {
+		logger.Error("mysql slot", "err", err)
+		os.Exit(1)
+	}
+	pgRepo, err := repo.New(cfg.PG.Driver, pair.Pg)
+	if err != nil {
+		logger.Error("postgres slot", "err", err)
+		os.Exit(1)
+	}
 	svc := service.DualService{
-		My:  repo.MySQLUserRepo{DB: pair.My},
-		Pg:  repo.PGUserRepo{DB: pair.Pg},
-		Log: logger,
+		Repos: []service.NamedRepo{
+			{Name: "mysql", Repo: myRepo},
+			{Name: "postgres", Repo: pgRepo},
+		},
+		Log:       logger,
+		DualWrite: cfg.DualWrite,
 	}
 
 	if err := svc.Bootstrap(ctx); err != nil {
@@ -70,6 +89,33 @@ func main() {
 		})
 	})
 
+	handlers.Users{Svc: svc, Log: logger}.Register(http.DefaultServeMux)
+
+	// Drift between the slots: GET reports it, POST /drift/repair also
+	// makes the postgres slot match the mysql slot. Repair deletes users,
+	// so it is only served when DRIFT_REPAIR_TOKEN is set, to callers
+	// presenting it.
+	http.HandleFunc("GET /drift", func(w http.ResponseWriter, r *http.Request) {
+		reconcile(w, r, svc, false)
+	})
+	if token := cfg.Reconcile.RepairToken; token != "" {
+		http.HandleFunc("POST /drift/repair", func(w http.ResponseWriter, r *http.Request) {
+			if !bearer(r, token) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="drift-repair"`)
+				writeJSON(w, http.StatusUnauthorized, response{Message: "Drift repair requires the DRIFT_REPAIR_TOKEN bearer token"})
+				return
+			}
+			reconcile(w, r, svc, true)
+		})
+	}
+	http.Handle("GET /metrics", promhttp.Handler())
+
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if cfg.Reconcile.Interval > 0 {
+		go svc.RunReconciler(jobCtx, cfg.Reconcile.Interval, cfg.Reconcile.Repair)
+	}
+
 	// HTTP server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -95,6 +141,7 @@ func main() {
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 	logger.Info("shutting down server...")
+	stopJobs()
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
@@ -107,6 +154,26 @@ func main() {
 	logger.Info("done")
 }
 
+func reconcile(w http.ResponseWriter, r *http.Request, svc service.DualService, repair bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	rep, err := svc.Reconcile(ctx, repair)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, response{
+			Message: "Reconciliation failed",
+			Error:   err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// bearer reports whether r carries token as its bearer credentials.
+func bearer(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
 // writeJSON encodes v to JSON and writes it to w with the given status code
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,115 @@
+// Command migrate manages the schema of the service's databases.
+//
+//	migrate [-store mysql|postgres|all] up|down N|status|redo
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"db-sql-multi/internal/config"
+	"db-sql-multi/internal/db"
+	"db-sql-multi/internal/migrate"
+)
+
+func main() {
+	store := flag.String("store", "all", "database to migrate: mysql, postgres or all")
+	timeout := flag.Duration("timeout", 5*time.Minute, "overall deadline")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: migrate [flags] up|down N|status|redo\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd := args[0]
+	n := 0
+	switch {
+	case cmd == "down" && len(args) == 2:
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			fmt.Fprintln(os.Stderr, "migrate: down needs a positive count")
+			os.Exit(2)
+		}
+	case (cmd == "up" || cmd == "status" || cmd == "redo") && len(args) == 1:
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	cfg := config.Load()
+	targets := map[string]config.DBConfig{"mysql": cfg.MySQL, "postgres": cfg.PG}
+	var names []string
+	switch *store {
+	case "all":
+		names = []string{"mysql", "postgres"}
+	case "mysql", "postgres":
+		names = []string{*store}
+	default:
+		fmt.Fprintf(os.Stderr, "migrate: unknown store %q\n", *store)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	for _, name := range names {
+		if err := run(ctx, name, targets[name], cmd, n, logger); err != nil {
+			logger.Error("migrate failed", "store", name, "err", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func run(ctx context.Context, name string, c config.DBConfig, cmd string, n int, logger *slog.Logger) error {
+	conn, err := db.Connect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	m, err := migrate.New(conn, c.Driver, logger.With("store", name))
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case "up":
+		applied, err := m.Up(ctx)
+		logger.Info("up", "store", name, "applied", applied)
+		return err
+	case "down":
+		reverted, err := m.Down(ctx, n)
+		logger.Info("down", "store", name, "reverted", reverted)
+		return err
+	case "redo":
+		return m.Redo(ctx)
+	}
+	st, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s:\n", name)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range st {
+		at := "-"
+		if !s.AppliedAt.IsZero() {
+			at = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "  %04d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, at)
+	}
+	return w.Flush()
+}
@@ -9,6 +9,7 @@ import (
 
 	_ "github.com/go-sql-driver/mysql" // MySQL
 	_ "github.com/lib/pq"              // PostgreSQL
+	_ "modernc.org/sqlite"             // SQLite
 )
 
 type Pair struct {
@@ -16,31 +17,43 @@ type Pair struct {
 	Pg *sql.DB
 }
 
-func Open(cfg config.AppConfig, logger *slog.Logger) (Pair, error) {
-	open := func(driver, dsn string, maxOpen, maxIdle int, life time.Duration) (*sql.DB, error) {
-		db, err := sql.Open(driver, dsn)
-		if err != nil {
-			return nil, err
-		}
-		db.SetMaxOpenConns(maxOpen)
-		db.SetMaxIdleConns(maxIdle)
-		db.SetConnMaxLifetime(life)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := db.PingContext(ctx); err != nil {
-			return nil, err
-		}
-		return db, nil
+// Connect opens the database c describes, sizes its pool and pings it.
+// SQLite gets a single connection that is never recycled: it allows one
+// writer at a time anyway, and an in-memory database lives only as long
+// as its connection.
+func Connect(c config.DBConfig) (*sql.DB, error) {
+	db, err := sql.Open(c.Driver, c.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if c.Driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+	} else {
+		db.SetMaxOpenConns(c.MaxOpen)
+		db.SetMaxIdleConns(c.MaxIdle)
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
 	}
-	my, err := open(cfg.MySQL.Driver, cfg.MySQL.DSN, cfg.MySQL.MaxOpen, cfg.MySQL.MaxIdle, cfg.MySQL.ConnMaxLifetime)
+	return db, nil
+}
+
+func Open(cfg config.AppConfig, logger *slog.Logger) (Pair, error) {
+	my, err := Connect(cfg.MySQL)
 	if err != nil {
 		return Pair{}, err
 	}
-	pg, err := open(cfg.PG.Driver, cfg.PG.DSN, cfg.PG.MaxOpen, cfg.PG.MaxIdle, cfg.PG.ConnMaxLifetime)
+	pg, err := Connect(cfg.PG)
 	if err != nil {
 		my.Close()
 		return Pair{}, err
 	}
-	logger.Info("databases connected")
+	logger.Info("databases connected", "mysql_slot", cfg.MySQL.Driver, "pg_slot", cfg.PG.Driver)
 	return Pair{My: my, Pg: pg}, nil
 }
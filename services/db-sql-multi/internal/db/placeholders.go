@@ -0,0 +1,23 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DollarPlaceholders numbers the ? placeholders in q as $1, $2, ... for
+// PostgreSQL. Queries passed to it must not contain a literal ?.
+func DollarPlaceholders(q string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
@@ -0,0 +1,381 @@
+// Package handlers serves the /users REST API over the service's repos.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-sql-multi/internal/model"
+	"db-sql-multi/internal/repo"
+	"db-sql-multi/internal/service"
+)
+
+// Page sizes for GET /users.
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// maxBodyBytes bounds request bodies.
+const maxBodyBytes = 1 << 20
+
+// maxField bounds email and name, the width of their columns.
+const maxField = 255
+
+// Users serves /users. Every route takes ?store= naming the repo to use;
+// without it, the primary (the first repo) is used. With Svc.DualWrite,
+// writes that name no store go to every repo through the service's dual
+// writes instead; naming a store still writes that repo alone, which the
+// reconciler then reports as drift.
+type Users struct {
+	Svc service.DualService
+	Log *slog.Logger
+	// Timeout bounds each request's database work; default 10s.
+	Timeout time.Duration
+}
+
+// Register adds the /users routes to mux.
+func (h Users) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /users", h.create)
+	mux.HandleFunc("GET /users", h.list)
+	mux.HandleFunc("GET /users/{id}", h.get)
+	mux.HandleFunc("GET /users/by-email/{email}", h.getByEmail)
+	mux.HandleFunc("PUT /users/{id}", h.update)
+	mux.HandleFunc("DELETE /users/{id}", h.delete)
+}
+
+// errorResponse is the body of every error, shaped like cmd/app's
+// responses.
+type errorResponse struct {
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+type userRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type listResponse struct {
+	Users []model.User `json:"users"`
+	// NextAfterID is the after_id of the next page; absent on the last.
+	NextAfterID int64 `json:"next_after_id,omitempty"`
+}
+
+// target resolves ?store= and bounds the request's context. It writes the
+// error response and returns ok false when the store is unknown.
+func (h Users) target(w http.ResponseWriter, r *http.Request) (_ repo.UserRepo, store string, _ context.Context, _ context.CancelFunc, ok bool) {
+	store = r.URL.Query().Get("store")
+	if store == "" && len(h.Svc.Repos) > 0 {
+		store = h.Svc.Repos[0].Name
+	}
+	ur, found := h.Svc.Repo(store)
+	if !found {
+		names := make([]string, len(h.Svc.Repos))
+		for i, nr := range h.Svc.Repos {
+			names[i] = nr.Name
+		}
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: "Unknown store",
+			Error: fmt.Sprintf("store must be one of %s", strings.Join(names, ", "))})
+		return nil, "", nil, nil, false
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ur, store, ctx, cancel, true
+}
+
+// dual reports whether a write to r goes to every repo.
+func (h Users) dual(r *http.Request) bool {
+	return h.Svc.DualWrite && r.URL.Query().Get("store") == ""
+}
+
+func (h Users) create(w http.ResponseWriter, r *http.Request) {
+	ur, store, ctx, cancel, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	var req userRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	u := model.User{Email: strings.TrimSpace(req.Email), Name: strings.TrimSpace(req.Name)}
+	if msg := validateEmail(u.Email); msg != "" {
+		badRequest(w, msg)
+		return
+	}
+	if msg := validateName(u.Name); msg != "" {
+		badRequest(w, msg)
+		return
+	}
+	var err error
+	if h.dual(r) {
+		u, err = h.Svc.CreateUser(ctx, u.Email, u.Name)
+	} else {
+		err = ur.Create(ctx, &u)
+	}
+	if err != nil {
+		h.fail(ctx, w, r, store, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/users/%d?store=%s", u.ID, store))
+	writeJSON(w, http.StatusCreated, u)
+}
+
+func (h Users) list(w http.ResponseWriter, r *http.Request) {
+	ur, store, ctx, cancel, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	q := r.URL.Query()
+	opts := repo.ListOptions{Limit: defaultLimit, Email: q.Get("email"), NameContains: q.Get("name")}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			badRequest(w, fmt.Sprintf("limit must be an integer from 1 to %d", maxLimit))
+			return
+		}
+		opts.Limit = n
+	}
+	if v := q.Get("after_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			badRequest(w, "after_id must be a non-negative integer")
+			return
+		}
+		opts.AfterID = n
+	}
+	users, err := ur.List(ctx, opts)
+	if err != nil {
+		h.fail(ctx, w, r, store, err)
+		return
+	}
+	resp := listResponse{Users: users}
+	if resp.Users == nil {
+		resp.Users = []model.User{}
+	}
+	if len(users) == opts.Limit {
+		resp.NextAfterID = users[len(users)-1].ID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h Users) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	ur, store, ctx, cancel, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	u, err := ur.GetByID(ctx, id)
+	if err != nil {
+		h.fail(ctx, w, r, store, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h Users) getByEmail(w http.ResponseWriter, r *http.Request) {
+	ur, store, ctx, cancel, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	u, err := ur.GetByEmail(ctx, r.PathValue("email"))
+	if err != nil {
+		h.fail(ctx, w, r, store, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// update changes a user's name; the email cannot change, so a body naming
+// another email is rejected.
+func (h Users) update(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	ur, store, ctx, cancel, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	var req userRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if msg := validateName(name); msg != "" {
+		badRequest(w, msg)
+		return
+	}
+	if h.dual(r) {
+		// IDs differ between repos: the primary's ID finds the user, and
+		// the dual write matches it everywhere by email.
+		u, err := ur.GetByID(ctx, id)
+		if err == nil && req.Email != "" && strings.TrimSpace(req.Email) != u.Email {
+			err = errEmailChange
+		}
+		if err == nil {
+			err = h.Svc.RenameUser(ctx, u.Email, name)
+			if errors.Is(err, sql.ErrNoRows) {
+				// The primary has the user, so another repo lacks it.
+				err = fmt.Errorf("%w: %v", errDrifted, err)
+			}
+		}
+		if err != nil {
+			h.fail(ctx, w, r, store, err)
+			return
+		}
+		u.Name = name
+		writeJSON(w, http.StatusOK, u)
+		return
+	}
+	u, err := repo.InTx(ctx, ur, func(tx repo.UserRepo) (model.User, error) {
+		u, err := tx.GetByID(ctx, id)
+		if err != nil {
+			return u, err
+		}
+		if req.Email != "" && strings.TrimSpace(req.Email) != u.Email {
+			return u, errEmailChange
+		}
+		if err := tx.UpdateName(ctx, id, name); err != nil {
+			return u, err
+		}
+		u.Name = name
+		return u, nil
+	})
+	if err != nil {
+		h.fail(ctx, w, r, store, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h Users) delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	ur, store, ctx, cancel, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	var err error
+	if h.dual(r) {
+		var u model.User
+		if u, err = ur.GetByID(ctx, id); err == nil {
+			err = h.Svc.DeleteUser(ctx, u.Email)
+		}
+	} else {
+		err = ur.Delete(ctx, id)
+	}
+	if err != nil {
+		h.fail(ctx, w, r, store, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errEmailChange = errors.New("email cannot be changed")
+
+// errDrifted marks a dual write that found the user in the primary but
+// not in every other repo; reconciliation has to bring them back in line.
+var errDrifted = errors.New("user is missing from another store; reconcile the stores and retry")
+
+// fail maps a repo error to a response. When the client has gone away
+// nothing is written; when the request ran out of time it gets 504.
+// Context state is checked rather than err, because drivers report
+// cancellation with errors of their own.
+func (h Users) fail(ctx context.Context, w http.ResponseWriter, r *http.Request, store string, err error) {
+	switch {
+	case r.Context().Err() != nil:
+		h.Log.Info("client went away", "path", r.URL.Path, "store", store, "err", err)
+	case errors.Is(err, errDrifted):
+		h.Log.Warn("users request found drift", "path", r.URL.Path, "store", store, "err", err)
+		writeJSON(w, http.StatusConflict, errorResponse{Message: "Stores disagree", Error: err.Error()})
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, errorResponse{Message: "User not found"})
+	case errors.Is(err, repo.ErrDuplicateEmail):
+		writeJSON(w, http.StatusConflict, errorResponse{Message: "Email already in use"})
+	case errors.Is(err, errEmailChange):
+		badRequest(w, err.Error())
+	case ctx.Err() != nil:
+		h.Log.Warn("users request timed out", "path", r.URL.Path, "store", store, "err", err)
+		writeJSON(w, http.StatusGatewayTimeout, errorResponse{Message: "Database operation timed out"})
+	default:
+		h.Log.Error("users request failed", "path", r.URL.Path, "store", store, "err", err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Message: "Database operation failed", Error: err.Error()})
+	}
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		badRequest(w, "id must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// decode reads a JSON body into v, rejecting unknown fields and trailing
+// data.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must hold a single JSON object")
+	}
+	if err != nil {
+		badRequest(w, "malformed body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func validateEmail(email string) string {
+	if email == "" || len(email) > maxField {
+		return fmt.Sprintf("email is required and at most %d bytes", maxField)
+	}
+	if a, err := mail.ParseAddress(email); err != nil || a.Address != email {
+		return "email must be a plain address such as name@example.com"
+	}
+	return ""
+}
+
+func validateName(name string) string {
+	if name == "" || len(name) > maxField {
+		return fmt.Sprintf("name is required and at most %d bytes", maxField)
+	}
+	return ""
+}
+
+func badRequest(w http.ResponseWriter, msg string) {
+	writeJSON(w, http.StatusBadRequest, errorResponse{Message: "Invalid request", Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
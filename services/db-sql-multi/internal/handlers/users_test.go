@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"db-sql-multi/internal/config"
+	"db-sql-multi/internal/db"
+	"db-sql-multi/internal/model"
+	"db-sql-multi/internal/repo"
+	"db-sql-multi/internal/service"
+)
+
+// server serves Users over two fresh SQLite repos named like cmd/app's
+// slots, mysql (the primary) and postgres.
+type server struct {
+	t   *testing.T
+	mux *http.ServeMux
+}
+
+func newServer(t *testing.T, dualWrite bool) *server {
+	t.Helper()
+	var repos []service.NamedRepo
+	for _, name := range []string{"mysql", "postgres"} {
+		conn, err := db.Connect(config.DBConfig{Driver: "sqlite", DSN: config.SQLiteMemoryDSN(t.Name() + "_" + name)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		repos = append(repos, service.NamedRepo{Name: name, Repo: repo.NewSQLite(conn)})
+	}
+	svc := service.DualService{Repos: repos, Log: slog.New(slog.DiscardHandler), DualWrite: dualWrite}
+	if err := svc.Bootstrap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	Users{Svc: svc, Log: svc.Log}.Register(mux)
+	return &server{t: t, mux: mux}
+}
+
+// do sends a request and decodes a JSON response into out, if given.
+func (s *server) do(method, target, body string, out any) *httptest.ResponseRecorder {
+	s.t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if out != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decode %q: %v", method, target, rec.Body.Bytes(), err)
+		}
+	}
+	return rec
+}
+
+func (s *server) create(target, email, name string) model.User {
+	s.t.Helper()
+	var u model.User
+	if rec := s.do("POST", target, fmt.Sprintf(`{"email":%q,"name":%q}`, email, name), &u); rec.Code != http.StatusCreated {
+		s.t.Fatalf("POST %s %s: status %d: %s", target, email, rec.Code, rec.Body.Bytes())
+	}
+	return u
+}
+
+// users lists every user of store by email, as names.
+func (s *server) users(store string) map[string]string {
+	s.t.Helper()
+	var resp listResponse
+	if rec := s.do("GET", "/users?limit=100&store="+store, "", &resp); rec.Code != http.StatusOK {
+		s.t.Fatalf("GET /users?store=%s: status %d", store, rec.Code)
+	}
+	out := make(map[string]string)
+	for _, u := range resp.Users {
+		out[u.Email] = u.Name
+	}
+	return out
+}
+
+func TestUsersStatus(t *testing.T) {
+	s := newServer(t, false)
+	ann := s.create("/users", "ann@example.com", "Ann")
+	id := fmt.Sprint("/users/", ann.ID)
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{"GET", id, "", http.StatusOK},
+		{"GET", "/users/by-email/ann@example.com", "", http.StatusOK},
+		{"GET", "/users/999", "", http.StatusNotFound},
+		{"GET", "/users/by-email/nobody@example.com", "", http.StatusNotFound},
+		{"PUT", "/users/999", `{"name":"X"}`, http.StatusNotFound},
+		{"DELETE", "/users/999", "", http.StatusNotFound},
+		{"GET", "/users/0", "", http.StatusBadRequest},
+		{"GET", "/users/x", "", http.StatusBadRequest},
+		{"POST", "/users", `{"email":"ann@example.com","name":"Other"}`, http.StatusConflict},
+		{"POST", "/users", `{"email":"Ann <ann2@example.com>","name":"Ann"}`, http.StatusBadRequest},
+		{"POST", "/users", `{"email":"ann2@example.com","name":" "}`, http.StatusBadRequest},
+		{"POST", "/users", `{"email":"ann2@example.com","name":"Ann","age":3}`, http.StatusBadRequest},
+		{"POST", "/users", `{"email":"ann2@example.com","name":"Ann"} {}`, http.StatusBadRequest},
+		{"PUT", id, `{"email":"other@example.com","name":"Ann"}`, http.StatusBadRequest},
+		{"PUT", id, `{"email":"ann@example.com","name":"Anna"}`, http.StatusOK},
+		{"GET", "/users?store=oracle", "", http.StatusBadRequest},
+		{"GET", "/users?limit=0", "", http.StatusBadRequest},
+		{"GET", "/users?limit=101", "", http.StatusBadRequest},
+		{"GET", "/users?after_id=-1", "", http.StatusBadRequest},
+		{"DELETE", id, "", http.StatusNoContent},
+		{"DELETE", id, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := s.do(tt.method, tt.target, tt.body, nil)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s %s: status %d, want %d: %s", tt.method, tt.target, tt.body, rec.Code, tt.want, rec.Body.Bytes())
+		}
+		if ct := rec.Header().Get("Content-Type"); rec.Code != http.StatusNoContent && ct != "application/json" {
+			t.Errorf("%s %s: Content-Type %q", tt.method, tt.target, ct)
+		}
+	}
+}
+
+func TestUsersStore(t *testing.T) {
+	s := newServer(t, false)
+	var u model.User
+	rec := s.do("POST", "/users?store=postgres", `{"email":"ann@example.com","name":"Ann"}`, &u)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST: status %d", rec.Code)
+	}
+	if loc, want := rec.Header().Get("Location"), fmt.Sprintf("/users/%d?store=postgres", u.ID); loc != want {
+		t.Errorf("Location %q, want %q", loc, want)
+	}
+	if got := s.users("postgres"); got["ann@example.com"] != "Ann" {
+		t.Errorf("postgres users = %v, want Ann", got)
+	}
+	if got := s.users("mysql"); len(got) != 0 {
+		t.Errorf("mysql users = %v, want none", got)
+	}
+	// Without ?store= the primary, mysql, is used.
+	if rec := s.do("GET", fmt.Sprint("/users/", u.ID), "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without store: status %d, want 404 from mysql", rec.Code)
+	}
+	// The same email may exist once per store.
+	s.create("/users", "ann@example.com", "Ann")
+}
+
+func TestUsersList(t *testing.T) {
+	s := newServer(t, false)
+	var created []model.User
+	for i, name := range []string{"Ann Lee", "anna Bell", "Bob", "Cy", "Dee"} {
+		created = append(created, s.create("/users", fmt.Sprintf("u%d@example.com", i), name))
+	}
+
+	// Pages follow next_after_id, meet every user once, and the last
+	// page has none.
+	var seen []int64
+	target := "/users?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > len(created) {
+			t.Fatal("pagination does not end")
+		}
+		var resp listResponse
+		if rec := s.do("GET", target, "", &resp); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", target, rec.Code)
+		}
+		for _, u := range resp.Users {
+			seen = append(seen, u.ID)
+		}
+		if resp.NextAfterID == 0 {
+			break
+		}
+		target = fmt.Sprint("/users?limit=2&after_id=", resp.NextAfterID)
+	}
+	if len(seen) != len(created) {
+		t.Fatalf("pages listed %v, want %d users", seen, len(created))
+	}
+	for i, u := range created {
+		if seen[i] != u.ID {
+			t.Errorf("page order %v, want the IDs in creation order", seen)
+			break
+		}
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"email=u2@example.com", []string{"Bob"}},
+		{"name=ANN", []string{"Ann Lee", "anna Bell"}},
+		{"name=ann&email=u0@example.com", []string{"Ann Lee"}},
+		{"name=nobody", []string{}},
+		{fmt.Sprint("after_id=", created[3].ID), []string{"Dee"}},
+	} {
+		var resp listResponse
+		if rec := s.do("GET", "/users?"+tt.query, "", &resp); rec.Code != http.StatusOK {
+			t.Fatalf("GET ?%s: status %d", tt.query, rec.Code)
+		}
+		got := []string{}
+		for _, u := range resp.Users {
+			got = append(got, u.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("GET ?%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestUsersDualWrite(t *testing.T) {
+	s := newServer(t, true)
+	ann := s.create("/users", "ann@example.com", "Ann")
+	both := func(want map[string]string) {
+		t.Helper()
+		for _, store := range []string{"mysql", "postgres"} {
+			if got := s.users(store); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s users = %v, want %v", store, got, want)
+			}
+		}
+	}
+	both(map[string]string{"ann@example.com": "Ann"})
+
+	if rec := s.do("POST", "/users", `{"email":"ann@example.com","name":"Ann"}`, nil); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate POST: status %d, want 409", rec.Code)
+	}
+	var u model.User
+	if rec := s.do("PUT", fmt.Sprint("/users/", ann.ID), `{"name":"Anna"}`, &u); rec.Code != http.StatusOK || u.Name != "Anna" {
+		t.Errorf("PUT: status %d, user %+v", rec.Code, u)
+	}
+	both(map[string]string{"ann@example.com": "Anna"})
+
+	// Naming a store writes it alone.
+	s.create("/users?store=postgres", "bob@example.com", "Bob")
+	if got := s.users("mysql"); len(got) != 1 {
+		t.Errorf("mysql users = %v after a postgres-only write", got)
+	}
+
+	if rec := s.do("DELETE", fmt.Sprint("/users/", ann.ID), "", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE: status %d", rec.Code)
+	}
+	if got := s.users("mysql"); len(got) != 0 {
+		t.Errorf("mysql users = %v after DELETE", got)
+	}
+	if got := s.users("postgres"); len(got) != 1 || got["bob@example.com"] == "" {
+		t.Errorf("postgres users = %v after DELETE, want only Bob", got)
+	}
+	if rec := s.do("DELETE", fmt.Sprint("/users/", ann.ID), "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: status %d, want 404", rec.Code)
+	}
+}
+
+func TestUsersDualRenameDrifted(t *testing.T) {
+	s := newServer(t, true)
+	cy := s.create("/users?store=mysql", "cy@example.com", "Cy")
+	rec := s.do("PUT", fmt.Sprint("/users/", cy.ID), `{"name":"Cyrus"}`, nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("PUT of a user postgres lacks: status %d, want 409: %s", rec.Code, rec.Body.Bytes())
+	}
+	var body errorResponse
+	if err := json.NewDecoder(bytes.NewReader(rec.Body.Bytes())).Decode(&body); err != nil || body.Message != "Stores disagree" {
+		t.Errorf("body %+v, %v", body, err)
+	}
+	if got := s.users("mysql"); got["cy@example.com"] != "Cy" {
+		t.Errorf("mysql users = %v, want the rename undone", got)
+	}
+}
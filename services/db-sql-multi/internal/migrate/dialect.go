@@ -0,0 +1,95 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"db-sql-multi/internal/db"
+)
+
+// dialect holds the per-database parts of migrating: where its scripts
+// live, the bookkeeping table and the session lock.
+type dialect struct {
+	dir   string
+	table string
+	bind  func(query string) string
+	// lock takes the migration lock for conn's session, waiting at most
+	// timeout; unlock releases it.
+	lock   func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error
+	unlock func(ctx context.Context, conn *sql.Conn) error
+}
+
+var dialects = map[string]*dialect{
+	"mysql": {
+		dir: "mysql",
+		table: `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  checksum CHAR(64) NOT NULL,
+  applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+		bind: func(q string) string { return q },
+		lock: func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+			// GET_LOCK names are server-wide, so include the database.
+			var got sql.NullInt64
+			err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(CONCAT(DATABASE(), '.schema_migrations'), ?)`,
+				int(timeout.Seconds())).Scan(&got)
+			if err != nil {
+				return err
+			}
+			if got.Int64 != 1 {
+				return errors.New("timed out waiting for another migration")
+			}
+			return nil
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `DO RELEASE_LOCK(CONCAT(DATABASE(), '.schema_migrations'))`)
+			return err
+		},
+	},
+	"postgres": {
+		dir: "postgres",
+		table: `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`,
+		bind: db.DollarPlaceholders,
+		lock: func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, pgLockKey)
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return errors.New("timed out waiting for another migration")
+			}
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, pgLockKey)
+			return err
+		},
+	},
+	"sqlite": {
+		dir: "sqlite",
+		table: `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+		bind: func(q string) string { return q },
+		// SQLite has no session locks. Its DDL is transactional, so of two
+		// processes applying the same version one fails on the
+		// schema_migrations key and rolls back; db.Connect limits a process
+		// to one connection.
+		lock:   func(context.Context, *sql.Conn, time.Duration) error { return nil },
+		unlock: func(context.Context, *sql.Conn) error { return nil },
+	},
+}
+
+// pgLockKey is the advisory lock key migrations hold; advisory locks are
+// scoped to the current database.
+const pgLockKey int64 = 0x6462_7371_6c6d_6967 // "dbsqlmig"
@@ -0,0 +1,360 @@
+// Package migrate applies versioned schema migrations. Applied versions
+// are recorded in schema_migrations with a checksum of their up script, so
+// a migration edited after it ran is caught instead of drifting silently,
+// and a database lock keeps concurrent instances from migrating at once.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-sql-multi/migrations"
+)
+
+// ErrChecksum is returned when an applied migration's script has changed
+// since it ran.
+var ErrChecksum = errors.New("migrate: checksum mismatch")
+
+// Migration is one version's up and down scripts.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up, Down string
+	// Checksum is the hex SHA-256 of Up.
+	Checksum string
+}
+
+// Status is the state of one version: "applied", "pending", "modified"
+// (applied, but the script changed since) or "missing" (applied, but no
+// script exists, for example after a rollback to an older build).
+type Status struct {
+	Version   int64
+	Name      string
+	State     string
+	AppliedAt time.Time
+}
+
+// Migrator applies one dialect's migrations to a database.
+type Migrator struct {
+	db     *sql.DB
+	d      *dialect
+	ms     []Migration
+	logger *slog.Logger
+	// LockTimeout bounds the wait for another instance's migration to
+	// finish; default 1 minute.
+	LockTimeout time.Duration
+}
+
+// New returns a Migrator for db using the embedded migrations of driver:
+// "mysql", "postgres" or "sqlite".
+func New(db *sql.DB, driver string, logger *slog.Logger) (*Migrator, error) {
+	return NewFS(db, driver, migrations.FS, logger)
+}
+
+// NewFS is New with the migrations read from fsys, which holds a directory
+// per dialect.
+func NewFS(db *sql.DB, driver string, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("migrate: unsupported driver %q", driver)
+	}
+	ms, err := Load(fsys, d.dir)
+	if err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Migrator{db: db, d: d, ms: ms, logger: logger.With("dialect", driver), LockTimeout: time.Minute}, nil
+}
+
+// Load reads the migrations in dir of fsys, in version order.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		file := e.Name()
+		base, dirn, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if e.IsDir() || !strings.HasSuffix(file, ".sql") || !ok || (dirn != "up" && dirn != "down") {
+			return nil, fmt.Errorf("migrate: %s: want <version>_<name>.up.sql or .down.sql", file)
+		}
+		v, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migrate: %s: bad version %q", file, v)
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migrate: version %d has two names, %q and %q", version, m.Name, name)
+		}
+		if dirn == "up" {
+			m.Up = string(body)
+			sum := sha256.Sum256(body)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(body)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up script", m.Version)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Up applies every pending migration and returns how many it applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]record) error {
+		var err error
+		n, err = m.up(ctx, conn, applied, len(m.ms))
+		return err
+	})
+	return n, err
+}
+
+// Down reverts the n most recently applied migrations and returns how many
+// it reverted.
+func (m *Migrator) Down(ctx context.Context, n int) (int, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]record) error {
+		var err error
+		done, err = m.down(ctx, conn, applied, n)
+		return err
+	})
+	return len(done), err
+}
+
+// Redo reverts the latest applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int64]record) error {
+		done, err := m.down(ctx, conn, applied, 1)
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			return errors.New("migrate: nothing to redo")
+		}
+		return m.apply(ctx, conn, applied, done[0])
+	})
+}
+
+// Status lists every known version, applied or not, in version order.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.locked(ctx, func(_ *sql.Conn, applied map[int64]record) error {
+		seen := make(map[int64]bool)
+		for _, mg := range m.ms {
+			seen[mg.Version] = true
+			s := Status{Version: mg.Version, Name: mg.Name, State: "pending"}
+			if r, ok := applied[mg.Version]; ok {
+				s.State, s.AppliedAt = "applied", r.appliedAt
+				if r.checksum != mg.Checksum {
+					s.State = "modified"
+				}
+			}
+			out = append(out, s)
+		}
+		for v, r := range applied {
+			if !seen[v] {
+				out = append(out, Status{Version: v, Name: r.name, State: "missing", AppliedAt: r.appliedAt})
+			}
+		}
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, err
+}
+
+// record is a row of schema_migrations.
+type record struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// locked runs fn holding the migration lock, on one connection, with the
+// applied versions read after the lock was taken.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, map[int64]record) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The lock is a session lock, not a row in schema_migrations, so it
+	// can be taken before the table exists; creating the table under it
+	// keeps two first runs from racing on the DDL.
+	if err := m.d.lock(ctx, conn, m.LockTimeout); err != nil {
+		return fmt.Errorf("migrate: lock: %w", err)
+	}
+	defer func() {
+		// The lock belongs to the session; release it even if ctx ended.
+		if err := m.d.unlock(context.WithoutCancel(ctx), conn); err != nil {
+			m.logger.Warn("migrate: unlock", "err", err)
+		}
+	}()
+	if _, err := conn.ExecContext(ctx, m.d.table); err != nil {
+		return fmt.Errorf("migrate: create schema_migrations: %w", err)
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]record, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	defer rows.Close()
+	out := make(map[int64]record)
+	for rows.Next() {
+		var v int64
+		var r record
+		if err := rows.Scan(&v, &r.name, &r.checksum, &r.appliedAt); err != nil {
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+		out[v] = r
+	}
+	return out, rows.Err()
+}
+
+// up applies at most n pending migrations, refusing to start if an applied
+// one has been modified.
+func (m *Migrator) up(ctx context.Context, conn *sql.Conn, applied map[int64]record, n int) (int, error) {
+	for _, mg := range m.ms {
+		if r, ok := applied[mg.Version]; ok && r.checksum != mg.Checksum {
+			return 0, fmt.Errorf("%w: %d_%s changed after it was applied", ErrChecksum, mg.Version, mg.Name)
+		}
+	}
+	done := 0
+	for _, mg := range m.ms {
+		if done == n {
+			break
+		}
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		if err := m.apply(ctx, conn, applied, mg); err != nil {
+			return done, err
+		}
+		done++
+	}
+	return done, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, applied map[int64]record, mg Migration) error {
+	start := time.Now()
+	err := m.run(ctx, conn, mg.Up, m.d.bind(`INSERT INTO schema_migrations (version, name, checksum) VALUES (?,?,?)`),
+		mg.Version, mg.Name, mg.Checksum)
+	if err != nil {
+		return fmt.Errorf("migrate: up %d_%s: %w", mg.Version, mg.Name, err)
+	}
+	applied[mg.Version] = record{name: mg.Name, checksum: mg.Checksum, appliedAt: time.Now()}
+	m.logger.Info("migration applied", "version", mg.Version, "name", mg.Name, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// down reverts at most n applied migrations, newest first, and returns
+// them. It refuses to revert past versions it has no script for.
+func (m *Migrator) down(ctx context.Context, conn *sql.Conn, applied map[int64]record, n int) ([]Migration, error) {
+	known := make(map[int64]bool, len(m.ms))
+	for _, mg := range m.ms {
+		known[mg.Version] = true
+	}
+	for v, r := range applied {
+		if !known[v] {
+			return nil, fmt.Errorf("migrate: %d_%s is applied but unknown to this build", v, r.name)
+		}
+	}
+	var done []Migration
+	for i := len(m.ms) - 1; i >= 0 && len(done) < n; i-- {
+		mg := m.ms[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		if mg.Down == "" {
+			return done, fmt.Errorf("migrate: %d_%s has no down script", mg.Version, mg.Name)
+		}
+		err := m.run(ctx, conn, mg.Down, m.d.bind(`DELETE FROM schema_migrations WHERE version=?`), mg.Version)
+		if err != nil {
+			return done, fmt.Errorf("migrate: down %d_%s: %w", mg.Version, mg.Name, err)
+		}
+		delete(applied, mg.Version)
+		done = append(done, mg)
+		m.logger.Info("migration reverted", "version", mg.Version, "name", mg.Name)
+	}
+	return done, nil
+}
+
+// run executes script and the bookkeeping statement in one transaction.
+// MySQL commits DDL implicitly, so there a script that fails halfway has
+// to be cleaned up by hand; keep its migrations to one DDL statement.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range Statements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Statements splits script at semicolons that end a line and drops
+// comment-only lines and empty statements.
+func Statements(script string) []string {
+	var out []string
+	var cur strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteByte('\n')
+		if strings.HasSuffix(trimmed, ";") {
+			if stmt := strings.TrimSuffix(strings.TrimSpace(cur.String()), ";"); stmt != "" {
+				out = append(out, stmt)
+			}
+			cur.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+		out = append(out, stmt)
+	}
+	return out
+}
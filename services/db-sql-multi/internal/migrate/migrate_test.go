@@ -0,0 +1,229 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"db-sql-multi/internal/config"
+	"db-sql-multi/internal/db"
+	"db-sql-multi/internal/migrate"
+)
+
+// TestUpDownSQLite applies every migration, reverts them all and applies
+// them again: each down script must undo its up script completely.
+func TestUpDownSQLite(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.Connect(config.DBConfig{Driver: "sqlite", DSN: config.SQLiteMemoryDSN("migratetest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	m, err := migrate.New(conn, "sqlite", slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if n, err := m.Up(ctx); err != nil || n != len(all) {
+			t.Fatalf("Up applied %d, %v; want %d", n, err, len(all))
+		}
+		if _, err := conn.ExecContext(ctx, `INSERT INTO orders (user_id, amount) VALUES (NULL, 1)`); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := m.Down(ctx, len(all)); err != nil || n != len(all) {
+			t.Fatalf("Down reverted %d, %v; want %d", n, err, len(all))
+		}
+		var tables int
+		if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name IN ('users','orders')`).Scan(&tables); err != nil {
+			t.Fatal(err)
+		}
+		if tables != 0 {
+			t.Fatalf("%d tables left after reverting every migration", tables)
+		}
+	}
+}
+
+// sqliteFS is a migrations tree for the sqlite dialect, by file name.
+func sqliteFS(files map[string]string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, body := range files {
+		fsys["sqlite/"+name] = &fstest.MapFile{Data: []byte(body)}
+	}
+	return fsys
+}
+
+func newMigrator(t *testing.T, conn *sql.DB, fsys fs.FS) *migrate.Migrator {
+	t.Helper()
+	m, err := migrate.NewFS(conn, "sqlite", fsys, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := db.Connect(config.DBConfig{Driver: "sqlite", DSN: config.SQLiteMemoryDSN(t.Name())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func states(t *testing.T, m *migrate.Migrator) map[int64]string {
+	t.Helper()
+	st, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[int64]string, len(st))
+	for _, s := range st {
+		out[s.Version] = s.State
+	}
+	return out
+}
+
+var twoVersions = map[string]string{
+	"1_notes.up.sql":   "CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL);",
+	"1_notes.down.sql": "DROP TABLE notes;",
+	"2_tags.up.sql":    "CREATE TABLE tags (name TEXT PRIMARY KEY);",
+	"2_tags.down.sql":  "DROP TABLE tags;",
+}
+
+func TestChecksum(t *testing.T) {
+	ctx := context.Background()
+	conn := openSQLite(t)
+	if _, err := newMigrator(t, conn, sqliteFS(twoVersions)).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := maps.Clone(twoVersions)
+	edited["1_notes.up.sql"] = "CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);"
+	edited["3_links.up.sql"] = "CREATE TABLE links (url TEXT);"
+	m := newMigrator(t, conn, sqliteFS(edited))
+	if n, err := m.Up(ctx); !errors.Is(err, migrate.ErrChecksum) || n != 0 {
+		t.Fatalf("Up over an edited migration = %d, %v; want 0, ErrChecksum", n, err)
+	}
+	want := map[int64]string{1: "modified", 2: "applied", 3: "pending"}
+	if got := states(t, m); !maps.Equal(got, want) {
+		t.Errorf("Status = %v, want %v", got, want)
+	}
+}
+
+func TestStatusMissing(t *testing.T) {
+	ctx := context.Background()
+	conn := openSQLite(t)
+	if _, err := newMigrator(t, conn, sqliteFS(twoVersions)).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// An older build knows only version 1.
+	older := maps.Clone(twoVersions)
+	delete(older, "2_tags.up.sql")
+	delete(older, "2_tags.down.sql")
+	m := newMigrator(t, conn, sqliteFS(older))
+	want := map[int64]string{1: "applied", 2: "missing"}
+	if got := states(t, m); !maps.Equal(got, want) {
+		t.Errorf("Status = %v, want %v", got, want)
+	}
+	if n, err := m.Down(ctx, 1); err == nil || n != 0 {
+		t.Errorf("Down past an unknown version = %d, %v; want a refusal", n, err)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Errorf("Up = %d, %v; want nothing to do", n, err)
+	}
+}
+
+func TestRedo(t *testing.T) {
+	ctx := context.Background()
+	conn := openSQLite(t)
+	m := newMigrator(t, conn, sqliteFS(twoVersions))
+	if err := m.Redo(ctx); err == nil {
+		t.Error("Redo with nothing applied succeeded")
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, `INSERT INTO tags (name) VALUES ('go')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The latest version was dropped and created again, empty; the one
+	// before it was left alone.
+	var tags int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM tags`).Scan(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if tags != 0 {
+		t.Errorf("%d tags survived Redo, want 0", tags)
+	}
+	want := map[int64]string{1: "applied", 2: "applied"}
+	if got := states(t, m); !maps.Equal(got, want) {
+		t.Errorf("Status = %v, want %v", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string // error substring; "" for none
+	}{
+		{"ok", twoVersions, ""},
+		{"not sql", map[string]string{"1_a.up.txt": ""}, "want <version>_<name>.up.sql"},
+		{"no direction", map[string]string{"1_a.sql": ""}, "want <version>_<name>.up.sql"},
+		{"bad direction", map[string]string{"1_a.sideways.sql": ""}, "want <version>_<name>.up.sql"},
+		{"subdirectory", map[string]string{"old/1_a.up.sql": ""}, "want <version>_<name>.up.sql"},
+		{"version not a number", map[string]string{"v1_a.up.sql": ""}, `bad version "v1"`},
+		{"version zero", map[string]string{"0_a.up.sql": ""}, `bad version "0"`},
+		{"two names", map[string]string{"1_a.up.sql": "", "1_b.down.sql": ""}, "two names"},
+		{"down only", map[string]string{"1_a.down.sql": ""}, "no up script"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, err := migrate.Load(sqliteFS(tt.files), "sqlite")
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ms) != 2 || ms[0].Version != 1 || ms[1].Name != "tags" || ms[0].Down == "" {
+				t.Errorf("Load() = %+v", ms)
+			}
+		})
+	}
+}
+
+func TestLoadOrder(t *testing.T) {
+	ms, err := migrate.Load(sqliteFS(map[string]string{
+		"10_c.up.sql": "", "2_b.up.sql": "", "1_a.up.sql": "",
+	}), "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, m := range ms {
+		got = append(got, m.Version)
+	}
+	if !slices.Equal(got, []int64{1, 2, 10}) {
+		t.Errorf("versions %v, want numeric order", got)
+	}
+}
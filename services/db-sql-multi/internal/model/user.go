@@ -3,8 +3,8 @@ package model
 import "time"
 
 type User struct {
-	ID        int64
-	Email     string
-	Name      string
-	CreatedAt time.Time
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,102 @@
+// Package repo stores users in SQL databases. Every backend implements
+// UserRepo and must pass repotest.TestUserRepo.
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"db-sql-multi/internal/model"
+)
+
+// ErrDuplicateEmail is returned by Create when another user has the email.
+// Lookups of a missing user return sql.ErrNoRows.
+var ErrDuplicateEmail = errors.New("repo: email already in use")
+
+// UserRepo is a store of users.
+type UserRepo interface {
+	// Migrate brings the schema up to date.
+	Migrate(ctx context.Context) error
+	// Create inserts u and sets its ID and CreatedAt.
+	Create(ctx context.Context, u *model.User) error
+	GetByEmail(ctx context.Context, email string) (model.User, error)
+	GetByID(ctx context.Context, id int64) (model.User, error)
+	// List returns users in ID order.
+	List(ctx context.Context, opts ListOptions) ([]model.User, error)
+	// UpdateName, AppendSuffix and Delete return sql.ErrNoRows when no
+	// user has the ID.
+	UpdateName(ctx context.Context, id int64, name string) error
+	// AppendSuffix appends suffix to the user's name in a single
+	// statement, so concurrent appends are not lost.
+	AppendSuffix(ctx context.Context, id int64, suffix string) error
+	Delete(ctx context.Context, id int64) error
+	// WithTx runs fn in a transaction, passing a UserRepo bound to it. The
+	// transaction commits if fn returns nil and rolls back otherwise.
+	// Calling WithTx on a bound repo runs fn in the same transaction.
+	WithTx(ctx context.Context, fn func(tx UserRepo) error) error
+}
+
+// New returns the UserRepo for a database opened with driver: "mysql",
+// "postgres" or "sqlite".
+func New(driver string, db *sql.DB) (UserRepo, error) {
+	switch driver {
+	case "mysql":
+		return NewMySQL(db), nil
+	case "postgres":
+		return NewPostgres(db), nil
+	case "sqlite":
+		return NewSQLite(db), nil
+	}
+	return nil, fmt.Errorf("repo: unsupported driver %q", driver)
+}
+
+// ListOptions pages through and filters users; zero values list every
+// user from the start with the default limit.
+type ListOptions struct {
+	// AfterID lists users with a greater ID, for keyset pagination.
+	AfterID int64
+	// Limit caps the users returned; default 100.
+	Limit int
+	// Email keeps the user with exactly this email.
+	Email string
+	// NameContains keeps users whose name contains it, ignoring ASCII
+	// case.
+	NameContains string
+}
+
+// InTx runs fn in a transaction on r, like UserRepo.WithTx, and returns
+// its result.
+func InTx[T any](ctx context.Context, r UserRepo, fn func(tx UserRepo) (T, error)) (T, error) {
+	var out T
+	err := r.WithTx(ctx, func(tx UserRepo) error {
+		v, err := fn(tx)
+		if err != nil {
+			return err
+		}
+		out = v
+		return nil
+	})
+	return out, err
+}
+
+// Suffix is one name change made by AppendSuffixes.
+type Suffix struct {
+	ID     int64
+	Suffix string
+}
+
+// AppendSuffixes appends each suffix to its user's name in one
+// transaction: either every name changes or none does. It replaces the
+// per-dialect TxTransfer and TxSwapSuffix demos.
+func AppendSuffixes(ctx context.Context, r UserRepo, suffixes ...Suffix) error {
+	return r.WithTx(ctx, func(tx UserRepo) error {
+		for _, s := range suffixes {
+			if err := tx.AppendSuffix(ctx, s.ID, s.Suffix); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,53 @@
+package repo_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"db-sql-multi/internal/config"
+	"db-sql-multi/internal/db"
+	"db-sql-multi/internal/repo"
+	"db-sql-multi/internal/repo/repotest"
+)
+
+// MYSQL_DSN and PG_DSN, read as by the app, add those databases to the
+// suite. It only touches the users it creates, so a development database
+// will do.
+
+func TestSQLiteRepo(t *testing.T) {
+	testRepo(t, config.DBConfig{Driver: "sqlite", DSN: config.SQLiteMemoryDSN("repotest")})
+}
+
+func TestMySQLRepo(t *testing.T) {
+	if os.Getenv("MYSQL_DSN") == "" {
+		t.Skip("MYSQL_DSN not set")
+	}
+	testRepo(t, config.Load().MySQL)
+}
+
+func TestPostgresRepo(t *testing.T) {
+	if os.Getenv("PG_DSN") == "" {
+		t.Skip("PG_DSN not set")
+	}
+	testRepo(t, config.Load().PG)
+}
+
+func testRepo(t *testing.T, cfg config.DBConfig) {
+	t.Helper()
+	conn, err := db.Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	r, err := repo.New(cfg.Driver, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := repotest.TestUserRepo(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+}
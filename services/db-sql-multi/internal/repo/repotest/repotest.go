@@ -0,0 +1,393 @@
+// Package repotest implements a conformance suite for repo.UserRepo
+// implementations, in the spirit of testing/fstest.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"db-sql-multi/internal/model"
+	"db-sql-multi/internal/repo"
+)
+
+// TestUserRepo exercises r and reports the first behaviour that deviates
+// from the UserRepo contract. It migrates r, works only on users it
+// creates, and deletes them again, so it can run against a shared
+// database; nothing else should write to the users table meanwhile.
+func TestUserRepo(ctx context.Context, r repo.UserRepo) error {
+	checks := []struct {
+		name string
+		fn   func(context.Context, repo.UserRepo, *fixture) error
+	}{
+		{"migrate", testMigrate},
+		{"create and get", testCreateGet},
+		{"duplicate email", testDuplicate},
+		{"list", testList},
+		{"list filters", testListFilters},
+		{"update name", testUpdateName},
+		{"delete", testDelete},
+		{"not found", testNotFound},
+		{"transaction commit", testTxCommit},
+		{"transaction rollback", testTxRollback},
+		{"append suffixes", testAppendSuffixes},
+		{"append suffixes atomic", testAppendSuffixesAtomic},
+	}
+	for _, c := range checks {
+		f := &fixture{run: time.Now().UnixNano()}
+		err := c.fn(ctx, r, f)
+		if cerr := f.cleanup(ctx, r); err == nil && cerr != nil {
+			return fmt.Errorf("repotest: %s: cleanup: %w", c.name, cerr)
+		}
+		if err != nil {
+			return fmt.Errorf("repotest: %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// fixture tracks the users a check created.
+type fixture struct {
+	run int64
+	n   int
+	ids []int64
+}
+
+func (f *fixture) email(name string) string {
+	f.n++
+	return fmt.Sprintf("repotest_%d_%d_%s@example.com", f.run, f.n, strings.ToLower(name))
+}
+
+func (f *fixture) create(ctx context.Context, r repo.UserRepo, name string) (model.User, error) {
+	u := model.User{Email: f.email(name), Name: name}
+	if err := r.Create(ctx, &u); err != nil {
+		return model.User{}, fmt.Errorf("create %s: %w", name, err)
+	}
+	f.ids = append(f.ids, u.ID)
+	return u, nil
+}
+
+func (f *fixture) cleanup(ctx context.Context, r repo.UserRepo) error {
+	for _, id := range f.ids {
+		if err := r.Delete(ctx, id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameUser(a, b model.User) bool {
+	return a.ID == b.ID && a.Email == b.Email && a.Name == b.Name &&
+		a.CreatedAt.Truncate(time.Second).Equal(b.CreatedAt.Truncate(time.Second))
+}
+
+func testMigrate(ctx context.Context, r repo.UserRepo, _ *fixture) error {
+	for range 2 {
+		if err := r.Migrate(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testCreateGet(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	u, err := f.create(ctx, r, "Alice")
+	if err != nil {
+		return err
+	}
+	if u.ID == 0 || u.CreatedAt.IsZero() {
+		return fmt.Errorf("Create set ID %d, CreatedAt %v", u.ID, u.CreatedAt)
+	}
+	byID, err := r.GetByID(ctx, u.ID)
+	if err != nil {
+		return fmt.Errorf("GetByID: %w", err)
+	}
+	byEmail, err := r.GetByEmail(ctx, u.Email)
+	if err != nil {
+		return fmt.Errorf("GetByEmail: %w", err)
+	}
+	if !sameUser(byID, u) || !sameUser(byEmail, u) {
+		return fmt.Errorf("created %+v, GetByID %+v, GetByEmail %+v", u, byID, byEmail)
+	}
+	v, err := f.create(ctx, r, "Bob")
+	if err != nil {
+		return err
+	}
+	if v.ID <= u.ID {
+		return fmt.Errorf("IDs not increasing: %d then %d", u.ID, v.ID)
+	}
+	return nil
+}
+
+func testDuplicate(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	u, err := f.create(ctx, r, "Alice")
+	if err != nil {
+		return err
+	}
+	dup := model.User{Email: u.Email, Name: "Other"}
+	err = r.Create(ctx, &dup)
+	if dup.ID != 0 {
+		f.ids = append(f.ids, dup.ID)
+	}
+	if !errors.Is(err, repo.ErrDuplicateEmail) {
+		return fmt.Errorf("second Create with %s: got %v, want ErrDuplicateEmail", u.Email, err)
+	}
+	got, err := r.GetByEmail(ctx, u.Email)
+	if err != nil || got.Name != u.Name {
+		return fmt.Errorf("after duplicate: got %+v, %v; want the first user", got, err)
+	}
+	return nil
+}
+
+func testList(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	var want []model.User
+	for _, name := range []string{"A", "B", "C", "D", "E"} {
+		u, err := f.create(ctx, r, name)
+		if err != nil {
+			return err
+		}
+		want = append(want, u)
+	}
+	var got []model.User
+	after := want[0].ID - 1
+	for page := 0; ; page++ {
+		if page > len(want) {
+			return fmt.Errorf("pagination does not end")
+		}
+		us, err := r.List(ctx, repo.ListOptions{AfterID: after, Limit: 2})
+		if err != nil {
+			return err
+		}
+		if len(us) > 2 {
+			return fmt.Errorf("Limit 2 returned %d users", len(us))
+		}
+		for _, u := range us {
+			if u.ID <= after {
+				return fmt.Errorf("AfterID %d returned ID %d", after, u.ID)
+			}
+			after = u.ID
+			if u.ID <= want[len(want)-1].ID {
+				got = append(got, u)
+			}
+		}
+		if len(us) < 2 || after >= want[len(want)-1].ID {
+			break
+		}
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("listed %d of the %d users created", len(got), len(want))
+	}
+	for i := range want {
+		if !sameUser(got[i], want[i]) {
+			return fmt.Errorf("user %d: listed %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	return nil
+}
+
+func testListFilters(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	var users []model.User
+	for _, name := range []string{"Ann-%d-x", "ANN-%d-y", "Bob-%d%%"} {
+		u, err := f.create(ctx, r, fmt.Sprintf(name, f.run))
+		if err != nil {
+			return err
+		}
+		users = append(users, u)
+	}
+	cases := []struct {
+		opts repo.ListOptions
+		want []model.User
+	}{
+		{repo.ListOptions{Email: users[1].Email}, users[1:2]},
+		{repo.ListOptions{NameContains: fmt.Sprintf("ann-%d", f.run)}, users[:2]},
+		{repo.ListOptions{NameContains: fmt.Sprintf("%d%%", f.run)}, users[2:]}, // % is literal
+		{repo.ListOptions{NameContains: fmt.Sprintf("%d_", f.run)}, nil},        // so is _
+		{repo.ListOptions{NameContains: fmt.Sprintf("ann-%d", f.run), Email: users[2].Email}, nil},
+		{repo.ListOptions{NameContains: fmt.Sprintf("-%d-", f.run), AfterID: users[0].ID}, users[1:2]},
+	}
+	for _, c := range cases {
+		got, err := r.List(ctx, c.opts)
+		if err != nil {
+			return err
+		}
+		if len(got) != len(c.want) {
+			return fmt.Errorf("List(%+v) returned %d users, want %d", c.opts, len(got), len(c.want))
+		}
+		for i := range got {
+			if !sameUser(got[i], c.want[i]) {
+				return fmt.Errorf("List(%+v)[%d] = %+v, want %+v", c.opts, i, got[i], c.want[i])
+			}
+		}
+	}
+	return nil
+}
+
+func testUpdateName(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	u, err := f.create(ctx, r, "Alice")
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"Alicia", "Alicia"} { // unchanged name is still found
+		if err := r.UpdateName(ctx, u.ID, name); err != nil {
+			return fmt.Errorf("UpdateName(%q): %w", name, err)
+		}
+	}
+	got, err := r.GetByID(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if got.Name != "Alicia" || got.Email != u.Email {
+		return fmt.Errorf("after UpdateName got %+v", got)
+	}
+	return nil
+}
+
+func testDelete(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	u, err := f.create(ctx, r, "Alice")
+	if err != nil {
+		return err
+	}
+	if err := r.Delete(ctx, u.ID); err != nil {
+		return err
+	}
+	if _, err := r.GetByID(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("GetByID after Delete: got %v, want sql.ErrNoRows", err)
+	}
+	if err := r.Delete(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("second Delete: got %v, want sql.ErrNoRows", err)
+	}
+	// The email is free again.
+	_, err = f.create(ctx, r, "Alice")
+	return err
+}
+
+func testNotFound(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	const missing = 1<<62 - 1
+	if _, err := r.GetByID(ctx, missing); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("GetByID: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := r.GetByEmail(ctx, f.email("nobody")); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("GetByEmail: got %v, want sql.ErrNoRows", err)
+	}
+	if err := r.UpdateName(ctx, missing, "x"); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("UpdateName: got %v, want sql.ErrNoRows", err)
+	}
+	if err := r.AppendSuffix(ctx, missing, "x"); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("AppendSuffix: got %v, want sql.ErrNoRows", err)
+	}
+	if err := r.Delete(ctx, missing); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("Delete: got %v, want sql.ErrNoRows", err)
+	}
+	return nil
+}
+
+func testTxCommit(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	u, err := repo.InTx(ctx, r, func(tx repo.UserRepo) (model.User, error) {
+		u, err := f.create(ctx, tx, "Alice")
+		if err != nil {
+			return u, err
+		}
+		// The transaction sees its own writes, also through nested WithTx.
+		err = tx.WithTx(ctx, func(tx repo.UserRepo) error {
+			return tx.UpdateName(ctx, u.ID, "Alicia")
+		})
+		if err != nil {
+			return u, err
+		}
+		return tx.GetByID(ctx, u.ID)
+	})
+	if err != nil {
+		return err
+	}
+	if u.Name != "Alicia" {
+		return fmt.Errorf("InTx returned %+v", u)
+	}
+	got, err := r.GetByID(ctx, u.ID)
+	if err != nil {
+		return fmt.Errorf("after commit: %w", err)
+	}
+	if !sameUser(got, u) {
+		return fmt.Errorf("after commit got %+v, want %+v", got, u)
+	}
+	return nil
+}
+
+func testTxRollback(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	u, err := f.create(ctx, r, "Alice")
+	if err != nil {
+		return err
+	}
+	var created model.User
+	abort := errors.New("abort")
+	err = r.WithTx(ctx, func(tx repo.UserRepo) error {
+		var err error
+		if created, err = f.create(ctx, tx, "Bob"); err != nil {
+			return err
+		}
+		if err := tx.UpdateName(ctx, u.ID, "Changed"); err != nil {
+			return err
+		}
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		return fmt.Errorf("WithTx returned %v, want fn's error", err)
+	}
+	if _, err := r.GetByEmail(ctx, created.Email); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user created in a rolled back transaction: got %v, want sql.ErrNoRows", err)
+	}
+	got, err := r.GetByID(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if got.Name != u.Name {
+		return fmt.Errorf("update in a rolled back transaction kept: name %q", got.Name)
+	}
+	return nil
+}
+
+func testAppendSuffixes(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	a, err := f.create(ctx, r, "Alice")
+	if err != nil {
+		return err
+	}
+	b, err := f.create(ctx, r, "Bob")
+	if err != nil {
+		return err
+	}
+	err = repo.AppendSuffixes(ctx, r, repo.Suffix{ID: a.ID, Suffix: "_from"}, repo.Suffix{ID: b.ID, Suffix: "_to"})
+	if err != nil {
+		return err
+	}
+	for id, want := range map[int64]string{a.ID: "Alice_from", b.ID: "Bob_to"} {
+		got, err := r.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if got.Name != want {
+			return fmt.Errorf("user %d named %q, want %q", id, got.Name, want)
+		}
+	}
+	return nil
+}
+
+func testAppendSuffixesAtomic(ctx context.Context, r repo.UserRepo, f *fixture) error {
+	a, err := f.create(ctx, r, "Alice")
+	if err != nil {
+		return err
+	}
+	err = repo.AppendSuffixes(ctx, r, repo.Suffix{ID: a.ID, Suffix: "_from"}, repo.Suffix{ID: 1<<62 - 1, Suffix: "_to"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("with a missing user: got %v, want sql.ErrNoRows", err)
+	}
+	got, err := r.GetByID(ctx, a.ID)
+	if err != nil {
+		return err
+	}
+	if got.Name != a.Name {
+		return fmt.Errorf("failed AppendSuffixes left name %q, want %q", got.Name, a.Name)
+	}
+	return nil
+}
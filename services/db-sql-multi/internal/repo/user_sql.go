@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"db-sql-multi/internal/migrate"
+	"db-sql-multi/internal/model"
+)
+
+// dialect holds what differs between SQL backends; sqlUserRepo writes its
+// queries once, with ? placeholders, on top of it.
+type dialect struct {
+	name string // also the migrations' dialect
+	// bind rewrites ? placeholders into the dialect's own.
+	bind func(query string) string
+	// concat returns an expression joining two string expressions.
+	concat func(a, b string) string
+	// like is the case-insensitive LIKE operator.
+	like string
+	// returning reports whether INSERT supports RETURNING.
+	returning bool
+	// duplicate reports whether err is a unique constraint violation.
+	duplicate func(err error) bool
+	txOptions *sql.TxOptions
+}
+
+// querier is the part of *sql.DB and *sql.Tx the queries use.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlUserRepo implements UserRepo for a dialect. q is the database, or
+// the transaction when tx is set.
+type sqlUserRepo struct {
+	db *sql.DB
+	q  querier
+	tx *sql.Tx
+	d  *dialect
+}
+
+func newSQLUserRepo(db *sql.DB, d *dialect) sqlUserRepo {
+	return sqlUserRepo{db: db, q: db, d: d}
+}
+
+func (r sqlUserRepo) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.q.ExecContext(ctx, r.d.bind(query), args...)
+}
+
+func (r sqlUserRepo) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return r.q.QueryRowContext(ctx, r.d.bind(query), args...)
+}
+
+// Migrate applies the dialect's pending migrations. It always runs on the
+// database, outside any transaction r is bound to.
+func (r sqlUserRepo) Migrate(ctx context.Context) error {
+	m, err := migrate.New(r.db, r.d.name, nil)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up(ctx)
+	return err
+}
+
+func (r sqlUserRepo) Create(ctx context.Context, u *model.User) error {
+	if r.d.returning {
+		err := r.queryRow(ctx, `INSERT INTO users (email,name) VALUES (?,?) RETURNING id, created_at`, u.Email, u.Name).
+			Scan(&u.ID, &u.CreatedAt)
+		return r.createErr(err)
+	}
+	res, err := r.exec(ctx, `INSERT INTO users (email,name) VALUES (?,?)`, u.Email, u.Name)
+	if err != nil {
+		return r.createErr(err)
+	}
+	if u.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	return r.queryRow(ctx, `SELECT created_at FROM users WHERE id=?`, u.ID).Scan(&u.CreatedAt)
+}
+
+func (r sqlUserRepo) createErr(err error) error {
+	if err != nil && r.d.duplicate(err) {
+		return fmt.Errorf("%w: %v", ErrDuplicateEmail, err)
+	}
+	return err
+}
+
+const selectUser = `SELECT id,email,name,created_at FROM users`
+
+func (r sqlUserRepo) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	return r.get(ctx, selectUser+` WHERE email=?`, email)
+}
+
+func (r sqlUserRepo) GetByID(ctx context.Context, id int64) (model.User, error) {
+	return r.get(ctx, selectUser+` WHERE id=?`, id)
+}
+
+func (r sqlUserRepo) get(ctx context.Context, query string, args ...any) (model.User, error) {
+	var u model.User
+	err := r.queryRow(ctx, query, args...).Scan(&u.ID, &u.Email, &u.Name, &u.CreatedAt)
+	if err != nil {
+		return model.User{}, err
+	}
+	return u, nil
+}
+
+func (r sqlUserRepo) List(ctx context.Context, opts ListOptions) ([]model.User, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 100
+	}
+	query, args := selectUser+` WHERE id>?`, []any{opts.AfterID}
+	if opts.Email != "" {
+		query, args = query+` AND email=?`, append(args, opts.Email)
+	}
+	if opts.NameContains != "" {
+		query, args = query+` AND name `+r.d.like+` ? ESCAPE '!'`, append(args, "%"+likeEscaper.Replace(opts.NameContains)+"%")
+	}
+	rows, err := r.q.QueryContext(ctx, r.d.bind(query+` ORDER BY id LIMIT ?`), append(args, opts.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.User
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// likeEscaper escapes LIKE wildcards with the ! escape character, which
+// needs no quoting in any dialect.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (r sqlUserRepo) UpdateName(ctx context.Context, id int64, name string) error {
+	res, err := r.exec(ctx, `UPDATE users SET name=? WHERE id=?`, name, id)
+	return r.affected(ctx, res, err, id)
+}
+
+func (r sqlUserRepo) AppendSuffix(ctx context.Context, id int64, suffix string) error {
+	res, err := r.exec(ctx, `UPDATE users SET name=`+r.d.concat("name", "?")+` WHERE id=?`, suffix, id)
+	return r.affected(ctx, res, err, id)
+}
+
+func (r sqlUserRepo) Delete(ctx context.Context, id int64) error {
+	res, err := r.exec(ctx, `DELETE FROM users WHERE id=?`, id)
+	return r.affected(ctx, res, err, id)
+}
+
+// affected turns a statement that touched no row into sql.ErrNoRows. MySQL
+// counts only rows that changed, so a zero count is checked against the
+// table before it is trusted.
+func (r sqlUserRepo) affected(ctx context.Context, res sql.Result, err error, id int64) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var one int
+	return r.queryRow(ctx, `SELECT 1 FROM users WHERE id=?`, id).Scan(&one)
+}
+
+func (r sqlUserRepo) WithTx(ctx context.Context, fn func(tx UserRepo) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+	tx, err := r.db.BeginTx(ctx, r.d.txOptions)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(sqlUserRepo{db: r.db, q: tx, tx: tx, d: r.d}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit: %w", r.d.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+// internal/repo/user_sqlite.go
+package repo
+
+import (
+	"database/sql"
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLite transactions are serializable; the driver ignores isolation
+// levels, so txOptions stays nil.
+var sqliteDialect = dialect{
+	name:      "sqlite",
+	bind:      func(q string) string { return q },
+	concat:    func(a, b string) string { return a + "||" + b },
+	like:      "LIKE",
+	returning: true,
+	duplicate: func(err error) bool {
+		var se *sqlite.Error
+		return errors.As(err, &se) && se.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	},
+}
+
+// NewSQLite returns a UserRepo on an SQLite database opened with the
+// modernc.org/sqlite driver.
+func NewSQLite(db *sql.DB) UserRepo {
+	return newSQLUserRepo(db, &sqliteDialect)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"db-sql-multi/internal/model"
+)
+
+// ErrInconsistent marks a dual write that failed and could not be fully
+// undone: the repos disagree until the reconciler repairs them.
+var ErrInconsistent = errors.New("service: repos left inconsistent")
+
+// compensateTimeout bounds undoing a failed dual write. Compensation runs
+// even if the caller has gone away.
+const compensateTimeout = 10 * time.Second
+
+var (
+	dualWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dualwrite_operations_total",
+		Help: "Dual writes by operation and outcome: ok, failed (nothing written), compensated or inconsistent.",
+	}, []string{"op", "outcome"})
+	compensations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dualwrite_compensations_total", Help: "Undo steps run after a failed dual write, by repo and outcome.",
+	}, []string{"repo", "outcome"})
+)
+
+// step is one repo's part of a dual write and how to undo it.
+type step struct {
+	repo string
+	do   func(ctx context.Context) error
+	undo func(ctx context.Context) error
+}
+
+// saga runs steps in order. When one fails, the steps already done are
+// undone newest first and the error is returned; if an undo fails too, the
+// error also wraps ErrInconsistent.
+func (s DualService) saga(ctx context.Context, op, key string, steps []step) error {
+	for i, st := range steps {
+		err := st.do(ctx)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("%s: %w", st.repo, err)
+		if i == 0 {
+			dualWrites.WithLabelValues(op, "failed").Inc()
+			return err
+		}
+		s.Log.Warn("dual write failed; compensating", "op", op, "key", key, "repo", st.repo, "err", err)
+		cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compensateTimeout)
+		defer cancel()
+		var undoErrs []error
+		for j := i - 1; j >= 0; j-- {
+			u := steps[j]
+			if uerr := u.undo(cctx); uerr != nil {
+				compensations.WithLabelValues(u.repo, "failed").Inc()
+				s.Log.Error("compensation failed", "op", op, "key", key, "repo", u.repo, "err", uerr)
+				undoErrs = append(undoErrs, fmt.Errorf("undo %s: %w", u.repo, uerr))
+				continue
+			}
+			compensations.WithLabelValues(u.repo, "ok").Inc()
+		}
+		if len(undoErrs) > 0 {
+			dualWrites.WithLabelValues(op, "inconsistent").Inc()
+			return errors.Join(append([]error{err, ErrInconsistent}, undoErrs...)...)
+		}
+		dualWrites.WithLabelValues(op, "compensated").Inc()
+		return err
+	}
+	dualWrites.WithLabelValues(op, "ok").Inc()
+	return nil
+}
+
+// CreateUser creates the user in every repo, in order, and returns it as
+// stored by the first. If a later repo fails, the users already created
+// are deleted again.
+func (s DualService) CreateUser(ctx context.Context, email, name string) (model.User, error) {
+	created := make([]model.User, len(s.Repos))
+	steps := make([]step, len(s.Repos))
+	for i, r := range s.Repos {
+		steps[i] = step{
+			repo: r.Name,
+			do: func(ctx context.Context) error {
+				created[i] = model.User{Email: email, Name: name}
+				return r.Repo.Create(ctx, &created[i])
+			},
+			undo: func(ctx context.Context) error {
+				return r.Repo.Delete(ctx, created[i].ID)
+			},
+		}
+	}
+	if err := s.saga(ctx, "create", email, steps); err != nil {
+		return model.User{}, err
+	}
+	return created[0], nil
+}
+
+// RenameUser sets the name of the user with email in every repo. If a
+// later repo fails, the names already changed are restored.
+func (s DualService) RenameUser(ctx context.Context, email, name string) error {
+	steps := make([]step, len(s.Repos))
+	for i, r := range s.Repos {
+		var before model.User
+		steps[i] = step{
+			repo: r.Name,
+			do: func(ctx context.Context) error {
+				var err error
+				if before, err = r.Repo.GetByEmail(ctx, email); err != nil {
+					return err
+				}
+				return r.Repo.UpdateName(ctx, before.ID, name)
+			},
+			undo: func(ctx context.Context) error {
+				return r.Repo.UpdateName(ctx, before.ID, before.Name)
+			},
+		}
+	}
+	return s.saga(ctx, "rename", email, steps)
+}
+
+// DeleteUser deletes the user with email from every repo that has it and
+// returns sql.ErrNoRows if none does. If a later repo fails, the users
+// already deleted are created again, under new IDs.
+func (s DualService) DeleteUser(ctx context.Context, email string) error {
+	found := false
+	steps := make([]step, len(s.Repos))
+	for i, r := range s.Repos {
+		var before model.User
+		deleted := false
+		steps[i] = step{
+			repo: r.Name,
+			do: func(ctx context.Context) error {
+				var err error
+				before, err = r.Repo.GetByEmail(ctx, email)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil // already gone here
+				}
+				if err != nil {
+					return err
+				}
+				if err := r.Repo.Delete(ctx, before.ID); err != nil {
+					return err
+				}
+				deleted, found = true, true
+				return nil
+			},
+			undo: func(ctx context.Context) error {
+				if !deleted {
+					return nil
+				}
+				u := model.User{Email: before.Email, Name: before.Name}
+				return r.Repo.Create(ctx, &u)
+			},
+		}
+	}
+	if err := s.saga(ctx, "delete", email, steps); err != nil {
+		return err
+	}
+	if !found {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"db-sql-multi/internal/model"
+	"db-sql-multi/internal/repo"
+)
+
+// Drift kinds: the user is missing from a repo, exists only in it, or has
+// another name there than in the primary.
+const (
+	DriftMissing = "missing"
+	DriftExtra   = "extra"
+	DriftName    = "name_mismatch"
+)
+
+var (
+	reconcileRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reconcile_runs_total", Help: "Reconciliation runs by outcome: ok or error.",
+	}, []string{"outcome"})
+	reconcileDrift = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "reconcile_drift_users", Help: "Users drifted from the primary at the last run, by repo and kind.",
+	}, []string{"repo", "kind"})
+	reconcileRepairs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reconcile_repairs_total", Help: "Drift repairs by repo, action and outcome.",
+	}, []string{"repo", "action", "outcome"})
+	reconcileDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "reconcile_duration_seconds", Help: "Duration of reconciliation runs.",
+	})
+)
+
+// Drift is one user that differs between the primary and a repo.
+type Drift struct {
+	Email string `json:"email"`
+	Repo  string `json:"repo"`
+	Kind  string `json:"kind"`
+	// PrimaryName and RepoName are the user's names, empty where the
+	// user does not exist.
+	PrimaryName string `json:"primary_name,omitempty"`
+	RepoName    string `json:"repo_name,omitempty"`
+	// Repaired reports whether the run fixed it; RepairError why not.
+	Repaired    bool   `json:"repaired,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// Report is the outcome of one reconciliation run.
+type Report struct {
+	Primary  string         `json:"primary"`
+	Started  time.Time      `json:"started"`
+	Duration string         `json:"duration"`
+	Users    map[string]int `json:"users"` // per repo
+	Drift    []Drift        `json:"drift"`
+	Repair   bool           `json:"repair"`
+	Repaired int            `json:"repaired"`
+}
+
+// Reconcile diffs the users of every repo against the first, the primary,
+// matching them by email. Dual writes reach the primary first, so it is
+// the source of truth: with repair set, the other repos are changed to
+// match it, creating missing users, deleting extra ones and fixing names.
+//
+// Each repo's users are read in full, in pages, without a snapshot, so a
+// user written during the run may show as drift that the next run no
+// longer sees.
+func (s DualService) Reconcile(ctx context.Context, repair bool) (Report, error) {
+	start := time.Now()
+	rep, err := s.reconcile(ctx, repair)
+	reconcileDuration.Observe(time.Since(start).Seconds())
+	rep.Started, rep.Duration = start.UTC(), time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		reconcileRuns.WithLabelValues("error").Inc()
+		s.Log.Error("reconcile failed", "err", err)
+		return rep, err
+	}
+	reconcileRuns.WithLabelValues("ok").Inc()
+	s.Log.Info("reconciled", "primary", rep.Primary, "users", rep.Users, "drift", len(rep.Drift), "repaired", rep.Repaired, "took", rep.Duration)
+	return rep, nil
+}
+
+func (s DualService) reconcile(ctx context.Context, repair bool) (Report, error) {
+	rep := Report{Users: make(map[string]int), Drift: []Drift{}, Repair: repair}
+	if len(s.Repos) == 0 {
+		return rep, errors.New("reconcile: no repos")
+	}
+	primary := s.Repos[0]
+	rep.Primary = primary.Name
+	want, err := usersByEmail(ctx, primary.Repo)
+	if err != nil {
+		return rep, fmt.Errorf("%s: %w", primary.Name, err)
+	}
+	rep.Users[primary.Name] = len(want)
+	for _, r := range s.Repos[1:] {
+		have, err := usersByEmail(ctx, r.Repo)
+		if err != nil {
+			return rep, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		rep.Users[r.Name] = len(have)
+		drift := diff(r.Name, want, have)
+		counts := map[string]int{DriftMissing: 0, DriftExtra: 0, DriftName: 0}
+		for i := range drift {
+			d := &drift[i]
+			counts[d.Kind]++
+			s.Log.Warn("drift", "repo", d.Repo, "email", d.Email, "kind", d.Kind)
+			if repair {
+				s.repair(ctx, r, d, want, have)
+				if d.Repaired {
+					rep.Repaired++
+				}
+			}
+		}
+		for kind, n := range counts {
+			reconcileDrift.WithLabelValues(r.Name, kind).Set(float64(n))
+		}
+		rep.Drift = append(rep.Drift, drift...)
+	}
+	return rep, nil
+}
+
+// diff lists the users of have that differ from want, ordered by email.
+func diff(name string, want, have map[string]model.User) []Drift {
+	var out []Drift
+	for email, w := range want {
+		h, ok := have[email]
+		switch {
+		case !ok:
+			out = append(out, Drift{Email: email, Repo: name, Kind: DriftMissing, PrimaryName: w.Name})
+		case h.Name != w.Name:
+			out = append(out, Drift{Email: email, Repo: name, Kind: DriftName, PrimaryName: w.Name, RepoName: h.Name})
+		}
+	}
+	for email, h := range have {
+		if _, ok := want[email]; !ok {
+			out = append(out, Drift{Email: email, Repo: name, Kind: DriftExtra, RepoName: h.Name})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Email < out[j].Email })
+	return out
+}
+
+// repair makes r agree with the primary about d's user and records the
+// outcome in d.
+func (s DualService) repair(ctx context.Context, r NamedRepo, d *Drift, want, have map[string]model.User) {
+	var action string
+	var err error
+	switch d.Kind {
+	case DriftMissing:
+		action = "create"
+		u := model.User{Email: d.Email, Name: want[d.Email].Name}
+		err = r.Repo.Create(ctx, &u)
+	case DriftExtra:
+		action = "delete"
+		err = r.Repo.Delete(ctx, have[d.Email].ID)
+	case DriftName:
+		action = "rename"
+		err = r.Repo.UpdateName(ctx, have[d.Email].ID, d.PrimaryName)
+	}
+	if err != nil {
+		reconcileRepairs.WithLabelValues(r.Name, action, "failed").Inc()
+		s.Log.Error("repair failed", "repo", r.Name, "email", d.Email, "action", action, "err", err)
+		d.RepairError = err.Error()
+		return
+	}
+	reconcileRepairs.WithLabelValues(r.Name, action, "ok").Inc()
+	s.Log.Info("repaired", "repo", r.Name, "email", d.Email, "action", action)
+	d.Repaired = true
+}
+
+// usersByEmail reads every user of r.
+func usersByEmail(ctx context.Context, r repo.UserRepo) (map[string]model.User, error) {
+	out := make(map[string]model.User)
+	opts := repo.ListOptions{Limit: 500}
+	for {
+		page, err := r.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range page {
+			out[u.Email] = u
+		}
+		if len(page) < opts.Limit {
+			return out, nil
+		}
+		opts.AfterID = page[len(page)-1].ID
+	}
+}
+
+// RunReconciler reconciles every interval until ctx ends.
+func (s DualService) RunReconciler(ctx context.Context, interval time.Duration, repair bool) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		_, _ = s.Reconcile(ctx, repair) // logged and counted by Reconcile
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"db-sql-multi/internal/config"
+	"db-sql-multi/internal/db"
+	"db-sql-multi/internal/model"
+	"db-sql-multi/internal/repo"
+)
+
+var errInjected = errors.New("injected failure")
+
+// flaky is a UserRepo whose writes fail while fail names them.
+type flaky struct {
+	repo.UserRepo
+	fail map[string]bool // "create", "rename" or "delete"
+}
+
+func (f *flaky) Create(ctx context.Context, u *model.User) error {
+	if f.fail["create"] {
+		return errInjected
+	}
+	return f.UserRepo.Create(ctx, u)
+}
+
+func (f *flaky) UpdateName(ctx context.Context, id int64, name string) error {
+	if f.fail["rename"] {
+		return errInjected
+	}
+	return f.UserRepo.UpdateName(ctx, id, name)
+}
+
+func (f *flaky) Delete(ctx context.Context, id int64) error {
+	if f.fail["delete"] {
+		return errInjected
+	}
+	return f.UserRepo.Delete(ctx, id)
+}
+
+// newService returns a DualService over two fresh SQLite repos, the
+// primary and the secondary, each wrapped to fail on demand.
+func newService(t *testing.T) (DualService, *flaky, *flaky) {
+	t.Helper()
+	open := func(name string) *flaky {
+		conn, err := db.Connect(config.DBConfig{Driver: "sqlite", DSN: config.SQLiteMemoryDSN(t.Name() + "_" + name)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &flaky{UserRepo: repo.NewSQLite(conn), fail: map[string]bool{}}
+	}
+	primary, secondary := open("primary"), open("secondary")
+	s := DualService{
+		Repos: []NamedRepo{{Name: "primary", Repo: primary}, {Name: "secondary", Repo: secondary}},
+		Log:   slog.New(slog.DiscardHandler),
+	}
+	if err := s.Bootstrap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, primary, secondary
+}
+
+// names returns the name of every user of r by email.
+func names(t *testing.T, r repo.UserRepo) map[string]string {
+	t.Helper()
+	users, err := usersByEmail(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]string, len(users))
+	for email, u := range users {
+		out[email] = u.Name
+	}
+	return out
+}
+
+func TestDualWriteCompensates(t *testing.T) {
+	ctx := context.Background()
+	const email = "ann@example.com"
+	tests := []struct {
+		name string
+		// setup runs before the secondary starts failing op.
+		setup func(s DualService) error
+		op    string
+		write func(s DualService) error
+		want  map[string]string // the primary's users afterwards
+	}{
+		{
+			name:  "create",
+			op:    "create",
+			write: func(s DualService) error { _, err := s.CreateUser(ctx, email, "Ann"); return err },
+			want:  map[string]string{},
+		},
+		{
+			name:  "rename",
+			setup: func(s DualService) error { _, err := s.CreateUser(ctx, email, "Ann"); return err },
+			op:    "rename",
+			write: func(s DualService) error { return s.RenameUser(ctx, email, "Anna") },
+			want:  map[string]string{email: "Ann"},
+		},
+		{
+			name:  "delete",
+			setup: func(s DualService) error { _, err := s.CreateUser(ctx, email, "Ann"); return err },
+			op:    "delete",
+			write: func(s DualService) error { return s.DeleteUser(ctx, email) },
+			want:  map[string]string{email: "Ann"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, primary, secondary := newService(t)
+			if tt.setup != nil {
+				if err := tt.setup(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := names(t, secondary)
+			secondary.fail[tt.op] = true
+			err := tt.write(s)
+			if !errors.Is(err, errInjected) || errors.Is(err, ErrInconsistent) {
+				t.Fatalf("write returned %v, want the secondary's error alone", err)
+			}
+			if got := names(t, primary); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("primary after compensation = %v, want %v", got, tt.want)
+			}
+			if got := names(t, secondary); !reflect.DeepEqual(got, before) {
+				t.Errorf("secondary changed to %v, want %v", got, before)
+			}
+		})
+	}
+}
+
+func TestDualWriteFirstStepFails(t *testing.T) {
+	s, primary, secondary := newService(t)
+	primary.fail["create"] = true
+	if _, err := s.CreateUser(context.Background(), "ann@example.com", "Ann"); !errors.Is(err, errInjected) {
+		t.Fatalf("CreateUser returned %v, want the primary's error", err)
+	}
+	if got := names(t, secondary); len(got) != 0 {
+		t.Errorf("secondary was written after the primary failed: %v", got)
+	}
+}
+
+func TestDualWriteUndoFails(t *testing.T) {
+	s, primary, secondary := newService(t)
+	secondary.fail["create"] = true
+	primary.fail["delete"] = true
+	_, err := s.CreateUser(context.Background(), "ann@example.com", "Ann")
+	if !errors.Is(err, ErrInconsistent) || !errors.Is(err, errInjected) {
+		t.Fatalf("CreateUser returned %v, want ErrInconsistent", err)
+	}
+	if got := names(t, primary); got["ann@example.com"] != "Ann" {
+		t.Errorf("primary = %v, want the user the undo failed to delete", got)
+	}
+}
+
+func TestDeleteUserMissing(t *testing.T) {
+	s, _, _ := newService(t)
+	if err := s.DeleteUser(context.Background(), "nobody@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("DeleteUser returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	s, primary, secondary := newService(t)
+	for _, u := range []struct {
+		r           repo.UserRepo
+		email, name string
+	}{
+		{primary, "ann@example.com", "Ann"},
+		{primary, "bob@example.com", "Bob"},
+		{primary, "cy@example.com", "Cy"},
+		{secondary, "ann@example.com", "Anna"},
+		{secondary, "bob@example.com", "Bob"},
+		{secondary, "dee@example.com", "Dee"},
+	} {
+		if err := u.r.Create(ctx, &model.User{Email: u.email, Name: u.name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep, err := s.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Drift{
+		{Email: "ann@example.com", Repo: "secondary", Kind: DriftName, PrimaryName: "Ann", RepoName: "Anna"},
+		{Email: "cy@example.com", Repo: "secondary", Kind: DriftMissing, PrimaryName: "Cy"},
+		{Email: "dee@example.com", Repo: "secondary", Kind: DriftExtra, RepoName: "Dee"},
+	}
+	if !reflect.DeepEqual(rep.Drift, want) {
+		t.Errorf("drift = %+v, want %+v", rep.Drift, want)
+	}
+	if rep.Users["primary"] != 3 || rep.Users["secondary"] != 3 || rep.Repaired != 0 {
+		t.Errorf("report users %v, repaired %d; want 3 each and none repaired", rep.Users, rep.Repaired)
+	}
+	if got := names(t, secondary); got["ann@example.com"] != "Anna" || len(got) != 3 {
+		t.Errorf("reporting changed the secondary: %v", got)
+	}
+
+	// A failed repair is reported and retried by the next run.
+	secondary.fail["delete"] = true
+	rep, err = s.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Repaired != 2 || rep.Drift[2].Repaired || rep.Drift[2].RepairError == "" {
+		t.Errorf("repaired %d, extra user %+v; want 2 repaired and the delete failed", rep.Repaired, rep.Drift[2])
+	}
+	secondary.fail["delete"] = false
+	if rep, err = s.Reconcile(ctx, true); err != nil || rep.Repaired != 1 {
+		t.Fatalf("second repair: repaired %d, %v; want 1", rep.Repaired, err)
+	}
+
+	if rep, err = s.Reconcile(ctx, false); err != nil || len(rep.Drift) != 0 {
+		t.Fatalf("after repair: drift %+v, %v; want none", rep.Drift, err)
+	}
+	if got, want := names(t, secondary), names(t, primary); !reflect.DeepEqual(got, want) {
+		t.Errorf("secondary = %v, want the primary's %v", got, want)
+	}
+}
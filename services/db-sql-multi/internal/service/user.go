@@ -11,18 +11,39 @@ import (
 	"db-sql-multi/internal/repo"
 )
 
+// NamedRepo is a UserRepo and the name it goes by in logs and errors.
+type NamedRepo struct {
+	Name string
+	Repo repo.UserRepo
+}
+
+// DualService runs the same user operations on every repo in Repos, in
+// order. The first repo is the primary: dual writes reach it first and
+// Reconcile treats it as the source of truth.
 type DualService struct {
-	My  repo.MySQLUserRepo
-	Pg  repo.PGUserRepo
-	Log *slog.Logger
+	Repos []NamedRepo
+	Log   *slog.Logger
+	// DualWrite makes Demo write the same users to every repo, through
+	// CreateUser, RenameUser and DeleteUser, instead of its own users to
+	// each; the /users handlers write through them too.
+	DualWrite bool
 }
 
-func (s DualService) Bootstrap(ctx context.Context) error {
-	if err := s.My.Migrate(ctx); err != nil {
-		return err
+// Repo returns the repo called name.
+func (s DualService) Repo(name string) (repo.UserRepo, bool) {
+	for _, r := range s.Repos {
+		if r.Name == name {
+			return r.Repo, true
+		}
 	}
-	if err := s.Pg.Migrate(ctx); err != nil {
-		return err
+	return nil, false
+}
+
+func (s DualService) Bootstrap(ctx context.Context) error {
+	for _, r := range s.Repos {
+		if err := r.Repo.Migrate(ctx); err != nil {
+			return fmt.Errorf("%s: migrate: %w", r.Name, err)
+		}
 	}
 	return nil
 }
@@ -32,36 +53,53 @@ func randomEmail(name string) string {
 }
 
 func (s DualService) Demo(ctx context.Context) error {
-	u1 := &model.User{Email: randomEmail("Alice"), Name: "Alice"}
-	u2 := &model.User{Email: randomEmail("Bob"), Name: "Bob"}
-	if err := s.My.Create(ctx, u1); err != nil {
-		return err
+	if s.DualWrite {
+		return s.dualDemo(ctx)
 	}
-	if err := s.Pg.Create(ctx, u2); err != nil {
+	for _, r := range s.Repos {
+		if err := s.demo(ctx, r.Repo); err != nil {
+			return fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond) // give pool a bit of churn
+	return nil
+}
+
+func (s DualService) demo(ctx context.Context, r repo.UserRepo) error {
+	u1 := &model.User{Email: randomEmail("Alice"), Name: "Alice"}
+	if err := r.Create(ctx, u1); err != nil {
 		return err
 	}
 
 	// Read
-	_, _ = s.My.GetByEmail(ctx, u1.Email)
-	_, _ = s.Pg.GetByEmail(ctx, u2.Email)
-
-	// Update + Transactions
-	if err := s.My.UpdateName(ctx, u1.ID, "AliceUpdated"); err != nil {
+	if _, err := r.GetByEmail(ctx, u1.Email); err != nil {
 		return err
 	}
-	if err := s.Pg.UpdateName(ctx, u2.ID, "BobUpdated"); err != nil {
+
+	// Update + Transactions
+	if err := r.UpdateName(ctx, u1.ID, "AliceUpdated"); err != nil {
 		return err
 	}
 
 	// Add another to show tx across rows
-	u3 := &model.User{Email: randomEmail("Carol"), Name: "Carol"}
-	_ = s.My.Create(ctx, u3)
-	_ = s.My.TxTransfer(ctx, u1.ID, u3.ID)
-
-	u4 := &model.User{Email: randomEmail("Dave"), Name: "Dave"}
-	_ = s.Pg.Create(ctx, u4)
-	_ = s.Pg.TxSwapSuffix(ctx, u2.ID, u4.ID)
+	u2 := &model.User{Email: randomEmail("Bob"), Name: "Bob"}
+	if err := r.Create(ctx, u2); err != nil {
+		return err
+	}
+	return repo.AppendSuffixes(ctx, r, repo.Suffix{ID: u1.ID, Suffix: "_from"}, repo.Suffix{ID: u2.ID, Suffix: "_to"})
+}
 
-	time.Sleep(100 * time.Millisecond) // give pool a bit of churn
-	return nil
+func (s DualService) dualDemo(ctx context.Context) error {
+	alice, err := s.CreateUser(ctx, randomEmail("Alice"), "Alice")
+	if err != nil {
+		return err
+	}
+	if err := s.RenameUser(ctx, alice.Email, "AliceUpdated"); err != nil {
+		return err
+	}
+	bob, err := s.CreateUser(ctx, randomEmail("Bob"), "Bob")
+	if err != nil {
+		return err
+	}
+	return s.DeleteUser(ctx, bob.Email)
 }
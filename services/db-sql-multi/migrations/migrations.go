@@ -0,0 +1,10 @@
+// Package migrations embeds the versioned schema migrations, one directory
+// per dialect. Files are named <version>_<name>.up.sql and
+// <version>_<name>.down.sql; statements end with a semicolon at the end of
+// a line. Never edit a migration once it has shipped: add a new one.
+package migrations
+
+import "embed"
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var FS embed.FS
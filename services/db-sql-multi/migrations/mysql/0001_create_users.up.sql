@@ -0,0 +1,8 @@
+-- IF NOT EXISTS adopts tables made by the earlier ad-hoc Migrate or by
+-- scripts/database/mysql-init.sql; 0002 aligns the latter.
+CREATE TABLE IF NOT EXISTS users (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  email VARCHAR(255) UNIQUE NOT NULL,
+  name  VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+-- Nothing to undo: narrowing the columns again could truncate data.
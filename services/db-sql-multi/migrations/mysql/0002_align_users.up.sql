@@ -0,0 +1,7 @@
+-- Tables created by older init scripts used VARCHAR(100) and a nullable
+-- created_at. The id column is left alone: orders.user_id may reference it.
+UPDATE users SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+ALTER TABLE users
+  MODIFY email VARCHAR(255) NOT NULL,
+  MODIFY name VARCHAR(255) NOT NULL,
+  MODIFY created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
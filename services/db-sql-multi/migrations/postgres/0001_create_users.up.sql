@@ -0,0 +1,8 @@
+-- IF NOT EXISTS adopts tables made by the earlier ad-hoc Migrate or by
+-- scripts/database/postgres-init.sql; 0002 aligns the latter.
+CREATE TABLE IF NOT EXISTS users (
+  id BIGSERIAL PRIMARY KEY,
+  email TEXT UNIQUE NOT NULL,
+  name  TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
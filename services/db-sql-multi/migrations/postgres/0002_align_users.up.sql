@@ -0,0 +1,10 @@
+-- Tables created by older init scripts used VARCHAR(100) and a nullable
+-- TIMESTAMP created_at. The id column is left alone: orders.user_id may
+-- reference it.
+UPDATE users SET created_at = NOW() WHERE created_at IS NULL;
+ALTER TABLE users
+  ALTER COLUMN email TYPE TEXT,
+  ALTER COLUMN name TYPE TEXT,
+  ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+  ALTER COLUMN created_at SET DEFAULT NOW(),
+  ALTER COLUMN created_at SET NOT NULL;
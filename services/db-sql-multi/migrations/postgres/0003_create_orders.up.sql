@@ -0,0 +1,9 @@
+-- IF NOT EXISTS adopts the orders table older versions of
+-- scripts/database/postgres-init.sql created.
+CREATE TABLE IF NOT EXISTS orders (
+  id SERIAL PRIMARY KEY,
+  user_id BIGINT REFERENCES users (id),
+  amount DECIMAL(10,2) NOT NULL,
+  status VARCHAR(20) DEFAULT 'pending',
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
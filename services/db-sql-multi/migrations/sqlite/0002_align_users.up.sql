@@ -0,0 +1,2 @@
+-- Nothing to align: the init scripts never created SQLite tables. The
+-- version exists so every dialect shares the same history.
@@ -1,25 +1,336 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"http-rest/internal/auth"
+	"http-rest/internal/config"
+	"http-rest/internal/events"
 	"http-rest/internal/handlers"
+	"http-rest/internal/health"
+	"http-rest/internal/idempotency"
+	"http-rest/internal/metrics"
+	"http-rest/internal/openapi"
+	"http-rest/internal/outbox"
+	"http-rest/internal/ratelimit"
+	"http-rest/internal/registry"
 	"http-rest/internal/router"
+	"http-rest/internal/search"
+	"http-rest/internal/server"
+	"http-rest/internal/store"
+	"http-rest/internal/telemetry"
 )
 
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cfg := config.Load()
+	logger := newLogger(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	shutdownTracing, err := telemetry.Setup(ctx, telemetry.Config{
+		ServiceName: cfg.ServiceName,
+		Exporter:    cfg.TraceExporter,
+		Endpoint:    cfg.TraceEndpoint,
+	})
+	if err != nil {
+		logger.Error("tracing setup", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("tracing shutdown", "err", err)
+		}
+	}()
+
+	books, err := store.Open(ctx, cfg.StoreDriver, cfg.StoreDSN)
+	if err != nil {
+		logger.Error("open book store", "driver", cfg.StoreDriver, "err", err)
+		os.Exit(1)
+	}
+	defer books.Close()
+	logger.Info("book store ready", "driver", cfg.StoreDriver)
+
+	srv, err := server.New(server.Options{
+		Addr:              cfg.Addr,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		DrainDelay:        cfg.DrainDelay,
+		ShutdownTimeout:   cfg.ShutdownTimeout,
+		TLSCertFile:       cfg.TLSCertFile,
+		TLSKeyFile:        cfg.TLSKeyFile,
+		TLSReloadInterval: cfg.TLSReloadInterval,
+	}, logger)
+	if err != nil {
+		logger.Error("server setup", "err", err)
+		os.Exit(1)
+	}
+
 	mux := http.NewServeMux()
+	indexed, err := search.NewIndexedStore(ctx, books)
+	if err != nil {
+		logger.Error("index books", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("search index ready", "books", indexed.Index().Len())
+	// Changes are published after the index has them, so a client reacting
+	// to an event finds the book in search results.
+	broker := events.NewBroker(events.Options{History: cfg.EventHistory, Buffer: cfg.EventBuffer})
+	srv.RegisterOnShutdown(broker.Close)
+	kafkaOutbox, closeKafka := kafkaEvents(cfg, broker, logger)
+	defer closeKafka()
+	bh := handlers.NewBookHandler(events.NewStore(indexed, broker))
+	bh.Index = indexed.Index()
+	bh.Events, bh.EventHeartbeat = broker, cfg.EventHeartbeat
+	bh.DefaultPageSize, bh.MaxPageSize = cfg.DefaultPageSize, cfg.MaxPageSize
+	bh.MaxBodyBytes, bh.MaxImportBytes = int64(cfg.MaxBodyBytes), int64(cfg.MaxImportBytes)
+	bh.Logger = logger
+	bh.Register(mux)
 
-	books := handlers.NewBookHandler()
-	books.Register(mux)
+	targets, err := registry.Load()
+	if err != nil {
+		logger.Error("load trigger targets", "err", err)
+		os.Exit(1)
+	}
+	triggers := handlers.NewTriggers(targets, cfg.TriggerTimeout)
+	triggers.Logger = logger
+	triggers.Register(mux)
+	checks := healthChecks(cfg, srv, books, targets)
+	if kafkaOutbox != nil {
+		checks.Readiness(health.Check{Name: "kafka", Func: func(context.Context) error { return kafkaOutbox.Err() }})
+	}
+	mux.Handle("GET /livez", checks.LiveHandler())
+	mux.Handle("GET /readyz", checks.ReadyHandler())
+	doc := handlers.OpenAPI(targets)
+	mux.Handle("GET /openapi.json", openapi.Handler(doc))
+	mux.Handle("GET /docs", openapi.DocsHandler(doc, "/openapi.json"))
+	httpMetrics, err := setupMetrics(cfg, mux, books, broker, kafkaOutbox, logger)
+	if err != nil {
+		logger.Error("metrics setup", "err", err)
+		os.Exit(1)
+	}
+
+	limits, err := ratelimit.Load()
+	if err != nil {
+		logger.Error("load rate limits", "err", err)
+		os.Exit(1)
+	}
+	limiter := ratelimit.New(limits, nil, logger)
+
+	var authn *auth.Authenticator
+	authConf, err := auth.Load()
+	if err != nil {
+		logger.Error("load auth config", "err", err)
+		os.Exit(1)
+	}
+	if authConf != nil {
+		if authn, err = auth.New(authConf); err != nil {
+			logger.Error("auth setup", "err", err)
+			os.Exit(1)
+		}
+		limiter.IdentifyBy(authn.Caller)
+		logger.Info("authentication enabled", "api_keys", len(authConf.APIKeys), "anonymous_scopes", authConf.AnonymousScopes)
+	} else {
+		logger.Warn("authentication disabled: AUTH_CONFIG_FILE and AUTH_CONFIG are unset")
+	}
+	go reloadOnHangup(logger, limiter, authn)
 
-	handler := router.New(mux, mux)
-	addr := ":8084"
-	logger.Info("http server", "addr", addr)
-	if err := http.ListenAndServe(addr, handler); err != nil {
+	keys := idempotency.NewMemory()
+	keys.MaxKeys = cfg.IdempotencyMaxKeys
+	keeper := idempotency.New(keys, idempotency.Options{
+		TTL:          cfg.IdempotencyTTL,
+		Wait:         cfg.IdempotencyWait,
+		MaxBodyBytes: int64(cfg.MaxBodyBytes),
+	}, logger)
+
+	handler := router.New(mux, mux, router.Config{
+		Logger:            logger,
+		SuccessSampleRate: cfg.AccessLogSampleRate,
+		RateLimiter:       limiter,
+		Auth:              authn,
+		Scopes:            handlers.Scopes(),
+		Idempotency:       keeper,
+		IdempotentRoutes:  handlers.IdempotentRoutes(),
+		Metrics:           httpMetrics,
+	})
+	stop := shutdownSignal(logger)
+	go serveAdmin(stop, cfg.AdminAddr, logger)
+	if err := srv.Run(stop, handler); err != nil {
 		logger.Error("server", "err", err)
 	}
 }
+
+// serveAdmin serves /debug/vars on addr until ctx is done. It lives on its
+// own listener, loopback by default, because expvar publishes memstats, the
+// command line and every counter to whoever asks.
+func serveAdmin(ctx context.Context, addr string, logger *slog.Logger) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	logger.Info("admin listener", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("admin listener", "addr", addr, "err", err)
+	}
+}
+
+// setupMetrics serves the Prometheus registry at cfg.MetricsPath and
+// returns the request metrics for the router, or nil if metrics are off.
+func setupMetrics(cfg config.Conf, mux *http.ServeMux, books store.BookStore, broker *events.Broker, kafkaOutbox *outbox.Outbox, logger *slog.Logger) (*metrics.HTTP, error) {
+	if !cfg.MetricsEnabled {
+		return nil, nil
+	}
+	if !strings.HasPrefix(cfg.MetricsPath, "/") {
+		return nil, fmt.Errorf("METRICS_ENDPOINT %q must start with /", cfg.MetricsPath)
+	}
+	var buckets []float64
+	if cfg.MetricsBuckets != "" {
+		var err error
+		if buckets, err = metrics.ParseBuckets(cfg.MetricsBuckets); err != nil {
+			return nil, err
+		}
+	}
+	m := metrics.NewHTTP(prometheus.DefaultRegisterer, buckets)
+	metrics.GaugeFunc(prometheus.DefaultRegisterer, "books_stored", "Books in the store.", func() (float64, bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		n, err := books.Count(ctx)
+		if err != nil {
+			logger.Warn("count books for metrics", "err", err)
+			return 0, false
+		}
+		return float64(n), true
+	})
+	metrics.GaugeFunc(prometheus.DefaultRegisterer, "book_event_subscribers", "Open book event subscriptions.", func() (float64, bool) {
+		return float64(broker.Subscribers()), true
+	})
+	if kafkaOutbox != nil {
+		metrics.GaugeFunc(prometheus.DefaultRegisterer, "outbox_pending", "Book events waiting to be written to Kafka.", func() (float64, bool) {
+			return float64(kafkaOutbox.Pending()), true
+		})
+	}
+	mux.Handle("GET "+cfg.MetricsPath, promhttp.Handler())
+	return m, nil
+}
+
+// kafkaEvents forwards book events to Kafka through an outbox, or returns
+// a nil outbox when BOOK_EVENTS_KAFKA_BROKERS is unset. The returned func
+// flushes the outbox, for up to SHUTDOWN_TIMEOUT, and closes the writer.
+func kafkaEvents(cfg config.Conf, broker *events.Broker, logger *slog.Logger) (*outbox.Outbox, func()) {
+	if len(cfg.EventKafkaBrokers) == 0 {
+		return nil, func() {}
+	}
+	w := outbox.NewWriter(cfg.EventKafkaBrokers, cfg.EventKafkaTopic)
+	ob := outbox.New(w, outbox.Options{
+		Topic:       cfg.EventKafkaTopic,
+		Capacity:    cfg.EventOutboxSize,
+		MaxAttempts: cfg.EventKafkaAttempts,
+	}, logger)
+	broker.Forward(func(e events.Event) { ob.Enqueue(events.KafkaMessage(e)) })
+	logger.Info("publishing book events to kafka", "brokers", cfg.EventKafkaBrokers, "topic", cfg.EventKafkaTopic)
+	return ob, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := ob.Close(ctx); err != nil {
+			logger.Error("flush book events to kafka", "err", err)
+		}
+		if err := w.Close(); err != nil {
+			logger.Error("close kafka writer", "err", err)
+		}
+	}
+}
+
+// healthChecks registers the liveness and readiness checks. The server and
+// store must be up for the service to be ready; an unreachable trigger
+// target only degrades readiness, since the Books API still works. The
+// goroutine count only degrades liveness: SSE subscribers hold goroutines,
+// and restarting a busy pod would not help.
+func healthChecks(cfg config.Conf, srv *server.Server, books store.BookStore, targets *registry.Registry) *health.Checker {
+	c := health.New(health.Options{Timeout: cfg.HealthTimeout, CacheTTL: cfg.HealthCacheTTL})
+	c.Liveness(health.Check{Name: "goroutines", Func: health.Goroutines(10000)})
+	c.Readiness(health.Check{Name: "server", Func: health.Flag(srv.Ready, "draining"), Critical: true, CacheTTL: -1})
+	c.Readiness(health.Check{Name: "store", Critical: true, Func: func(ctx context.Context) error {
+		_, err := books.List(ctx, store.ListOptions{Limit: 1})
+		return err
+	}})
+	if cfg.HealthCheckTargets {
+		for _, t := range targets.Targets() {
+			c.Readiness(health.Check{Name: "target:" + t.Name, Func: health.Dial(t.BaseURL)})
+		}
+	}
+	return c
+}
+
+// shutdownSignal returns a context cancelled by the first SIGINT or
+// SIGTERM. A second signal exits at once, skipping the drain.
+func shutdownSignal(logger *slog.Logger) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		logger.Info("shutting down", "signal", s.String())
+		cancel()
+		s = <-sig
+		logger.Warn("second signal; exiting without draining", "signal", s.String())
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// reloadOnHangup re-reads the rate limit policy and, if authentication is
+// enabled, the auth configuration on every SIGHUP.
+func reloadOnHangup(logger *slog.Logger, limiter *ratelimit.Limiter, authn *auth.Authenticator) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := limiter.Reload(); err != nil {
+			logger.Error("reload rate limits", "err", err)
+		} else {
+			logger.Info("rate limits reloaded", "routes", len(limiter.Policy().Routes))
+		}
+		if authn == nil {
+			continue
+		}
+		if err := authn.Reload(); err != nil {
+			logger.Error("reload auth config", "err", err)
+		} else {
+			logger.Info("auth config reloaded")
+		}
+	}
+}
+
+func newLogger(cfg config.Conf) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, opts))
+}
@@ -0,0 +1,189 @@
+// Package auth authenticates callers with static API keys or locally
+// verified JWTs and authorizes them by the scope each mux pattern requires.
+// Nothing is fetched at request time: keys, secrets and the JWKS all come
+// from the configuration.
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Methods a Principal can have authenticated with.
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Subject is the API key's subject or the token's "sub" claim.
+	Subject string
+	Method  string
+	Scopes  []string
+}
+
+// Has reports whether p was granted scope.
+func (p *Principal) Has(scope string) bool {
+	return p != nil && slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	if t, ok := ctx.Value(trackKey{}).(*tracked); ok {
+		t.p = p
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+type trackKey struct{}
+
+// tracked is the per-request state Track sets up: the principal stored by
+// NewContext, and the outcome of verifying the request's credentials.
+type tracked struct {
+	p *Principal
+
+	checked bool
+	checkP  *Principal
+	checkE  error
+}
+
+// Track lets middleware that runs before authentication, such as the access
+// log, learn the principal once the request has been handled: the returned
+// func reports whatever NewContext later stored beneath ctx. Beneath ctx,
+// the credentials are also verified only once, however many middlewares
+// ask the Authenticator about them.
+func Track(ctx context.Context) (context.Context, func() *Principal) {
+	t := &tracked{}
+	return context.WithValue(ctx, trackKey{}, t), func() *Principal { return t.p }
+}
+
+// APIKey is one static key. Give either the key itself or, to keep it out
+// of the file, the hex SHA-256 of it.
+type APIKey struct {
+	Subject   string   `yaml:"subject"`
+	Key       string   `yaml:"key"`
+	KeySHA256 string   `yaml:"key_sha256"`
+	Scopes    []string `yaml:"scopes"`
+}
+
+// JWT configures bearer token verification. Tokens must be signed with
+// HS256 by HS256Secret or with RS256 by a key in JWKSFile, and must carry
+// an expiry. Scopes come from the space-separated "scope" claim or the
+// "scp" claim (string or array).
+type JWT struct {
+	HS256Secret string `yaml:"hs256_secret"`
+	JWKSFile    string `yaml:"jwks_file"`
+	// Issuer and Audience, if set, must match "iss" and "aud".
+	Issuer   string        `yaml:"issuer"`
+	Audience string        `yaml:"audience"`
+	Leeway   time.Duration `yaml:"leeway"`
+}
+
+// Config is the complete authentication configuration.
+type Config struct {
+	APIKeys []APIKey `yaml:"api_keys"`
+	// APIKeyHeader carries API keys; defaults to X-API-Key. Keys are also
+	// accepted as "Authorization: Bearer <key>".
+	APIKeyHeader string `yaml:"api_key_header"`
+	JWT          JWT    `yaml:"jwt"`
+	// AnonymousScopes are granted to requests without credentials, for
+	// example books:read to leave the catalog public.
+	AnonymousScopes []string `yaml:"anonymous_scopes"`
+}
+
+// Validate checks c and fills in defaults.
+func (c *Config) Validate() error {
+	if len(c.APIKeys) == 0 && c.JWT.HS256Secret == "" && c.JWT.JWKSFile == "" {
+		return errors.New("no api_keys and no jwt secret or jwks_file")
+	}
+	seen := make(map[string]bool, len(c.APIKeys))
+	for i := range c.APIKeys {
+		k := &c.APIKeys[i]
+		if k.Subject == "" {
+			return fmt.Errorf("api key %d: subject is required", i)
+		}
+		switch {
+		case k.Key != "" && k.KeySHA256 != "":
+			return fmt.Errorf("api key %q: set key or key_sha256, not both", k.Subject)
+		case k.Key != "":
+			sum := sha256.Sum256([]byte(k.Key))
+			k.KeySHA256 = hex.EncodeToString(sum[:])
+		case k.KeySHA256 == "":
+			return fmt.Errorf("api key %q: key or key_sha256 is required", k.Subject)
+		}
+		if b, err := hex.DecodeString(k.KeySHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("api key %q: key_sha256 must be 64 hex digits", k.Subject)
+		}
+		if seen[k.KeySHA256] {
+			return fmt.Errorf("api key %q: duplicate key", k.Subject)
+		}
+		seen[k.KeySHA256] = true
+	}
+	if c.JWT.Leeway < 0 {
+		return errors.New("jwt: leeway must not be negative")
+	}
+	if c.APIKeyHeader == "" {
+		c.APIKeyHeader = "X-API-Key"
+	}
+	return nil
+}
+
+// Parse reads a YAML or JSON configuration (JSON is valid YAML).
+func Parse(data []byte) (*Config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var c Config
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("parse auth config: %w", err)
+	}
+	if s := os.Getenv("AUTH_JWT_SECRET"); s != "" {
+		c.JWT.HS256Secret = s
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("parse auth config: %w", err)
+	}
+	return &c, nil
+}
+
+// LoadFile reads a configuration from a YAML or JSON file.
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Load reads the configuration from the file named by AUTH_CONFIG_FILE or
+// the inline document in AUTH_CONFIG; AUTH_JWT_SECRET, if set, replaces
+// jwt.hs256_secret. It returns nil when neither is set.
+func Load() (*Config, error) {
+	if path := os.Getenv("AUTH_CONFIG_FILE"); path != "" {
+		return LoadFile(path)
+	}
+	if doc := os.Getenv("AUTH_CONFIG"); doc != "" {
+		return Parse([]byte(doc))
+	}
+	return nil, nil
+}
+
+// ErrNoConfig is returned by Reload when no configuration source is set.
+var ErrNoConfig = errors.New("auth: AUTH_CONFIG_FILE and AUTH_CONFIG are unset")
@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func request(header, value string) *http.Request {
+	r := httptest.NewRequest("GET", "/books", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	return r
+}
+
+func TestValidateAPIKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []APIKey
+		want string // error substring; "" for none
+	}{
+		{"key is hashed", []APIKey{{Subject: "a", Key: "secret"}}, ""},
+		{"digest kept", []APIKey{{Subject: "a", KeySHA256: sha256Hex("secret")}}, ""},
+		{"no subject", []APIKey{{Key: "secret"}}, "subject is required"},
+		{"both", []APIKey{{Subject: "a", Key: "secret", KeySHA256: sha256Hex("secret")}}, "not both"},
+		{"neither", []APIKey{{Subject: "a"}}, "key or key_sha256 is required"},
+		{"short digest", []APIKey{{Subject: "a", KeySHA256: "abcd"}}, "64 hex digits"},
+		{"not hex", []APIKey{{Subject: "a", KeySHA256: strings.Repeat("z", 64)}}, "64 hex digits"},
+		{"duplicate", []APIKey{{Subject: "a", Key: "secret"}, {Subject: "b", KeySHA256: sha256Hex("secret")}}, "duplicate key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{APIKeys: tt.keys}
+			err := c.Validate()
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("Validate() = %v, want an error containing %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.APIKeys[0].KeySHA256; got != sha256Hex("secret") {
+				t.Errorf("KeySHA256 = %s, want the digest of the key", got)
+			}
+			if c.APIKeyHeader != "X-API-Key" {
+				t.Errorf("APIKeyHeader defaulted to %q", c.APIKeyHeader)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	c := &Config{APIKeys: []APIKey{
+		{Subject: "plain", Key: "plain-key", Scopes: []string{"books:read"}},
+		{Subject: "hashed", KeySHA256: sha256Hex("hashed-key"), Scopes: []string{"books:write"}},
+	}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, header, value string
+		want                string // subject; "" for no principal
+		wantErr             bool
+	}{
+		{"header", "X-API-Key", "plain-key", "plain", false},
+		{"digest only", "X-API-Key", "hashed-key", "hashed", false},
+		{"bearer", "Authorization", "Bearer hashed-key", "hashed", false},
+		{"bearer, any case", "Authorization", "bearer plain-key", "plain", false},
+		{"digest is not the key", "X-API-Key", sha256Hex("hashed-key"), "", true},
+		{"unknown", "X-API-Key", "guess", "", true},
+		{"other scheme", "Authorization", "Basic cGxhaW4ta2V5", "", false},
+		{"none", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authenticate(request(tt.header, tt.value))
+			if tt.wantErr != (err != nil) || (err != nil && !errors.Is(err, errInvalidCredentials)) {
+				t.Fatalf("Authenticate() error = %v, want error %v", err, tt.wantErr)
+			}
+			var got string
+			if p != nil {
+				got = p.Subject
+				if p.Method != MethodAPIKey {
+					t.Errorf("method %q", p.Method)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("subject %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// jwksFile writes the public halves of keys, by kid, as a JWKS file.
+func jwksFile(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, k := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func genKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	secret := []byte("hs256-test-secret")
+	k1 := genKey(t)
+	now := time.Now()
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "alice", "exp": now.Add(time.Hour).Unix()}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	hs := func(extra jwt.MapClaims) string { return sign(t, jwt.SigningMethodHS256, "", secret, claims(extra)) }
+	rs := func(kid string, extra jwt.MapClaims) string {
+		return sign(t, jwt.SigningMethodRS256, kid, k1, claims(extra))
+	}
+	pub := k1.N.Bytes() // public: anyone can read it from the JWKS
+
+	c := &Config{JWT: JWT{
+		HS256Secret: string(secret),
+		JWKSFile:    jwksFile(t, map[string]*rsa.PrivateKey{"k1": k1}),
+		Leeway:      30 * time.Second,
+		Issuer:      "https://issuer.example",
+	}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := jwt.MapClaims{"iss": "https://issuer.example"}
+	with := func(m jwt.MapClaims) jwt.MapClaims {
+		out := jwt.MapClaims{"iss": "https://issuer.example"}
+		for k, v := range m {
+			out[k] = v
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		scopes []string // nil with ok false means rejected
+		ok     bool
+	}{
+		{"HS256", hs(iss), nil, true},
+		{"RS256 by kid", rs("k1", iss), nil, true},
+		{"RS256 without kid, one key", rs("", iss), nil, true},
+		{"RS256 unknown kid", rs("k9", iss), nil, false},
+		{"scope claim", hs(with(jwt.MapClaims{"scope": "books:read books:write"})), []string{"books:read", "books:write"}, true},
+		{"scp string", hs(with(jwt.MapClaims{"scp": "books:read"})), []string{"books:read"}, true},
+		{"scp array", hs(with(jwt.MapClaims{"scp": []string{"books:read", "triggers:run"}})), []string{"books:read", "triggers:run"}, true},
+		{"scope wins over scp", hs(with(jwt.MapClaims{"scope": "books:read", "scp": "books:write"})), []string{"books:read"}, true},
+		{"expired", hs(with(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()})), nil, false},
+		{"expired within leeway", hs(with(jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()})), nil, true},
+		{"no exp", hs(with(jwt.MapClaims{"exp": nil})), nil, false},
+		{"nbf in the future", hs(with(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()})), nil, false},
+		{"nbf within leeway", hs(with(jwt.MapClaims{"nbf": now.Add(10 * time.Second).Unix()})), nil, true},
+		{"no sub", hs(with(jwt.MapClaims{"sub": nil})), nil, false},
+		{"wrong issuer", hs(jwt.MapClaims{"iss": "https://evil.example"}), nil, false},
+		{"bad signature", hs(iss)[:len(hs(iss))-4] + "AAAA", nil, false},
+		{"HS256 keyed with the RSA modulus", sign(t, jwt.SigningMethodHS256, "k1", pub, claims(iss)), nil, false},
+		{"HS384", sign(t, jwt.SigningMethodHS384, "", secret, claims(iss)), nil, false},
+		{"alg none", sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, claims(iss)), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authenticate(request("Authorization", "Bearer "+tt.token))
+			if !tt.ok {
+				if err == nil || !errors.Is(err, errInvalidCredentials) {
+					t.Fatalf("Authenticate() = %+v, %v; want invalid credentials", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Subject != "alice" || p.Method != MethodJWT || !slices.Equal(p.Scopes, tt.scopes) {
+				t.Errorf("principal %+v, want alice via jwt with scopes %v", p, tt.scopes)
+			}
+		})
+	}
+}
+
+func TestJWTAlgorithmMustBeConfigured(t *testing.T) {
+	k := genKey(t)
+	exp := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	tests := []struct {
+		name  string
+		conf  JWT
+		token string
+	}{
+		{"HS256 without a secret", JWT{JWKSFile: jwksFile(t, map[string]*rsa.PrivateKey{"k": k})},
+			sign(t, jwt.SigningMethodHS256, "", []byte("anything"), exp)},
+		{"RS256 without a JWKS", JWT{HS256Secret: "secret"}, sign(t, jwt.SigningMethodRS256, "k", k, exp)},
+		{"no JWT at all", JWT{}, sign(t, jwt.SigningMethodHS256, "", []byte("secret"), exp)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{APIKeys: []APIKey{{Subject: "k", Key: "key"}}, JWT: tt.conf}
+			if err := c.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			a, err := New(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p, err := a.Authenticate(request("Authorization", "Bearer "+tt.token)); err == nil {
+				t.Fatalf("Authenticate() = %+v, want rejection", p)
+			}
+		})
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	old, next := genKey(t), genKey(t)
+	exp := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	oldTok := sign(t, jwt.SigningMethodRS256, "old", old, exp)
+	nextTok := sign(t, jwt.SigningMethodRS256, "next", next, exp)
+	a := &Authenticator{}
+	for _, step := range []struct {
+		keys      map[string]*rsa.PrivateKey
+		oldOK, ok bool
+	}{
+		{map[string]*rsa.PrivateKey{"old": old}, true, false},
+		{map[string]*rsa.PrivateKey{"old": old, "next": next}, true, true},
+		{map[string]*rsa.PrivateKey{"next": next}, false, true},
+	} {
+		c := &Config{JWT: JWT{JWKSFile: jwksFile(t, step.keys)}}
+		if err := c.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.SetConfig(c); err != nil {
+			t.Fatal(err)
+		}
+		for tok, want := range map[string]bool{oldTok: step.oldOK, nextTok: step.ok} {
+			_, err := a.Authenticate(request("Authorization", "Bearer "+tok))
+			if (err == nil) != want {
+				t.Errorf("keys %v: token accepted %v, want %v (%v)", slices.Sorted(maps.Keys(step.keys)), err == nil, want, err)
+			}
+		}
+	}
+
+	// A broken JWKS keeps the keys in force.
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"keys":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetConfig(&Config{JWT: JWT{JWKSFile: bad}}); err == nil {
+		t.Fatal("SetConfig with no signing keys succeeded")
+	}
+	if _, err := a.Authenticate(request("Authorization", "Bearer "+nextTok)); err != nil {
+		t.Errorf("after a failed reload: %v", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	c := &Config{
+		APIKeys: []APIKey{
+			{Subject: "reader", Key: "read-key", Scopes: []string{"books:read"}},
+			{Subject: "writer", Key: "write-key", Scopes: []string{"books:read", "books:write"}},
+		},
+		AnonymousScopes: []string{"books:read"},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	var got *Principal
+	ok := func(w http.ResponseWriter, r *http.Request) { got, _ = FromContext(r.Context()) }
+	mux.HandleFunc("GET /books", ok)
+	mux.HandleFunc("POST /books", ok)
+	mux.HandleFunc("GET /livez", ok)
+	h := a.Wrap(mux, map[string]string{"GET /books": "books:read", "POST /books": "books:write"}, mux)
+
+	tests := []struct {
+		name, method, path, key string
+		status                  int
+		subject                 string
+		challenge               string // in WWW-Authenticate
+	}{
+		{"anonymous read", "GET", "/books", "", http.StatusOK, "", ""},
+		{"anonymous write", "POST", "/books", "", http.StatusUnauthorized, "", `Bearer realm="http-rest"`},
+		{"reader writes", "POST", "/books", "read-key", http.StatusForbidden, "", `error="insufficient_scope", scope="books:write"`},
+		{"writer writes", "POST", "/books", "write-key", http.StatusOK, "writer", ""},
+		{"reader reads", "GET", "/books", "read-key", http.StatusOK, "reader", ""},
+		{"bad key on a public route", "GET", "/livez", "guess", http.StatusUnauthorized, "", `error="invalid_token"`},
+		{"public route", "GET", "/livez", "", http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusOK {
+				if (got == nil) != (tt.subject == "") || (got != nil && got.Subject != tt.subject) {
+					t.Errorf("principal %+v, want subject %q", got, tt.subject)
+				}
+				return
+			}
+			if ch := rec.Header().Get("WWW-Authenticate"); !strings.Contains(ch, tt.challenge) {
+				t.Errorf("WWW-Authenticate %q, want %q", ch, tt.challenge)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type %q", ct)
+			}
+		})
+	}
+}
+
+// TestAuthenticatesOnce checks that Caller, as the rate limiter calls it,
+// and Wrap share one verification per tracked request.
+func TestAuthenticatesOnce(t *testing.T) {
+	c := &Config{APIKeys: []APIKey{{Subject: "ci", Key: "ci-key", Scopes: []string{"books:write"}}}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /books", func(http.ResponseWriter, *http.Request) {})
+	h := a.Wrap(mux, map[string]string{"POST /books": "books:write"}, mux)
+
+	req := httptest.NewRequest("POST", "/books", nil)
+	req.Header.Set("X-API-Key", "ci-key")
+	ctx, principal := Track(req.Context())
+	req = req.WithContext(ctx)
+	if got := a.Caller(req); got != "api_key:ci" {
+		t.Fatalf("Caller() = %q", got)
+	}
+	// Were the key checked again, the emptied configuration would reject it.
+	if err := a.SetConfig(&Config{}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || principal() == nil {
+		t.Errorf("status %d, principal %+v; want the cached principal let through", rec.Code, principal())
+	}
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"http-rest/internal/problem"
+)
+
+// errInvalidCredentials is returned for credentials that were presented but
+// do not check out; the caller is not told which part failed.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// failures counts rejected requests by reason: unauthenticated,
+// invalid_credentials or insufficient_scope.
+var failures = expvar.NewMap("http_auth_failures")
+
+// Authenticator verifies credentials against a Config. The configuration
+// can be replaced at any time with SetConfig.
+type Authenticator struct {
+	state atomic.Pointer[state]
+}
+
+// state is a Config compiled for lookups.
+type state struct {
+	keys      map[[sha256.Size]byte]*APIKey
+	header    string
+	anonymous []string
+	secret    []byte
+	rsa       map[string]*rsa.PublicKey
+	parser    *jwt.Parser
+}
+
+// New returns an authenticator for c, which must have been validated.
+func New(c *Config) (*Authenticator, error) {
+	a := &Authenticator{}
+	if err := a.SetConfig(c); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetConfig compiles c, reading its JWKS file, and installs it. On error
+// the configuration in force is kept.
+func (a *Authenticator) SetConfig(c *Config) error {
+	s := &state{
+		keys:      make(map[[sha256.Size]byte]*APIKey, len(c.APIKeys)),
+		header:    c.APIKeyHeader,
+		anonymous: c.AnonymousScopes,
+		secret:    []byte(c.JWT.HS256Secret),
+	}
+	for i := range c.APIKeys {
+		k := &c.APIKeys[i]
+		var sum [sha256.Size]byte
+		if _, err := hex.Decode(sum[:], []byte(k.KeySHA256)); err != nil {
+			return fmt.Errorf("api key %q: %w", k.Subject, err)
+		}
+		s.keys[sum] = k
+	}
+	var methods []string
+	if len(s.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if c.JWT.JWKSFile != "" {
+		keys, err := loadJWKS(c.JWT.JWKSFile)
+		if err != nil {
+			return err
+		}
+		s.rsa = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		a.state.Store(s) // API keys only; every token is rejected
+		return nil
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(c.JWT.Leeway),
+	}
+	if c.JWT.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.JWT.Issuer))
+	}
+	if c.JWT.Audience != "" {
+		opts = append(opts, jwt.WithAudience(c.JWT.Audience))
+	}
+	s.parser = jwt.NewParser(opts...)
+	a.state.Store(s)
+	return nil
+}
+
+// Reload re-reads the configuration with Load and installs it.
+func (a *Authenticator) Reload() error {
+	c, err := Load()
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return ErrNoConfig
+	}
+	return a.SetConfig(c)
+}
+
+// Authenticate returns the principal of r's credentials, or nil if it has
+// none. A bearer token that looks like a JWT is verified as one; any other
+// bearer token, or the API key header, is looked up as an API key.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	s := a.state.Load()
+	if tok, ok := bearer(r); ok {
+		if strings.Count(tok, ".") == 2 {
+			return s.verifyJWT(tok)
+		}
+		return s.lookupKey(tok)
+	}
+	if key := r.Header.Get(s.header); key != "" {
+		return s.lookupKey(key)
+	}
+	return nil, nil
+}
+
+// authenticate is Authenticate, run once per request tracked by Track: the
+// rate limiter's Caller and Wrap share the outcome instead of verifying the
+// credentials, a JWT signature say, twice.
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	t, ok := r.Context().Value(trackKey{}).(*tracked)
+	if ok && t.checked {
+		return t.checkP, t.checkE
+	}
+	p, err := a.Authenticate(r)
+	if ok {
+		t.checked, t.checkP, t.checkE = true, p, err
+	}
+	return p, err
+}
+
+// Caller names the principal of r's credentials as "method:subject", or
+// returns "" if r has none or they do not authenticate. It suits keying
+// per-caller state, such as rate limits, that must not grow with made-up
+// credentials.
+func (a *Authenticator) Caller(r *http.Request) string {
+	p, err := a.authenticate(r)
+	if err != nil || p == nil {
+		return ""
+	}
+	return p.Method + ":" + p.Subject
+}
+
+func bearer(r *http.Request) (string, bool) {
+	scheme, tok, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	tok = strings.TrimSpace(tok)
+	return tok, tok != ""
+}
+
+// lookupKey finds key by its digest, so lookup time does not depend on how
+// much of the key matches a configured one.
+func (s *state) lookupKey(key string) (*Principal, error) {
+	k, ok := s.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	return &Principal{Subject: k.Subject, Method: MethodAPIKey, Scopes: k.Scopes}, nil
+}
+
+func (s *state) verifyJWT(raw string) (*Principal, error) {
+	if s.parser == nil {
+		return nil, fmt.Errorf("%w: tokens are not accepted", errInvalidCredentials)
+	}
+	claims := jwt.MapClaims{}
+	_, err := s.parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		switch t.Method.Alg() {
+		case jwt.SigningMethodHS256.Alg():
+			if len(s.secret) > 0 {
+				return s.secret, nil
+			}
+		case jwt.SigningMethodRS256.Alg():
+			kid, _ := t.Header["kid"].(string)
+			if key, ok := s.rsa[kid]; ok {
+				return key, nil
+			}
+			if kid == "" && len(s.rsa) == 1 {
+				for _, key := range s.rsa {
+					return key, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return nil, fmt.Errorf("unexpected alg %s", t.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidCredentials, err)
+	}
+	sub, _ := claims.GetSubject()
+	if sub == "" {
+		return nil, fmt.Errorf("%w: token has no subject", errInvalidCredentials)
+	}
+	return &Principal{Subject: sub, Method: MethodJWT, Scopes: tokenScopes(claims)}, nil
+}
+
+// tokenScopes reads the OAuth 2.0 "scope" claim or the "scp" claim.
+func tokenScopes(claims jwt.MapClaims) []string {
+	if s, ok := claims["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	switch v := claims["scp"].(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Wrap authorizes requests to next by the scope scopes maps their mux
+// pattern to. Patterns without a scope are public. Authenticated requests
+// carry their Principal in the context.
+func (a *Authenticator) Wrap(mux *http.ServeMux, scopes map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		scope, protected := scopes[pattern]
+
+		p, err := a.authenticate(r)
+		switch {
+		case err != nil:
+			// Bad credentials are rejected even on public routes so that
+			// clients learn about them.
+			failures.Add("invalid_credentials", 1)
+			challenge(w, r, http.StatusUnauthorized, `error="invalid_token"`,
+				problem.Problem{Type: "/problems/unauthenticated", Title: "Unauthenticated", Detail: "the credentials are invalid or expired"})
+			return
+		case p != nil:
+			r = r.WithContext(NewContext(r.Context(), p))
+		}
+		if !protected || p.Has(scope) || (p == nil && slices.Contains(a.state.Load().anonymous, scope)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if p == nil {
+			failures.Add("unauthenticated", 1)
+			challenge(w, r, http.StatusUnauthorized, "",
+				problem.Problem{Type: "/problems/unauthenticated", Title: "Unauthenticated", Detail: "this route requires the " + scope + " scope; send an API key or bearer token"})
+			return
+		}
+		failures.Add("insufficient_scope", 1)
+		challenge(w, r, http.StatusForbidden, fmt.Sprintf(`error="insufficient_scope", scope=%q`, scope),
+			problem.Problem{Type: "/problems/forbidden", Title: "Forbidden", Detail: fmt.Sprintf("%s lacks the %s scope", p.Subject, scope)})
+	})
+}
+
+func challenge(w http.ResponseWriter, r *http.Request, status int, params string, p problem.Problem) {
+	v := `Bearer realm="http-rest"`
+	if params != "" {
+		v += ", " + params
+	}
+	w.Header().Set("WWW-Authenticate", v)
+	p.Status = status
+	problem.Write(w, r, &p)
+}
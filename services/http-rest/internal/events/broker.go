@@ -0,0 +1,229 @@
+// Package events fans book changes out to live subscribers, such as the
+// GET /books/events stream, and keeps a bounded history so a subscriber
+// that reconnects can resume where it left off.
+package events
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"http-rest/internal/types"
+)
+
+// Type is the kind of change an event reports.
+type Type string
+
+const (
+	Created Type = "created"
+	Updated Type = "updated"
+	Deleted Type = "deleted"
+)
+
+// Event is one change. Deleted events carry the book as it was last
+// stored.
+type Event struct {
+	ID   uint64
+	Type Type
+	Time time.Time
+	Book types.Book
+}
+
+var (
+	// ErrSlow ends a subscription whose buffer filled up; the subscriber
+	// should resubscribe from the last event it handled.
+	ErrSlow = errors.New("events: subscriber fell behind")
+	// ErrClosed ends every subscription when the broker closes.
+	ErrClosed = errors.New("events: broker closed")
+	// ErrGap reports that events after the requested ID have already left
+	// the history, or were never published by this broker.
+	ErrGap = errors.New("events: history does not reach back to the requested event")
+)
+
+var slowDrops = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "book_event_subscribers_dropped_total",
+	Help: "Event subscriptions ended for falling behind.",
+})
+
+// Options configures a Broker.
+type Options struct {
+	// History is how many recent events are kept for resuming; default 1000.
+	History int
+	// Buffer is how many events a subscriber may lag behind before it is
+	// dropped; default 64.
+	Buffer int
+	// FirstID is the ID of the first event. Zero uses the current Unix
+	// time in microseconds, so IDs keep growing across restarts and an ID
+	// from an earlier process is never mistaken for a recent one.
+	FirstID uint64
+}
+
+// Broker publishes events to subscribers without ever waiting for them: a
+// subscriber whose buffer is full is dropped with ErrSlow instead. It is
+// safe for concurrent use.
+type Broker struct {
+	opts    Options
+	mu      sync.Mutex
+	next    uint64
+	history []Event // oldest first, at most opts.History
+	subs    map[*Subscription]struct{}
+	forward []func(Event)
+	closed  bool
+}
+
+func NewBroker(opts Options) *Broker {
+	if opts.History <= 0 {
+		opts.History = 1000
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 64
+	}
+	if opts.FirstID == 0 {
+		opts.FirstID = uint64(time.Now().UnixMicro())
+	}
+	return &Broker{opts: opts, next: opts.FirstID, subs: make(map[*Subscription]struct{})}
+}
+
+// Publish records a change and offers it to every matching subscriber.
+// Callers that need events in the order their writes were applied must
+// serialise their calls, as Store does.
+func (b *Broker) Publish(t Type, book types.Book) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := Event{ID: b.next, Type: t, Time: time.Now(), Book: book}
+	b.next++
+	for _, f := range b.forward {
+		f(e)
+	}
+	if len(b.history) == b.opts.History {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:len(b.history)-1]
+	}
+	b.history = append(b.history, e)
+	for s := range b.subs {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			slowDrops.Inc()
+			b.endLocked(s, ErrSlow)
+		}
+	}
+	return e
+}
+
+// Forward calls f with every event published from now on, in order. f
+// runs while publishing and must not block; unlike a subscriber it is
+// never dropped, even after Close.
+func (b *Broker) Forward(f func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.forward = append(b.forward, f)
+}
+
+// LastID returns the ID of the latest event, or FirstID-1 before any.
+func (b *Broker) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.next - 1
+}
+
+// Subscribers returns the number of live subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Subscribe starts a subscription to the events match accepts; a nil
+// match accepts all. With resume set, the matching events after lastID
+// still in the history are returned for the caller to handle first; if
+// the history no longer reaches back that far the subscription still
+// starts and the error is ErrGap, so the caller can tell the subscriber
+// to reload. Replay and subscription are atomic: no event is missed or
+// seen twice.
+func (b *Broker) Subscribe(match func(Event) bool, resume bool, lastID uint64) (*Subscription, []Event, error) {
+	if match == nil {
+		match = func(Event) bool { return true }
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &Subscription{broker: b, match: match, ch: make(chan Event, b.opts.Buffer)}
+	if b.closed {
+		s.err = ErrClosed
+		close(s.ch)
+		return s, nil, ErrClosed
+	}
+	b.subs[s] = struct{}{}
+	if !resume {
+		return s, nil, nil
+	}
+	// History is contiguous, so lastID is covered when it is the event
+	// just before the oldest one kept, or any later published event.
+	oldest := b.next
+	if len(b.history) > 0 {
+		oldest = b.history[0].ID
+	}
+	if lastID+1 < oldest || lastID >= b.next {
+		return s, nil, ErrGap
+	}
+	var replay []Event
+	for _, e := range b.history {
+		if e.ID > lastID && match(e) {
+			replay = append(replay, e)
+		}
+	}
+	return s, replay, nil
+}
+
+// Close ends every subscription with ErrClosed; later subscriptions end at
+// once. Publishing still records history.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		b.endLocked(s, ErrClosed)
+	}
+}
+
+func (b *Broker) endLocked(s *Subscription, err error) {
+	if _, ok := b.subs[s]; !ok {
+		return
+	}
+	delete(b.subs, s)
+	s.err = err
+	close(s.ch)
+}
+
+// Subscription receives events on C until it is closed.
+type Subscription struct {
+	broker *Broker
+	match  func(Event) bool
+	ch     chan Event
+	err    error // guarded by broker.mu; set before ch closes
+}
+
+// C delivers events in ID order. It is closed when the subscription ends;
+// Err then says why.
+func (s *Subscription) C() <-chan Event { return s.ch }
+
+// Err returns ErrSlow or ErrClosed once C is closed, and nil before or
+// after Close.
+func (s *Subscription) Err() error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.endLocked(s, nil)
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"http-rest/internal/types"
+)
+
+// kafkaNames are the event types consumers see on the Kafka topic.
+var kafkaNames = map[Type]string{
+	Created: "BookCreated",
+	Updated: "BookUpdated",
+	Deleted: "BookDeleted",
+}
+
+// kafkaEvent is the value of each Kafka message. EventID is the same ID
+// GET /books/events uses, so consumers can deduplicate redeliveries.
+type kafkaEvent struct {
+	Type    string     `json:"type"`
+	EventID uint64     `json:"event_id"`
+	BookID  int64      `json:"book_id"`
+	Time    time.Time  `json:"time"`
+	Book    types.Book `json:"book"`
+}
+
+// KafkaMessage encodes e for the book events topic. The key is the book
+// ID, so with a hashing balancer all events of a book land on one
+// partition and are consumed in order.
+func KafkaMessage(e Event) kafka.Message {
+	name := kafkaNames[e.Type]
+	value, _ := json.Marshal(kafkaEvent{Type: name, EventID: e.ID, BookID: e.Book.ID, Time: e.Time, Book: e.Book})
+	return kafka.Message{
+		Key:   []byte(strconv.FormatInt(e.Book.ID, 10)),
+		Value: value,
+		Time:  e.Time,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte("application/json")},
+			{Key: "event-type", Value: []byte(name)},
+			{Key: "event-id", Value: []byte(strconv.FormatUint(e.ID, 10))},
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"http-rest/internal/events"
+	"http-rest/internal/outbox"
+	"http-rest/internal/types"
+)
+
+// TestKafkaForwarding publishes book events through an outbox whose first
+// writes fail, wholly and then for one partition, and checks that every
+// event reaches Kafka exactly once, keyed by book ID and in order within
+// each partition.
+func TestKafkaForwarding(t *testing.T) {
+	const topic = "books"
+	kafkaStub := outbox.NewMemory()
+	kafkaStub.Partitions = 3
+	kafkaStub.FailNext(errors.New("broker unavailable"))
+	kafkaStub.FailPartition(1, kafka.LeaderNotAvailable)
+	ob := outbox.New(kafkaStub.Writer(topic), outbox.Options{Topic: topic, MinBackoff: time.Millisecond}, slog.New(slog.DiscardHandler))
+	broker := events.NewBroker(events.Options{FirstID: 1})
+	defer broker.Close()
+	broker.Forward(func(e events.Event) { ob.Enqueue(events.KafkaMessage(e)) })
+
+	for id := int64(1); id <= 6; id++ {
+		book := types.Book{ID: id, Title: "Book " + strconv.FormatInt(id, 10)}
+		broker.Publish(events.Created, book)
+		if id%2 == 0 {
+			broker.Publish(events.Updated, book)
+		}
+		if id%3 == 0 {
+			broker.Publish(events.Deleted, book)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ob.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[uint64]bool)
+	last := make(map[int]uint64) // partition -> event ID
+	for _, m := range kafkaStub.Messages(topic) {
+		var v struct {
+			Type    string `json:"type"`
+			EventID uint64 `json:"event_id"`
+			BookID  int64  `json:"book_id"`
+		}
+		if err := json.Unmarshal(m.Value, &v); err != nil {
+			t.Fatalf("offset %d/%d: %v", m.Partition, m.Offset, err)
+		}
+		switch {
+		case v.Type != "BookCreated" && v.Type != "BookUpdated" && v.Type != "BookDeleted":
+			t.Errorf("event %d: unknown type %q", v.EventID, v.Type)
+		case string(m.Key) != strconv.FormatInt(v.BookID, 10):
+			t.Errorf("event %d: key %q for book %d", v.EventID, m.Key, v.BookID)
+		case seen[v.EventID]:
+			t.Errorf("event %d: delivered twice", v.EventID)
+		case v.EventID < last[m.Partition]:
+			t.Errorf("event %d: after event %d in partition %d", v.EventID, last[m.Partition], m.Partition)
+		}
+		seen[v.EventID] = true
+		last[m.Partition] = v.EventID
+	}
+	if got, want := uint64(len(seen)), broker.LastID(); got != want {
+		t.Errorf("%d events delivered, want %d", got, want)
+	}
+}
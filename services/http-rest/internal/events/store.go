@@ -0,0 +1,72 @@
+package events
+
+import (
+	"context"
+	"sync"
+
+	"http-rest/internal/store"
+	"http-rest/internal/types"
+)
+
+// Store is a BookStore that publishes every successful write to a Broker.
+// Like search.IndexedStore it only sees writes made through this process.
+type Store struct {
+	store.BookStore
+	broker *Broker
+	// mu orders writes so events carry IDs in the store's order.
+	mu sync.Mutex
+}
+
+func NewStore(s store.BookStore, b *Broker) *Store {
+	return &Store{BookStore: s, broker: b}
+}
+
+func (s *Store) Create(ctx context.Context, b types.Book) (types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.BookStore.Create(ctx, b)
+	if err == nil {
+		s.broker.Publish(Created, b)
+	}
+	return b, err
+}
+
+func (s *Store) CreateMany(ctx context.Context, books []types.Book) ([]types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out, err := s.BookStore.CreateMany(ctx, books)
+	if err == nil {
+		for _, b := range out {
+			s.broker.Publish(Created, b)
+		}
+	}
+	return out, err
+}
+
+func (s *Store) Update(ctx context.Context, id int64, fn func(*types.Book) error) (types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.BookStore.Update(ctx, id, fn)
+	if err == nil {
+		s.broker.Publish(Updated, b)
+	}
+	return b, err
+}
+
+// Delete always gives the store a check, to learn the book it deletes.
+func (s *Store) Delete(ctx context.Context, id int64, check func(types.Book) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last types.Book
+	err := s.BookStore.Delete(ctx, id, func(b types.Book) error {
+		last = b
+		if check != nil {
+			return check(b)
+		}
+		return nil
+	})
+	if err == nil {
+		s.broker.Publish(Deleted, last)
+	}
+	return err
+}
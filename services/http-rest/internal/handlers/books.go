@@ -1,117 +1,493 @@
 package handlers
 
 import (
+	"cmp"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"golang.org/x/text/language"
+
+	"http-rest/internal/events"
+	"http-rest/internal/isbn"
+	"http-rest/internal/problem"
+	"http-rest/internal/search"
+	"http-rest/internal/store"
 	"http-rest/internal/types"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxBodyBytes    = 1 << 20
+
+	maxTitleLen     = 200
+	maxAuthorLen    = 100
+	maxAuthors      = 20
+	maxPublisherLen = 100
+	maxTags         = 20
+	maxTagLen       = 50
+)
+
 type BookHandler struct {
-	mu   sync.RWMutex
-	data map[int64]types.Book
-	next int64
+	store store.BookStore
+
+	// DefaultPageSize and MaxPageSize bound GET /books; zero uses the defaults.
+	DefaultPageSize int
+	MaxPageSize     int
+	// MaxBodyBytes caps request bodies and import lines; zero uses 1 MiB.
+	MaxBodyBytes int64
+	// MaxImportBytes caps POST /books:import bodies; zero uses 32 MiB.
+	MaxImportBytes int64
+	// Index serves GET /books:search and must be kept in step with the
+	// store, as search.IndexedStore does; nil answers 501.
+	Index *search.Index
+	// Events serves GET /books/events and must see every write, as
+	// events.Store arranges; nil answers 501.
+	Events *events.Broker
+	// EventHeartbeat is how often an idle event stream sends a comment to
+	// keep proxies from closing it; zero uses 15s.
+	EventHeartbeat time.Duration
+	// Logger records store failures; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+// bookInput is the body accepted by POST and PUT /books and the document
+// PATCH merges into. Clients that predate multiple authors send only
+// author; newer ones may send authors, whose first entry author then
+// names.
+type bookInput struct {
+	Title     string   `json:"title"`
+	Author    string   `json:"author,omitempty"`
+	Authors   []string `json:"authors,omitempty"`
+	ISBN      string   `json:"isbn,omitempty"`
+	Year      int      `json:"year,omitempty"`
+	Publisher string   `json:"publisher,omitempty"`
+	Language  string   `json:"language,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
 }
 
-func NewBookHandler() *BookHandler {
-	return &BookHandler{data: make(map[int64]types.Book), next: 1}
+// inputFor is the editable part of b.
+func inputFor(b types.Book) bookInput {
+	return bookInput{
+		Title: b.Title, Author: b.Author, Authors: slices.Clone(b.Authors),
+		ISBN: b.ISBN, Year: b.Year, Publisher: b.Publisher, Language: b.Language, Tags: slices.Clone(b.Tags),
+	}
 }
 
-func (h *BookHandler) Register(mux *http.ServeMux) {
-	mux.HandleFunc("POST /books", h.create)
-	mux.HandleFunc("GET /books", h.list)     // supports ?author= and ?q=
-	mux.HandleFunc("GET /books/{id}", h.get) // path param
-	mux.HandleFunc("PUT /books/{id}", h.update)
-	mux.HandleFunc("DELETE /books/{id}", h.delete)
+// validate applies the rules for a new book, trimming every field and
+// putting author and authors, the ISBN, the language tag and the tags into
+// the form they are stored in.
+func (in *bookInput) validate() []FieldError {
+	var errs []FieldError
+	errs = checkText(errs, "title", in.Title, true, maxTitleLen)
+	in.Title = strings.TrimSpace(in.Title)
 
-	// NEW Trigger routes
-	mux.HandleFunc("/trigger/db", TriggerDBOperations)
-	mux.HandleFunc("/trigger/kafka", TriggerKafkaProducer)
-	mux.HandleFunc("/trigger/grpcunary", TriggerGRPCUnaryOperation)
-	mux.HandleFunc("/trigger/grpcstream", TriggerGRPCStreamOperation)
-	mux.HandleFunc("/trigger/allservices", TriggerAllServices)
+	in.Author = strings.TrimSpace(in.Author)
+	errs = checkText(errs, "author", in.Author, len(in.Authors) == 0, maxAuthorLen)
+	if len(in.Authors) > maxAuthors {
+		errs = append(errs, FieldError{Field: "authors", Message: fmt.Sprintf("must have at most %d entries", maxAuthors)})
+	}
+	for i, a := range in.Authors {
+		field := fmt.Sprintf("authors[%d]", i)
+		errs = checkText(errs, field, a, true, maxAuthorLen)
+		in.Authors[i] = strings.TrimSpace(a)
+		if slices.Contains(in.Authors[:i], in.Authors[i]) {
+			errs = append(errs, FieldError{Field: field, Message: "repeats an earlier author"})
+		}
+	}
+	switch {
+	case len(in.Authors) == 0 && in.Author != "":
+		in.Authors = []string{in.Author}
+	case len(in.Authors) > 0 && in.Author == "":
+		in.Author = in.Authors[0]
+	case len(in.Authors) > 0 && in.Author != in.Authors[0]:
+		errs = append(errs, FieldError{Field: "author", Message: "must be the first of authors"})
+	}
+
+	if in.ISBN != "" {
+		n, err := isbn.Normalize(in.ISBN)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "isbn", Message: "must be a valid ISBN-10 or ISBN-13"})
+		}
+		in.ISBN = n
+	}
+	if last := time.Now().Year() + 1; in.Year != 0 && (in.Year < 1 || in.Year > last) {
+		errs = append(errs, FieldError{Field: "year", Message: fmt.Sprintf("must be between 1 and %d", last)})
+	}
+	errs = checkText(errs, "publisher", in.Publisher, false, maxPublisherLen)
+	in.Publisher = strings.TrimSpace(in.Publisher)
+	if in.Language != "" {
+		tag, ok := languageTag(in.Language)
+		if !ok {
+			errs = append(errs, FieldError{Field: "language", Message: "must be a BCP 47 language tag such as en or pt-BR"})
+		}
+		in.Language = tag
+	}
+
+	if len(in.Tags) > maxTags {
+		errs = append(errs, FieldError{Field: "tags", Message: fmt.Sprintf("must have at most %d entries", maxTags)})
+	}
+	for i, t := range in.Tags {
+		errs = checkText(errs, fmt.Sprintf("tags[%d]", i), t, true, maxTagLen)
+		in.Tags[i] = strings.TrimSpace(t)
+	}
+	slices.Sort(in.Tags)
+	in.Tags = slices.Compact(in.Tags)
+	return errs
+}
+
+// languageTag returns the canonical form of a BCP 47 language tag.
+func languageTag(s string) (string, bool) {
+	tag, err := language.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", false
+	}
+	return tag.String(), true
+}
+
+// book is the new book described by a validated input.
+func (in bookInput) book(added time.Time) types.Book {
+	b := types.Book{Added: added}
+	in.apply(&b)
+	return b
 }
 
+// apply copies a validated input onto b.
+func (in bookInput) apply(b *types.Book) {
+	b.Title, b.Author, b.Authors = in.Title, in.Author, in.Authors
+	b.ISBN, b.Year, b.Publisher, b.Language, b.Tags = in.ISBN, in.Year, in.Publisher, in.Language, in.Tags
+}
+
+func (h *BookHandler) bodyLimit() int64 {
+	return cmp.Or(h.MaxBodyBytes, maxBodyBytes)
+}
+
+func NewBookHandler(s store.BookStore) *BookHandler {
+	return &BookHandler{store: s}
+}
+
+func (h *BookHandler) routes() []route {
+	return []route{
+		{"POST /books", ScopeBooksWrite, h.create},
+		{"GET /books", ScopeBooksRead, h.list},                  // filters (?author=, ?q=, ?isbn=, ?year=, ?tag= ...), ?sort=, ?order=, ?limit=, ?page_token=
+		{"POST /books:import", ScopeBooksWrite, h.importBooks},  // NDJSON or CSV, ?mode=all-or-nothing|best-effort
+		{"GET /books:export", ScopeBooksRead, h.exportBooks},    // same filters as GET /books, ?format=ndjson|csv
+		{"GET /books:search", ScopeBooksRead, h.search},         // ranked ?q=, ?author=, ?facets=author, ?limit=, ?offset=
+		{"GET /books/events", ScopeBooksRead, h.events},         // SSE change feed, Last-Event-ID resume, same filters as GET /books
+		{"GET /books/{id}", ScopeBooksRead, h.get},              // path param
+		{"GET /books/isbn/{isbn}", ScopeBooksRead, h.getByISBN}, // ISBN-10 or ISBN-13, hyphens allowed
+		{"PUT /books/{id}", ScopeBooksWrite, h.update},
+		{"PATCH /books/{id}", ScopeBooksWrite, h.patch}, // JSON Merge Patch (RFC 7396)
+		{"DELETE /books/{id}", ScopeBooksWrite, h.delete},
+	}
+}
+
+func (h *BookHandler) Register(mux Mux) { register(mux, h.routes()) }
+
 func (h *BookHandler) create(w http.ResponseWriter, r *http.Request) {
-	var in struct{ Title, Author string }
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "bad json", 400)
+	var in bookInput
+	if !decodeJSON(w, r, h.bodyLimit(), &in) {
 		return
 	}
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	id := h.next
-	h.next++
-	b := types.Book{ID: id, Title: in.Title, Author: in.Author, Added: time.Now().UTC()}
-	h.data[id] = b
+	if errs := in.validate(); len(errs) > 0 {
+		validationProblem(w, r, errs...)
+		return
+	}
+	b, err := h.store.Create(r.Context(), in.book(time.Now().UTC()))
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(b))
 	writeJSON(w, 201, b)
 }
 
-func (h *BookHandler) list(w http.ResponseWriter, r *http.Request) {
-	author := r.URL.Query().Get("author")
-	q := strings.ToLower(r.URL.Query().Get("q"))
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	out := make([]types.Book, 0, len(h.data))
-	for _, b := range h.data {
-		if author != "" && b.Author != author {
+// nextPageHeader carries the page token of the following page, so GET
+// /books can keep returning a bare JSON array.
+const nextPageHeader = "Next-Page-Token"
+
+// pageToken is the decoded form of the opaque page_token handed to clients.
+// It pins the ordering so a resumed listing cannot silently change sort.
+type pageToken struct {
+	Sort store.SortField `json:"s"`
+	Desc bool            `json:"d,omitempty"`
+	Key  string          `json:"k"`
+	ID   int64           `json:"i"`
+}
+
+func (t pageToken) encode() string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageToken(s string) (pageToken, error) {
+	var t pageToken
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, err
+	}
+	if !t.Sort.Valid() {
+		return t, fmt.Errorf("unknown sort %q", t.Sort)
+	}
+	return t, nil
+}
+
+// listQuery parses the filter, sort and order parameters shared by
+// GET /books and GET /books:export.
+func listQuery(q url.Values) (store.ListOptions, []FieldError) {
+	opts := store.ListOptions{
+		Filter: store.Filter{Author: q.Get("author"), Query: q.Get("q"), Publisher: q.Get("publisher")},
+		Sort:   store.SortID,
+	}
+	var errs []FieldError
+	if v := q.Get("isbn"); v != "" {
+		n, err := isbn.Normalize(v)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "isbn", Message: "must be a valid ISBN-10 or ISBN-13"})
+		}
+		opts.ISBN = n
+	}
+	if v := q.Get("language"); v != "" {
+		tag, ok := languageTag(v)
+		if !ok {
+			errs = append(errs, FieldError{Field: "language", Message: "must be a BCP 47 language tag such as en or pt-BR"})
+		}
+		opts.Language = tag
+	}
+	// year is shorthand for year_from and year_to both set to it.
+	for _, p := range []struct {
+		name     string
+		from, to bool
+	}{{"year", true, true}, {"year_from", true, false}, {"year_to", false, true}} {
+		v := q.Get(p.name)
+		if v == "" {
 			continue
 		}
-		if q != "" && !strings.Contains(strings.ToLower(b.Title), q) {
+		y, err := strconv.Atoi(v)
+		if err != nil || y < 1 {
+			errs = append(errs, FieldError{Field: p.name, Message: "must be a positive integer"})
 			continue
 		}
-		out = append(out, b)
+		if p.from {
+			opts.YearFrom = max(opts.YearFrom, y)
+		}
+		if p.to && (opts.YearTo == 0 || y < opts.YearTo) {
+			opts.YearTo = y
+		}
+	}
+	for _, t := range q["tag"] {
+		if t = strings.TrimSpace(t); t != "" {
+			opts.Tags = append(opts.Tags, t)
+		}
+	}
+	if v := q.Get("sort"); v != "" {
+		opts.Sort = store.SortField(v)
+		if !opts.Sort.Valid() {
+			errs = append(errs, FieldError{Field: "sort", Message: "must be one of id, title, author, added"})
+		}
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		errs = append(errs, FieldError{Field: "order", Message: "must be asc or desc"})
+	}
+	return opts, errs
+}
+
+func (h *BookHandler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts, errs := listQuery(q)
+	if len(errs) > 0 {
+		validationProblem(w, r, errs...)
+		return
+	}
+	opts.Limit = cmp.Or(h.DefaultPageSize, defaultPageSize)
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			validationProblem(w, r, FieldError{Field: "limit", Message: "must be a positive integer"})
+			return
+		}
+		opts.Limit = min(n, cmp.Or(h.MaxPageSize, maxPageSize))
+	}
+	if v := cmp.Or(q.Get("page_token"), q.Get("cursor")); v != "" {
+		t, err := decodePageToken(v)
+		if err != nil {
+			validationProblem(w, r, FieldError{Field: "page_token", Message: "is not a valid page token"})
+			return
+		}
+		if (q.Has("sort") && t.Sort != opts.Sort) || (q.Has("order") && t.Desc != opts.Desc) {
+			validationProblem(w, r, FieldError{Field: "page_token", Message: "does not match sort and order"})
+			return
+		}
+		opts.Sort, opts.Desc = t.Sort, t.Desc
+		opts.After = &store.Cursor{Key: t.Key, ID: t.ID}
+	}
+
+	// Ask for one extra book to learn whether another page follows.
+	limit := opts.Limit
+	opts.Limit++
+	out, err := h.store.List(r.Context(), opts)
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	if len(out) > limit {
+		out = out[:limit]
+		c := store.CursorFor(out[limit-1], opts.Sort)
+		token := pageToken{Sort: opts.Sort, Desc: opts.Desc, Key: c.Key, ID: c.ID}.encode()
+
+		next := *r.URL
+		nq := next.Query()
+		nq.Del("cursor")
+		nq.Set("page_token", token)
+		next.RawQuery = nq.Encode()
+		w.Header().Set(nextPageHeader, token)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	if out == nil {
+		out = []types.Book{}
 	}
 	writeJSON(w, 200, out)
 }
 
 func (h *BookHandler) get(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	b, ok := h.data[id]
+	id, ok := pathID(w, r)
 	if !ok {
-		http.NotFound(w, r)
+		return
+	}
+	b, err := h.store.Get(r.Context(), id)
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(b))
+	w.Header().Set("Accept-Patch", mergePatchType)
+	if notModified(r, b) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	writeJSON(w, 200, b)
 }
 
-func (h *BookHandler) update(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	var in struct{ Title, Author string }
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "bad json", 400)
+// getByISBN answers like get for the book with the ISBN in the path, in
+// either form, and names its canonical URL in Content-Location.
+func (h *BookHandler) getByISBN(w http.ResponseWriter, r *http.Request) {
+	n, err := isbn.Normalize(r.PathValue("isbn"))
+	if err != nil {
+		validationProblem(w, r, FieldError{Field: "isbn", Message: "must be a valid ISBN-10 or ISBN-13"})
+		return
+	}
+	b, err := h.store.GetByISBN(r.Context(), n)
+	if err != nil {
+		h.storeError(w, r, err)
 		return
 	}
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	b, ok := h.data[id]
+	w.Header().Set("ETag", etag(b))
+	w.Header().Set("Content-Location", "/books/"+strconv.FormatInt(b.ID, 10))
+	if notModified(r, b) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, 200, b)
+}
+
+// update changes the members of the body that are not empty, as if they
+// were sent as a merge patch, so author and authors interact the same way.
+func (h *BookHandler) update(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
 	if !ok {
-		http.NotFound(w, r)
 		return
 	}
-	if in.Title != "" {
-		b.Title = in.Title
+	var in bookInput
+	if !decodeJSON(w, r, h.bodyLimit(), &in) {
+		return
+	}
+	var errs []FieldError
+	errs = checkText(errs, "title", in.Title, false, maxTitleLen)
+	errs = checkText(errs, "author", in.Author, false, maxAuthorLen)
+	if len(errs) > 0 {
+		validationProblem(w, r, errs...)
+		return
 	}
-	if in.Author != "" {
-		b.Author = in.Author
+	var patch map[string]any
+	raw, _ := json.Marshal(in)
+	_ = json.Unmarshal(raw, &patch)
+	for k, v := range patch {
+		if v == "" {
+			delete(patch, k)
+		}
 	}
-	h.data[id] = b
+	b, err := h.store.Update(r.Context(), id, func(b *types.Book) error {
+		if err := checkIfMatch(r, *b); err != nil {
+			return err
+		}
+		return applyBookPatch(b, patch)
+	})
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(b))
 	writeJSON(w, 200, b)
 }
 
 func (h *BookHandler) delete(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	delete(h.data, id)
-	writeJSON(w, 204, nil)
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var check func(types.Book) error
+	if r.Header.Get("If-Match") != "" {
+		check = func(b types.Book) error { return checkIfMatch(r, b) }
+	}
+	if err := h.store.Delete(r.Context(), id, check); err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *BookHandler) logger() *slog.Logger { return cmp.Or(h.Logger, slog.Default()) }
+
+// storeError maps a BookStore error onto a problem response.
+func (h *BookHandler) storeError(w http.ResponseWriter, r *http.Request, err error) {
+	var fe fieldErrors
+	switch {
+	case errors.Is(err, errPreconditionFailed):
+		preconditionFailed(w, r)
+		return
+	case errors.Is(err, store.ErrNotFound) && r.Header.Get("If-Match") != "":
+		// No current representation can match If-Match (RFC 9110 13.1.1).
+		preconditionFailed(w, r)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		notFound(w, r)
+		return
+	case errors.Is(err, store.ErrDuplicateISBN):
+		writeProblem(w, r, problem.Problem{Type: problemDuplicateISBN, Title: "ISBN already in use", Status: http.StatusConflict,
+			Detail: "another book already has this ISBN"})
+		return
+	case errors.As(err, &fe):
+		validationProblem(w, r, fe...)
+		return
+	}
+	h.logger().ErrorContext(r.Context(), "book store", "err", err, "method", r.Method, "path", r.URL.Path)
+	writeProblem(w, r, problem.Problem{Status: http.StatusInternalServerError})
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
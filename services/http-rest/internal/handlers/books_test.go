@@ -0,0 +1,190 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"http-rest/internal/events"
+	"http-rest/internal/handlers"
+	"http-rest/internal/store"
+	"http-rest/internal/types"
+)
+
+// books serves the Books routes from a memory store, with a change feed.
+type books struct {
+	t     *testing.T
+	mux   *http.ServeMux
+	store store.BookStore
+}
+
+func newBooks(t *testing.T) *books {
+	t.Helper()
+	broker := events.NewBroker(events.Options{FirstID: 1})
+	t.Cleanup(broker.Close)
+	s := events.NewStore(store.NewMemoryStore(), broker)
+	h := handlers.NewBookHandler(s)
+	h.Events = broker
+	mux := http.NewServeMux()
+	h.Register(mux)
+	return &books{t: t, mux: mux, store: s}
+}
+
+func (b *books) add(title, author string) types.Book {
+	b.t.Helper()
+	book, err := b.store.Create(context.Background(), types.Book{Title: title, Author: author})
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return book
+}
+
+func (b *books) get(target string, header ...string) *httptest.ResponseRecorder {
+	b.t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	b.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestListPages walks GET /books page by page, following Next-Page-Token
+// and the Link header, and checks that the pages add up to the full
+// listing: no book skipped or repeated, even when books are added
+// mid-walk ahead of the cursor.
+func TestListPages(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"sort=title", []string{"A", "B", "C", "D", "E", "F", "G"}},
+		{"sort=title&order=desc", []string{"G", "F", "E", "D", "C", "B", "A"}},
+		{"sort=id", []string{"D", "A", "G", "C", "F", "B", "E"}},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			b := newBooks(t)
+			for _, title := range []string{"D", "A", "G", "C", "F", "B", "E"} {
+				b.add(title, "Anon")
+			}
+			for _, follow := range []string{"Next-Page-Token", "Link"} {
+				var got []string
+				target := "/books?limit=3&" + tt.query
+				for page := 0; target != ""; page++ {
+					if page > len(tt.want) {
+						t.Fatalf("%s: no last page", follow)
+					}
+					rec := b.get(target)
+					if rec.Code != http.StatusOK {
+						t.Fatalf("GET %s: status %d: %s", target, rec.Code, rec.Body)
+					}
+					var out []types.Book
+					if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+						t.Fatal(err)
+					}
+					for _, book := range out {
+						got = append(got, book.Title)
+					}
+					token, link := rec.Header().Get("Next-Page-Token"), rec.Header().Get("Link")
+					if (token == "") != (link == "") {
+						t.Fatalf("Next-Page-Token %q with Link %q", token, link)
+					}
+					switch {
+					case token == "":
+						target = ""
+					case follow == "Link":
+						uri, _, _ := strings.Cut(strings.TrimPrefix(link, "<"), ">")
+						target = uri
+					default:
+						// The token alone carries the sort and order.
+						target = "/books?limit=3&page_token=" + url.QueryEscape(token)
+					}
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("%s: pages list %v, want %v", follow, got, tt.want)
+				}
+			}
+		})
+	}
+
+	t.Run("insert behind the cursor", func(t *testing.T) {
+		b := newBooks(t)
+		for _, title := range []string{"B", "D", "F", "H"} {
+			b.add(title, "Anon")
+		}
+		first := b.get("/books?limit=2&sort=title")
+		token := first.Header().Get("Next-Page-Token")
+		b.add("A", "Anon") // sorts before the page already seen
+		b.add("E", "Anon") // sorts after it
+		var rest []types.Book
+		if err := json.Unmarshal(b.get("/books?limit=10&page_token="+url.QueryEscape(token)).Body.Bytes(), &rest); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, book := range rest {
+			got = append(got, book.Title)
+		}
+		if want := []string{"E", "F", "H"}; !slices.Equal(got, want) {
+			t.Errorf("second page %v, want %v", got, want)
+		}
+	})
+}
+
+func TestListPageTokenErrors(t *testing.T) {
+	b := newBooks(t)
+	for _, title := range []string{"A", "B", "C"} {
+		b.add(title, "Anon")
+	}
+	token := url.QueryEscape(b.get("/books?limit=1&sort=title").Header().Get("Next-Page-Token"))
+	for _, target := range []string{
+		"/books?page_token=" + token + "&sort=author",
+		"/books?page_token=" + token + "&order=desc",
+		"/books?page_token=not-base64!",
+		"/books?page_token=" + url.QueryEscape(`eyJzIjoibm9wZSJ9`), // {"s":"nope"}
+	} {
+		rec := b.get(target)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"page_token"`) {
+			t.Errorf("GET %s: status %d, body %s; want a page_token validation error", target, rec.Code, rec.Body)
+		}
+	}
+	if rec := b.get("/books?page_token=" + token + "&sort=title&order=asc"); rec.Code != http.StatusOK {
+		t.Errorf("token with its own sort and order: status %d", rec.Code)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	b := newBooks(t)
+	b.add("Beloved", "Toni Morrison")
+	b.add("Jazz", "Toni Morrison")
+	b.add("Dune", "Frank Herbert")
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"author=Toni+Morrison&sort=title", []string{"Beloved", "Jazz"}},
+		{"q=dune", []string{"Dune"}},
+		{"author=Nobody", nil},
+	} {
+		var out []types.Book
+		rec := b.get("/books?" + tt.query)
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: %v (%s)", tt.query, err, rec.Body)
+		}
+		var got []string
+		for _, book := range out {
+			got = append(got, book.Title)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.query, got, tt.want)
+		}
+	}
+	if body := strings.TrimSpace(b.get("/books?author=Nobody").Body.String()); body != "[]" {
+		t.Errorf("an empty page is %s, want []", body)
+	}
+}
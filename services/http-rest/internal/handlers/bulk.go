@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-rest/internal/problem"
+	"http-rest/internal/store"
+	"http-rest/internal/types"
+)
+
+const (
+	maxImportBytes = 32 << 20
+	// importBatch and exportBatch bound how many books one store call
+	// writes (best-effort imports) or reads (exports).
+	importBatch = 500
+	exportBatch = 500
+	// exportPageWrite is the write deadline of each export page. It replaces
+	// the server's WriteTimeout, which would cut large exports short.
+	exportPageWrite = 30 * time.Second
+
+	ndjsonType = "application/x-ndjson"
+	csvType    = "text/csv"
+
+	importAllOrNothing = "all-or-nothing"
+	importBestEffort   = "best-effort"
+
+	problemImportRejected   = "/problems/import-rejected"
+	problemImportIncomplete = "/problems/import-incomplete"
+)
+
+// csvColumns is the header row of a CSV export; columns added since the
+// first release go last. Imports need title and author or authors; id,
+// added, updated and version are ignored.
+var csvColumns = []string{"id", "title", "author", "added", "updated", "version",
+	"authors", "isbn", "year", "publisher", "language", "tags"}
+
+// csvListSep separates the entries of the authors and tags columns.
+const csvListSep = ";"
+
+// LineError reports why one line of an import was rejected. Line is the
+// 1-based line of the body (for CSV, where the record starts).
+type LineError struct {
+	Line    int          `json:"line"`
+	Message string       `json:"message,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// importReport is the body of a successful POST /books:import, and the
+// import member of the problem reported when a best-effort import stops at
+// a failed batch.
+type importReport struct {
+	Mode     string      `json:"mode"`
+	Imported int         `json:"imported"`
+	Rejected int         `json:"rejected"`
+	Errors   []LineError `json:"errors,omitempty"`
+	// Failed is the line range of the batch the store refused; neither it
+	// nor the lines after it were stored, so a client can resume from
+	// Failed.From.
+	Failed *lineRange `json:"failed,omitempty"`
+}
+
+// lineRange is an inclusive range of body lines.
+type lineRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (h *BookHandler) importLimit() int64 {
+	return cmp.Or(h.MaxImportBytes, maxImportBytes)
+}
+
+// bulkFormat maps a media type or ?format= value onto ndjsonType or
+// csvType, or "" if it names neither.
+func bulkFormat(v string) string {
+	mt, _, _ := mime.ParseMediaType(v)
+	switch mt {
+	case ndjsonType, "application/ndjson", "application/jsonl", "ndjson", "jsonl":
+		return ndjsonType
+	case csvType, "application/csv", "csv":
+		return csvType
+	}
+	return ""
+}
+
+func unsupportedMediaType(w http.ResponseWriter, r *http.Request, detail string) {
+	writeProblem(w, r, problem.Problem{
+		Type:   problemUnsupportedMediaType,
+		Title:  "Unsupported media type",
+		Status: http.StatusUnsupportedMediaType,
+		Detail: detail,
+	})
+}
+
+// importBooks creates books from an NDJSON or CSV body. In the default
+// all-or-nothing mode any rejected line rejects the whole import; in
+// best-effort mode valid lines are stored and the rest reported.
+func (h *BookHandler) importBooks(w http.ResponseWriter, r *http.Request) {
+	mode := cmp.Or(r.URL.Query().Get("mode"), importAllOrNothing)
+	if mode != importAllOrNothing && mode != importBestEffort {
+		validationProblem(w, r, FieldError{Field: "mode", Message: "must be all-or-nothing or best-effort"})
+		return
+	}
+	format := bulkFormat(r.Header.Get("Content-Type"))
+	if format == "" {
+		unsupportedMediaType(w, r, "import bodies must be "+ndjsonType+" or "+csvType)
+		return
+	}
+
+	// Nothing is written until the whole body has been read, so a body that
+	// turns out to be too large or malformed imports nothing in either mode.
+	body := http.MaxBytesReader(w, r.Body, h.importLimit())
+	report := importReport{Mode: mode}
+	var (
+		pending []types.Book
+		lines   []int // of pending
+	)
+	accept := func(line int, in bookInput) {
+		if errs := in.validate(); len(errs) > 0 {
+			report.Errors = append(report.Errors, LineError{Line: line, Errors: errs})
+			return
+		}
+		pending = append(pending, in.book(time.Now().UTC()))
+		lines = append(lines, line)
+	}
+	reject := func(e LineError) { report.Errors = append(report.Errors, e) }
+
+	var err error
+	if format == csvType {
+		err = readCSV(body, accept, reject)
+	} else {
+		err = readNDJSON(body, h.bodyLimit(), accept, reject)
+	}
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr):
+		writeProblem(w, r, problem.Problem{
+			Type:   problemBodyTooLarge,
+			Title:  "Request body too large",
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("import must not exceed %d bytes", maxErr.Limit),
+		})
+		return
+	case errors.As(err, new(*headerError)):
+		writeProblem(w, r, problem.Problem{Type: problemMalformedBody, Title: "Malformed request body", Status: http.StatusBadRequest, Detail: err.Error()})
+		return
+	case err != nil:
+		writeProblem(w, r, problem.Problem{Type: problemMalformedBody, Title: "Malformed request body", Status: http.StatusBadRequest, Detail: err.Error()})
+		return
+	}
+
+	if pending, lines, err = h.checkISBNs(r, pending, lines, func(e LineError) { report.Errors = append(report.Errors, e) }); err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	slices.SortStableFunc(report.Errors, func(a, b LineError) int { return cmp.Compare(a.Line, b.Line) })
+
+	report.Rejected = len(report.Errors)
+	if mode == importAllOrNothing && report.Rejected > 0 {
+		problem.Write(w, r, &Problem{
+			Problem: problem.Problem{
+				Type:   problemImportRejected,
+				Title:  "Import rejected",
+				Status: http.StatusUnprocessableEntity,
+				Detail: fmt.Sprintf("%d lines failed validation; nothing was imported", report.Rejected),
+			},
+			Lines: report.Errors,
+		})
+		return
+	}
+	// All-or-nothing imports are one atomic write; best-effort ones are
+	// written in batches so a large catalog is not one huge transaction.
+	batch := len(pending)
+	if mode == importBestEffort {
+		batch = importBatch
+	}
+	batch = max(batch, 1)
+	for start := 0; start < len(pending); start += batch {
+		end := min(start+batch, len(pending))
+		created, err := h.store.CreateMany(r.Context(), pending[start:end])
+		if err != nil && report.Imported == 0 {
+			h.storeError(w, r, err)
+			return
+		}
+		if err != nil {
+			// Earlier batches are stored; say so, or a retry duplicates them.
+			report.Failed = &lineRange{From: lines[start], To: lines[end-1]}
+			h.importIncomplete(w, r, report, err)
+			return
+		}
+		report.Imported += len(created)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// importIncomplete reports a best-effort import that stopped at a failed
+// batch, with the status storeError would use and the partial report.
+func (h *BookHandler) importIncomplete(w http.ResponseWriter, r *http.Request, report importReport, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, store.ErrDuplicateISBN) {
+		status = http.StatusConflict
+	} else {
+		h.logger().ErrorContext(r.Context(), "book store", "err", err, "method", r.Method, "path", r.URL.Path)
+	}
+	problem.Write(w, r, &Problem{
+		Problem: problem.Problem{
+			Type:   problemImportIncomplete,
+			Title:  "Import incomplete",
+			Status: status,
+			Detail: fmt.Sprintf("%d books were imported before lines %d-%d failed; nothing from line %d on was stored",
+				report.Imported, report.Failed.From, report.Failed.To, report.Failed.From),
+		},
+		Import: &report,
+	})
+}
+
+// checkISBNs rejects the pending books whose ISBN an earlier line or a
+// stored book already has, and returns the rest with their lines. The
+// store still has the last word: a book stored meanwhile fails the import
+// with a conflict.
+func (h *BookHandler) checkISBNs(r *http.Request, pending []types.Book, lines []int, reject func(LineError)) ([]types.Book, []int, error) {
+	first := make(map[string]int)
+	out, outLines := pending[:0], lines[:0]
+	for i, b := range pending {
+		if b.ISBN == "" {
+			out, outLines = append(out, b), append(outLines, lines[i])
+			continue
+		}
+		if line, dup := first[b.ISBN]; dup {
+			reject(LineError{Line: lines[i], Errors: []FieldError{{Field: "isbn", Message: fmt.Sprintf("repeats line %d", line)}}})
+			continue
+		}
+		first[b.ISBN] = lines[i]
+		switch existing, err := h.store.GetByISBN(r.Context(), b.ISBN); {
+		case err == nil:
+			reject(LineError{Line: lines[i], Errors: []FieldError{{Field: "isbn", Message: fmt.Sprintf("is already used by book %d", existing.ID)}}})
+			continue
+		case !errors.Is(err, store.ErrNotFound):
+			return nil, nil, err
+		}
+		out, outLines = append(out, b), append(outLines, lines[i])
+	}
+	return out, outLines, nil
+}
+
+// headerError reports a CSV header that cannot be imported.
+type headerError struct{ msg string }
+
+func (e *headerError) Error() string { return e.msg }
+
+// readNDJSON feeds each non-blank line of r, as a bookInput, to accept.
+// Lines longer than maxLine are rejected.
+func readNDJSON(r io.Reader, maxLine int64, accept func(int, bookInput), reject func(LineError)) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		raw, tooLong, err := readLine(br, maxLine)
+		switch {
+		case tooLong:
+			reject(LineError{Line: line, Message: fmt.Sprintf("line exceeds %d bytes", maxLine)})
+		case len(bytes.TrimSpace(raw)) == 0:
+		default:
+			in, lerr := decodeLine(raw)
+			if lerr != nil {
+				lerr.Line = line
+				reject(*lerr)
+			} else {
+				accept(line, in)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine returns the next line without its terminator, discarding the
+// rest of lines longer than max.
+func readLine(br *bufio.Reader, max int64) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if int64(len(line)+len(chunk)) > max {
+			tooLong = true
+		} else {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return bytes.TrimRight(line, "\r\n"), tooLong, err
+	}
+}
+
+// decodeLine parses one NDJSON line with the same rules as POST /books.
+func decodeLine(raw []byte) (bookInput, *LineError) {
+	var in bookInput
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&in)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after JSON object")
+	}
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return in, nil
+	case errors.As(err, &typeErr):
+		return in, &LineError{Errors: []FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return in, &LineError{Errors: []FieldError{{Field: field, Message: "unknown field"}}}
+	}
+	return in, &LineError{Message: "invalid JSON: " + strings.TrimPrefix(err.Error(), "json: ")}
+}
+
+// readCSV feeds each record of a headed CSV body to accept.
+func readCSV(r io.Reader, accept func(int, bookInput), reject func(LineError)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			return err
+		}
+		return &headerError{"invalid CSV header: " + err.Error()}
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(csvColumns, name) {
+			return &headerError{fmt.Sprintf("unknown CSV column %q", name)}
+		}
+		col[name] = i
+	}
+	_, hasAuthor := col["author"]
+	_, hasAuthors := col["authors"]
+	if _, ok := col["title"]; !ok {
+		return &headerError{`CSV header must include "title"`}
+	}
+	if !hasAuthor && !hasAuthors {
+		return &headerError{`CSV header must include "author" or "authors"`}
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			reject(LineError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(rec) != len(header) {
+			reject(LineError{Line: line, Message: fmt.Sprintf("record has %d fields, header has %d", len(rec), len(header))})
+			continue
+		}
+		in := bookInput{
+			Title: get(rec, "title"), Author: get(rec, "author"), Authors: splitList(get(rec, "authors")),
+			ISBN: get(rec, "isbn"), Publisher: get(rec, "publisher"), Language: get(rec, "language"), Tags: splitList(get(rec, "tags")),
+		}
+		if v := strings.TrimSpace(get(rec, "year")); v != "" {
+			y, err := strconv.Atoi(v)
+			if err != nil {
+				reject(LineError{Line: line, Errors: []FieldError{{Field: "year", Message: "must be an integer"}}})
+				continue
+			}
+			in.Year = y
+		}
+		accept(line, in)
+	}
+}
+
+// splitList reads a csvListSep-separated CSV cell; an empty cell is nil.
+func splitList(v string) []string {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	return strings.Split(v, csvListSep)
+}
+
+// exportBooks streams every book matching the list filters, a page at a
+// time, as NDJSON (the default) or CSV chosen by ?format= or Accept.
+func (h *BookHandler) exportBooks(w http.ResponseWriter, r *http.Request) {
+	opts, errs := listQuery(r.URL.Query())
+	format := ndjsonType
+	if v := r.URL.Query().Get("format"); v != "" {
+		if format = bulkFormat(v); format == "" {
+			errs = append(errs, FieldError{Field: "format", Message: "must be ndjson or csv"})
+		}
+	} else if v := bulkFormat(r.Header.Get("Accept")); v != "" {
+		format = v
+	}
+	if len(errs) > 0 {
+		validationProblem(w, r, errs...)
+		return
+	}
+	opts.Limit = exportBatch
+
+	// Fetch the first page before committing to a 200 so store failures
+	// can still be reported as problems.
+	page, err := h.store.List(r.Context(), opts)
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	ext := "ndjson"
+	if format == csvType {
+		ext = "csv"
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Disposition", `attachment; filename="books.`+ext+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(w)
+	var write func(types.Book) error
+	if format == csvType {
+		cw := csv.NewWriter(bw)
+		_ = cw.Write(csvColumns)
+		write = func(b types.Book) error {
+			year := ""
+			if b.Year != 0 {
+				year = strconv.Itoa(b.Year)
+			}
+			_ = cw.Write([]string{
+				strconv.FormatInt(b.ID, 10), b.Title, b.Author,
+				b.Added.Format(time.RFC3339Nano), b.Updated.Format(time.RFC3339Nano),
+				strconv.FormatInt(b.Version, 10),
+				strings.Join(b.Authors, csvListSep), b.ISBN, year, b.Publisher, b.Language, strings.Join(b.Tags, csvListSep),
+			})
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		enc := json.NewEncoder(bw)
+		write = func(b types.Book) error { return enc.Encode(b) }
+	}
+
+	rc := http.NewResponseController(w)
+	for {
+		_ = rc.SetWriteDeadline(time.Now().Add(exportPageWrite))
+		for _, b := range page {
+			if err := write(b); err != nil {
+				return // client went away
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return
+		}
+		_ = rc.Flush()
+		if len(page) < opts.Limit {
+			return
+		}
+		c := store.CursorFor(page[len(page)-1], opts.Sort)
+		opts.After = &c
+		if page, err = h.store.List(r.Context(), opts); err != nil {
+			// The status is already sent; cut the response short so the
+			// client cannot mistake it for a complete export.
+			h.logger().ErrorContext(r.Context(), "book export", "err", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"http-rest/internal/problem"
+	"http-rest/internal/types"
+)
+
+const problemPreconditionFailed = "/problems/precondition-failed"
+
+// errPreconditionFailed aborts a store write whose If-Match did not match.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// etag is the strong entity tag of b's current version.
+func etag(b types.Book) string {
+	return `"` + strconv.FormatInt(b.Version, 10) + `"`
+}
+
+// etagListMatches reports whether the If-Match or If-None-Match value
+// header lists tag or is "*". With weak set, W/ prefixes are ignored
+// (RFC 9110 weak comparison); otherwise weak tags never match.
+func etagListMatches(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if strings.HasPrefix(t, "W/") {
+			if !weak {
+				continue
+			}
+			t = t[2:]
+		}
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns errPreconditionFailed when r carries an If-Match
+// header that does not match b. Callers run it inside the store
+// transaction so the comparison and the write are atomic.
+func checkIfMatch(r *http.Request, b types.Book) error {
+	if h := r.Header.Get("If-Match"); h != "" && !etagListMatches(h, etag(b), false) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// notModified reports whether r's If-None-Match matches b.
+func notModified(r *http.Request, b types.Book) bool {
+	h := r.Header.Get("If-None-Match")
+	return h != "" && etagListMatches(h, etag(b), true)
+}
+
+func preconditionFailed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problem.Problem{
+		Type:   problemPreconditionFailed,
+		Title:  "Precondition failed",
+		Status: http.StatusPreconditionFailed,
+		Detail: "the book does not match If-Match; fetch it again and retry with the new ETag",
+	})
+}
@@ -0,0 +1,418 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"http-rest/internal/auth"
+	"http-rest/internal/events"
+	"http-rest/internal/handlers"
+	"http-rest/internal/health"
+	"http-rest/internal/idempotency"
+	"http-rest/internal/metrics"
+	"http-rest/internal/openapi"
+	"http-rest/internal/registry"
+	"http-rest/internal/router"
+	"http-rest/internal/search"
+	"http-rest/internal/store"
+)
+
+// recorder is a Mux that remembers the patterns registered on it. Requests
+// are served through handler, the mux behind the auth middleware.
+type recorder struct {
+	*http.ServeMux
+	patterns []string
+	handler  http.Handler
+}
+
+func (r *recorder) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	r.patterns = append(r.patterns, pattern)
+	r.ServeMux.HandleFunc(pattern, h)
+}
+
+// step is one request of the scenario. Only requests expected to succeed
+// have their bodies validated against the request schema.
+type step struct {
+	method, path string
+	header       http.Header
+	body         string
+	want         int
+	// timeout cancels the request after a while, for streams that never
+	// end on their own.
+	timeout time.Duration
+}
+
+func withBody(method, path, body string, want int) step {
+	return step{method: method, path: path, body: body, want: want,
+		header: http.Header{"Content-Type": {"application/json"}}}
+}
+
+func with(s step, key, value string) step {
+	s.header = s.header.Clone()
+	if s.header == nil {
+		s.header = http.Header{}
+	}
+	s.header.Set(key, value)
+	return s
+}
+
+// API keys the scenario authenticates with; steps use adminKey unless they
+// set X-API-Key themselves.
+const (
+	adminKey  = "contract-admin"
+	readerKey = "contract-reader"
+)
+
+// TestContract checks the handlers against the OpenAPI document they
+// publish. It serves the Books and trigger routes from an in-memory store
+// and local fake targets, runs a scenario covering every route, and checks
+// that every registered mux pattern and every documented operation is
+// exercised and every request and response body matches its schema.
+func TestContract(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close() // connections are refused
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer fake.Close()
+	noFanout := false
+	reg, err := registry.New([]registry.Target{
+		{Name: "ok", BaseURL: fake.URL, Path: "/ok"},
+		{Name: "failing", BaseURL: fake.URL, Path: "/fail"},
+		{Name: "unreachable", BaseURL: down.URL, Fanout: &noFanout},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := &recorder{ServeMux: http.NewServeMux()}
+	indexed, err := search.NewIndexedStore(context.Background(), store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := events.NewBroker(events.Options{FirstID: 1})
+	defer broker.Close()
+	bh := handlers.NewBookHandler(events.NewStore(indexed, broker))
+	bh.Index = indexed.Index()
+	bh.Events = broker
+	bh.Logger = slog.New(slog.DiscardHandler)
+	bh.Register(mux)
+	triggers := handlers.NewTriggers(reg, 0)
+	triggers.Logger = bh.Logger
+	triggers.Register(mux)
+	doc := handlers.OpenAPI(reg)
+	authn, err := auth.New(&auth.Config{APIKeyHeader: "X-API-Key", APIKeys: []auth.APIKey{
+		{Subject: "admin", KeySHA256: digest(adminKey), Scopes: []string{handlers.ScopeBooksRead, handlers.ScopeBooksWrite, handlers.ScopeTriggersRun}},
+		{Subject: "reader", KeySHA256: digest(readerKey), Scopes: []string{handlers.ScopeBooksRead}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := handlers.Scopes()
+	keeper := idempotency.New(idempotency.NewMemory(), idempotency.Options{}, slog.New(slog.DiscardHandler))
+	mux.handler = authn.Wrap(mux.ServeMux, scopes, keeper.Wrap(mux.ServeMux, handlers.IdempotentRoutes(), mux.ServeMux))
+
+	const (
+		patch  = "application/merge-patch+json"
+		ndjson = "application/x-ndjson"
+	)
+	steps := []step{
+		withBody("POST", "/books", `{"title":"Dune","author":"Frank Herbert"}`, 201),
+		withBody("POST", "/books", `{"title":"Emma","author":"Jane Austen"}`, 201),
+		withBody("POST", "/books", `{"title":"Good Omens","authors":["Terry Pratchett","Neil Gaiman"],"isbn":"0-575-04800-X","year":1990,"publisher":"Gollancz","language":"EN","tags":["fantasy","comedy"]}`, 201),
+		withBody("POST", "/books", `{"title":"Good Omens","author":"Neil Gaiman","isbn":"9780575048003"}`, 409),
+		withBody("POST", "/books", `{"title":"Bad","author":"X","authors":["Y"],"isbn":"0-575-04800-1","language":"??"}`, 400),
+		withBody("POST", "/books", `{"title":""}`, 400),
+		with(withBody("POST", "/books", `{"title":"Kindred","author":"Octavia E. Butler"}`, 201), "Idempotency-Key", "kindred-1"),
+		with(withBody("POST", "/books", `{"title":"Kindred","author":"Octavia E. Butler"}`, 201), "Idempotency-Key", "kindred-1"),
+		with(withBody("POST", "/books", `{"title":"Dawn","author":"Octavia E. Butler"}`, 422), "Idempotency-Key", "kindred-1"),
+		with(withBody("POST", "/books", `{"title":"Dawn","author":"Octavia E. Butler"}`, 400), "Idempotency-Key", "no spaces"),
+		withBody("POST", "/books", `{"title":`, 400),
+		{method: "GET", path: "/books", want: 200},
+		{method: "GET", path: "/books?limit=1&sort=title&order=desc", want: 200},
+		{method: "GET", path: "/books?sort=bogus", want: 400},
+		{method: "GET", path: "/books?author=Neil+Gaiman&tag=fantasy&year_from=1980&language=en", want: 200},
+		{method: "GET", path: "/books?isbn=123&year=x", want: 400},
+		{method: "GET", path: "/books/isbn/0-575-04800-X", want: 200},
+		with(step{method: "GET", path: "/books/isbn/9780575048003", want: 304}, "If-None-Match", `"1"`),
+		{method: "GET", path: "/books/isbn/9780306406157", want: 404},
+		{method: "GET", path: "/books/isbn/12345", want: 400},
+		{method: "GET", path: "/books/1", want: 200},
+		with(step{method: "GET", path: "/books/1", want: 304}, "If-None-Match", `"1"`),
+		{method: "GET", path: "/books/999", want: 404},
+		with(step{method: "GET", path: "/books/1", want: 401}, "X-API-Key", ""),
+		with(step{method: "GET", path: "/books/1", want: 401}, "X-API-Key", "wrong"),
+		with(withBody("POST", "/books", `{"title":"Emma","author":"Jane Austen"}`, 403), "X-API-Key", readerKey),
+		{method: "GET", path: "/books/abc", want: 400},
+		with(withBody("PUT", "/books/1", `{"title":"Dune Messiah"}`, 200), "If-Match", `"1"`),
+		with(withBody("PUT", "/books/1", `{"title":"Dune"}`, 412), "If-Match", `"1"`),
+		withBody("PUT", "/books/999", `{"title":"Dune"}`, 404),
+		with(withBody("PATCH", "/books/1", `{"author":"F. Herbert"}`, 200), "Content-Type", patch),
+		with(withBody("PATCH", "/books/1", `{"author":null}`, 400), "Content-Type", patch),
+		with(withBody("PATCH", "/books/3", `{"author":"Sir Terry Pratchett","tags":null}`, 200), "Content-Type", patch),
+		with(withBody("PATCH", "/books/1", `{"isbn":"9780575048003"}`, 409), "Content-Type", patch),
+		withBody("PATCH", "/books/1", `{"author":"F. Herbert"}`, 415),
+		with(step{method: "DELETE", path: "/books/2", want: 412}, "If-Match", `"7"`),
+		{method: "DELETE", path: "/books/2", want: 204},
+		{method: "DELETE", path: "/books/2", want: 404},
+		with(withBody("POST", "/books:import", "{\"title\":\"Beloved\",\"author\":\"Toni Morrison\"}\n\n{\"title\":\"Jazz\",\"author\":\"Toni Morrison\"}\n", 200), "Content-Type", ndjson),
+		with(withBody("POST", "/books:import", "{\"title\":\"Sula\",\"author\":\"Toni Morrison\"}\n{\"title\":\"\"}\n", 422), "Content-Type", ndjson),
+		with(withBody("POST", "/books:import?mode=best-effort", "title,author\nSula,Toni Morrison\n,Nobody\n", 200), "Content-Type", "text/csv"),
+		with(withBody("POST", "/books:import", "title\tauthor\n", 415), "Content-Type", "text/tab-separated-values"),
+		{method: "GET", path: "/books:export?author=Toni+Morrison", want: 200},
+		{method: "GET", path: "/books:export?format=csv&sort=title", want: 200},
+		{method: "GET", path: "/books:export?format=xml", want: 400},
+		{method: "GET", path: "/books:search?q=morri&facets=author", want: 200},
+		{method: "GET", path: "/books:search?limit=1&offset=1", want: 200},
+		{method: "GET", path: "/books:search?facets=title", want: 400},
+		{method: "GET", path: "/books/events?author=Toni+Morrison", want: 200, timeout: 20 * time.Millisecond},
+		with(step{method: "GET", path: "/books/events", want: 200, timeout: 20 * time.Millisecond}, "Last-Event-ID", "2"),
+		{method: "GET", path: "/books/events?last_event_id=99999", want: 200, timeout: 20 * time.Millisecond},
+		{method: "GET", path: "/books/events?last_event_id=abc", want: 400},
+		{method: "GET", path: "/trigger/targets", want: 200},
+		{method: "GET", path: "/trigger/allservices", want: 207},
+		{method: "GET", path: "/trigger/allservices?only=ok", want: 200},
+		{method: "GET", path: "/trigger/allservices?skip=ok", want: 502},
+		{method: "GET", path: "/trigger/allservices?only=nope", want: 400},
+		{method: "GET", path: "/trigger/ok", want: 200},
+		with(step{method: "GET", path: "/trigger/ok", want: 200}, "Idempotency-Key", "ok-1"),
+		with(step{method: "GET", path: "/trigger/ok", want: 200}, "Idempotency-Key", "ok-1"),
+		{method: "GET", path: "/trigger/failing", want: 502},
+		{method: "GET", path: "/trigger/unreachable", want: 500},
+		{method: "GET", path: "/trigger/nope", want: 404},
+	}
+
+	seenPatterns := make(map[string]bool)
+	seenOps := make(map[string]bool)
+	for _, s := range steps {
+		pattern, op, err := run(doc, mux, s)
+		seenPatterns[pattern] = true
+		seenOps[op] = true
+		if err != nil {
+			t.Errorf("%s %s: %v", s.method, s.path, err)
+		}
+	}
+	for _, p := range mux.patterns {
+		if !seenPatterns[p] {
+			t.Errorf("pattern %q is not exercised", p)
+		}
+		if scopes[p] == "" {
+			t.Errorf("pattern %q has no scope", p)
+		}
+	}
+	for path, item := range doc.Paths {
+		for _, m := range item.Methods() {
+			if !seenOps[m+" "+path] {
+				t.Errorf("documented operation %s %s is not served or not exercised", m, path)
+			}
+		}
+	}
+}
+
+// run sends one request and checks it against the documented operation.
+// It returns the mux pattern and the "METHOD /path" of the operation used.
+func run(doc *openapi.Document, mux *recorder, s step) (string, string, error) {
+	req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.body))
+	if s.timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), s.timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	req.Header.Set("X-API-Key", adminKey)
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	_, pattern := mux.Handler(req)
+	if pattern == "" {
+		return "", "", errors.New("no mux pattern matches")
+	}
+	path := pattern
+	if _, p, ok := strings.Cut(pattern, " "); ok {
+		path = p
+	}
+	item := doc.Paths[path]
+	if item == nil || item.Operation(s.method) == nil {
+		return pattern, "", fmt.Errorf("pattern %q has no documented %s operation", pattern, s.method)
+	}
+	op := item.Operation(s.method)
+	opKey := s.method + " " + path
+
+	if s.want < 300 && s.body != "" {
+		if err := checkBody(doc, requestContent(op), req.Header.Get("Content-Type"), []byte(s.body)); err != nil {
+			return pattern, opKey, fmt.Errorf("request: %w", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != s.want {
+		return pattern, opKey, fmt.Errorf("status %d, want %d (body %q)", resp.StatusCode, s.want, body)
+	}
+	doced := op.Responses[strconv.Itoa(resp.StatusCode)]
+	if doced == nil {
+		return pattern, opKey, fmt.Errorf("status %d is not documented", resp.StatusCode)
+	}
+	for name, h := range doced.Headers {
+		if h.Required && resp.Header.Get(name) == "" {
+			return pattern, opKey, fmt.Errorf("status %d: missing header %s", resp.StatusCode, name)
+		}
+	}
+	if len(body) == 0 {
+		if len(doced.Content) > 0 {
+			return pattern, opKey, fmt.Errorf("status %d: empty body", resp.StatusCode)
+		}
+		return pattern, opKey, nil
+	}
+	if err := checkBody(doc, doced.Content, resp.Header.Get("Content-Type"), body); err != nil {
+		return pattern, opKey, fmt.Errorf("status %d response: %w", resp.StatusCode, err)
+	}
+	return pattern, opKey, nil
+}
+
+func digest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestContent(op *openapi.Operation) map[string]openapi.MediaType {
+	if op.RequestBody == nil {
+		return nil
+	}
+	return op.RequestBody.Content
+}
+
+// checkBody validates a body against the schema documented for its media type.
+func checkBody(doc *openapi.Document, content map[string]openapi.MediaType, contentType string, body []byte) error {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	media, ok := content[mt]
+	if !ok {
+		documented := make([]string, 0, len(content))
+		for k := range content {
+			documented = append(documented, k)
+		}
+		slices.Sort(documented)
+		return fmt.Errorf("content type %q is not documented (have %v)", mt, documented)
+	}
+	if strings.HasSuffix(mt, "ndjson") {
+		for i, line := range bytes.Split(body, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var v any
+			if err := json.Unmarshal(line, &v); err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if err := doc.Validate(media.Schema, v); err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+		return nil
+	}
+	if mt == "text/event-stream" {
+		for i, line := range bytes.Split(body, []byte("\n")) {
+			data, ok := bytes.CutPrefix(line, []byte("data: "))
+			if !ok {
+				continue
+			}
+			var v any
+			if err := json.Unmarshal(data, &v); err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if err := doc.Validate(media.Schema, v); err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+		return nil
+	}
+	if !strings.HasSuffix(mt, "json") {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return err
+	}
+	return doc.Validate(media.Schema, v)
+}
+
+// TestOperationalEndpoints serves the documentation, health and metrics
+// routes as cmd/api wires them, behind the router's middleware, and checks
+// that each answers in its documented format. What the health reports and
+// metrics contain is tested in internal/health and internal/metrics.
+func TestOperationalEndpoints(t *testing.T) {
+	reg, err := registry.New([]registry.Target{{Name: "ok", BaseURL: "http://127.0.0.1:1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	handlers.NewBookHandler(store.NewMemoryStore()).Register(mux)
+	doc := handlers.OpenAPI(reg)
+	mux.Handle("GET /openapi.json", openapi.Handler(doc))
+	mux.Handle("GET /docs", openapi.DocsHandler(doc, "/openapi.json"))
+	checks := health.New(health.Options{})
+	checks.Liveness(health.Check{Name: "loop", Func: func(context.Context) error { return nil }, Critical: true})
+	checks.Readiness(health.Check{Name: "target:ok", Func: func(context.Context) error { return errors.New("unreachable") }, Critical: true})
+	mux.Handle("GET /livez", checks.LiveHandler())
+	mux.Handle("GET /readyz", checks.ReadyHandler())
+	prom := prometheus.NewRegistry()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(prom, promhttp.HandlerOpts{}))
+	handler := router.New(mux, mux, router.Config{
+		Logger:  slog.New(slog.DiscardHandler),
+		Metrics: metrics.NewHTTP(prom, nil),
+	})
+
+	get := func(path string, want int, contentType string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s: status %d, want %d (body %q)", path, rec.Code, want, rec.Body)
+		}
+		if ct, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type")); ct != contentType {
+			t.Errorf("GET %s: Content-Type %q, want %s", path, ct, contentType)
+		}
+		return rec.Body.String()
+	}
+
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(get("/openapi.json", 200, "application/json")), &spec); err != nil {
+		t.Errorf("GET /openapi.json: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.1") || len(spec.Paths) != len(doc.Paths) {
+		t.Errorf("GET /openapi.json: openapi %q with %d paths, want 3.1 with %d", spec.OpenAPI, len(spec.Paths), len(doc.Paths))
+	}
+	if body := get("/docs", 200, "text/html"); !strings.Contains(body, `"/openapi.json"`) || !strings.Contains(body, "/books/{id}") {
+		t.Errorf("GET /docs does not link the spec or list the routes:\n%s", body)
+	}
+	get("/livez", 200, "application/json")
+	get("/readyz", 503, "application/json")
+	get("/books", 200, "application/json")
+	// The router records requests on the registry the endpoint serves.
+	if body := get("/metrics", 200, "text/plain"); !strings.Contains(body, `http_requests_total{method="GET",route="GET /books",status_class="2xx"} 1`) {
+		t.Errorf("GET /metrics does not count GET /books:\n%s", body)
+	}
+}
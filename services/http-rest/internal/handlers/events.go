@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"http-rest/internal/events"
+	"http-rest/internal/problem"
+	"http-rest/internal/types"
+)
+
+const (
+	eventStreamType = "text/event-stream"
+	// eventReset tells a subscriber that events were lost and it should
+	// reload with GET /books before following the stream again.
+	eventReset = "reset"
+
+	defaultHeartbeat = 15 * time.Second
+	// eventWrite is the write deadline of each event or heartbeat; it
+	// replaces the server's WriteTimeout, which would end every stream.
+	eventWrite = 10 * time.Second
+	// eventRetry is the reconnection delay suggested to clients.
+	eventRetry = 3 * time.Second
+)
+
+// bookEvent is the data of each event on GET /books/events.
+type bookEvent struct {
+	Type string      `json:"type"`
+	Book *types.Book `json:"book,omitempty"`
+}
+
+// events streams book changes as Server-Sent Events. A client resumes
+// with Last-Event-ID (or ?last_event_id=): the missed events still in the
+// history are replayed first, or a reset event is sent when they are not.
+// The GET /books filters select which books to follow. A client that
+// falls behind is disconnected rather than slowing writers down, and
+// resumes the same way.
+func (h *BookHandler) events(w http.ResponseWriter, r *http.Request) {
+	if h.Events == nil {
+		writeProblem(w, r, problem.Problem{Status: http.StatusNotImplemented, Detail: "no change feed is configured"})
+		return
+	}
+	opts, errs := listQuery(r.URL.Query())
+	var (
+		lastID uint64
+		resume bool
+	)
+	if v := cmp.Or(r.Header.Get("Last-Event-ID"), r.URL.Query().Get("last_event_id")); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "last_event_id", Message: "must be an event ID from this stream"})
+		}
+		lastID, resume = n, true
+	}
+	if len(errs) > 0 {
+		validationProblem(w, r, errs...)
+		return
+	}
+
+	sub, replay, err := h.Events.Subscribe(func(e events.Event) bool { return opts.Filter.Match(e.Book) }, resume, lastID)
+	defer sub.Close()
+	if errors.Is(err, events.ErrClosed) {
+		writeProblem(w, r, problem.Problem{Status: http.StatusServiceUnavailable, Detail: "the server is shutting down"})
+		return
+	}
+
+	w.Header().Set("Content-Type", eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep proxies such as nginx from buffering
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	bw := bufio.NewWriter(w)
+	flush := func() bool {
+		_ = rc.SetWriteDeadline(time.Now().Add(eventWrite))
+		if bw.Flush() != nil {
+			return false
+		}
+		_ = rc.Flush()
+		return true
+	}
+	fmt.Fprintf(bw, "retry: %d\n\n", eventRetry.Milliseconds())
+	if errors.Is(err, events.ErrGap) {
+		// Moving the client's Last-Event-ID to the newest event lets it
+		// resume normally after reloading.
+		writeEvent(bw, h.Events.LastID(), eventReset, nil)
+	}
+	for _, e := range replay {
+		writeEvent(bw, e.ID, string(e.Type), &e.Book)
+	}
+	if !flush() {
+		return
+	}
+
+	heartbeat := time.NewTicker(cmp.Or(h.EventHeartbeat, defaultHeartbeat))
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C():
+			if !ok {
+				return // dropped as slow, or shutting down
+			}
+			writeEvent(bw, e.ID, string(e.Type), &e.Book)
+		case <-heartbeat.C:
+			bw.WriteString(": heartbeat\n\n")
+		}
+		if !flush() {
+			return
+		}
+	}
+}
+
+func writeEvent(w *bufio.Writer, id uint64, typ string, b *types.Book) {
+	data, _ := json.Marshal(bookEvent{Type: typ, Book: b})
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, typ, data)
+}
@@ -0,0 +1,129 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamWait is how long a test stream stays open: enough for the replay,
+// which is written before the handler waits for new events.
+const streamWait = 50 * time.Millisecond
+
+// eventIDs reads the id: lines of an SSE body.
+func eventIDs(body string) []string {
+	var ids []string
+	for _, line := range strings.Split(body, "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// stream reads GET target until the handler has had time to replay.
+func (b *books) stream(target string, header ...string) *httptest.ResponseRecorder {
+	b.t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), streamWait)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	b.mux.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+// TestEventsResume checks that a reconnecting client gets exactly the
+// events after its Last-Event-ID, from the header or the query.
+func TestEventsResume(t *testing.T) {
+	b := newBooks(t)
+	b.add("Beloved", "Toni Morrison") // event 1
+	b.add("Dune", "Frank Herbert")    // event 2
+	b.add("Jazz", "Toni Morrison")    // event 3
+	for _, tt := range []struct {
+		name   string
+		target string
+		header []string
+		want   []string
+	}{
+		{"no resume", "/books/events", nil, nil},
+		{"Last-Event-ID", "/books/events", []string{"Last-Event-ID", "1"}, []string{"2", "3"}},
+		{"query", "/books/events?last_event_id=2", nil, []string{"3"}},
+		{"header wins", "/books/events?last_event_id=0", []string{"Last-Event-ID", "2"}, []string{"3"}},
+		{"up to date", "/books/events", []string{"Last-Event-ID", "3"}, nil},
+		{"filtered", "/books/events?author=Toni+Morrison", []string{"Last-Event-ID", "0"}, []string{"1", "3"}},
+		{"unknown ID resets", "/books/events", []string{"Last-Event-ID", "99"}, []string{"3"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := b.stream(tt.target, tt.header...)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if got := eventIDs(rec.Body.String()); !slices.Equal(got, tt.want) {
+				t.Errorf("replayed %v, want %v\n%s", got, tt.want, rec.Body)
+			}
+		})
+	}
+	if rec := b.get("/books/events?last_event_id=abc"); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad last_event_id: status %d", rec.Code)
+	}
+}
+
+// TestEventsLive checks that a resumed stream goes on with events
+// published after it connected, in order and without a repeat.
+func TestEventsLive(t *testing.T) {
+	b := newBooks(t)
+	b.add("Beloved", "Toni Morrison")
+	srv := httptest.NewServer(b.mux)
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL+"/books/events", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	ids := make(chan string, 16)
+	go func() {
+		defer close(ids)
+		var sb strings.Builder
+		buf := make([]byte, 512)
+		for {
+			n, err := resp.Body.Read(buf)
+			sb.WriteString(string(buf[:n]))
+			for {
+				block, rest, ok := strings.Cut(sb.String(), "\n\n")
+				if !ok {
+					break
+				}
+				for _, id := range eventIDs(block) {
+					ids <- id
+				}
+				sb.Reset()
+				sb.WriteString(rest)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	next := func() string { return <-ids }
+	if got := next(); got != "1" {
+		t.Fatalf("first event %s, want the replayed 1", got)
+	}
+	for i := 2; i <= 3; i++ {
+		b.add(fmt.Sprint("Book ", i), "Anon")
+		if got := next(); got != fmt.Sprint(i) {
+			t.Fatalf("live event %s, want %d", got, i)
+		}
+	}
+}
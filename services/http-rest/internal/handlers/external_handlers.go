@@ -1,9 +1,24 @@
 package handlers
 
 import (
-	"encoding/json"
-	"log"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"http-rest/internal/problem"
+	"http-rest/internal/registry"
+	"http-rest/internal/telemetry"
 )
 
 type TriggerResponse struct {
@@ -11,99 +26,237 @@ type TriggerResponse struct {
 	Status  string `json:"status"`
 }
 
-func TriggerDBOperations(w http.ResponseWriter, r *http.Request) {
-	resp, err := http.Get("http://localhost:8081/trigger-crud") // DB project endpoint
-	if err != nil {
-		log.Printf("error triggering DB operations: %v", err)
-		http.Error(w, "DB trigger failed", http.StatusInternalServerError)
-		return
+// triggerCalls counts calls to each target by outcome: ok, unexpected_status
+// or error (the target could not be reached or timed out).
+var triggerCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "trigger_calls_total",
+	Help: "Calls to trigger targets by outcome.",
+}, []string{"target", "outcome"})
+
+// triggerClient forwards the caller's trace context to downstream services.
+var triggerClient = &http.Client{Transport: telemetry.NewTransport(http.DefaultTransport)}
+
+// Triggers serves the /trigger/* routes from a target registry.
+type Triggers struct {
+	Registry *registry.Registry
+	// Timeout bounds calls to targets that do not set their own.
+	Timeout time.Duration
+	// Logger records failed calls; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+func NewTriggers(reg *registry.Registry, timeout time.Duration) *Triggers {
+	return &Triggers{Registry: reg, Timeout: timeout}
+}
+
+func (t *Triggers) routes() []route {
+	return []route{
+		{"/trigger/targets", ScopeTriggersRun, t.targets},
+		{"/trigger/allservices", ScopeTriggersRun, t.allServices}, // supports ?only= and ?skip=
+		{"/trigger/{name}", ScopeTriggersRun, t.single},           // one route per registry target
 	}
-	defer resp.Body.Close()
+}
 
-	json.NewEncoder(w).Encode(TriggerResponse{
-		Service: "database-sql",
-		Status:  resp.Status,
-	})
+func (t *Triggers) Register(mux Mux) { register(mux, t.routes()) }
+
+// targetView is the read-only JSON form of a registry target.
+type targetView struct {
+	Name           string `json:"name"`
+	Route          string `json:"route"`
+	Method         string `json:"method"`
+	URL            string `json:"url"`
+	Timeout        string `json:"timeout"`
+	ExpectedStatus []int  `json:"expected_status,omitempty"`
+	Fanout         bool   `json:"fanout"`
 }
 
-func TriggerKafkaProducer(w http.ResponseWriter, r *http.Request) {
-	resp, err := http.Get("http://localhost:8082/trigger-produce") // Kafka project endpoint
-	if err != nil {
-		log.Printf("error triggering Kafka producer: %v", err)
-		http.Error(w, "Kafka trigger failed", http.StatusInternalServerError)
+func (t *Triggers) targets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, problem.Problem{Status: http.StatusMethodNotAllowed})
 		return
 	}
-	defer resp.Body.Close()
+	out := []targetView{}
+	for _, target := range t.Registry.Targets() {
+		out = append(out, targetView{
+			Name:           target.Name,
+			Route:          "/trigger/" + target.Route,
+			Method:         target.Method,
+			URL:            target.URL(),
+			Timeout:        t.timeout(target).String(),
+			ExpectedStatus: target.ExpectedStatus,
+			Fanout:         target.InFanout(),
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (t *Triggers) single(w http.ResponseWriter, r *http.Request) {
+	target, ok := t.Registry.Lookup(r.PathValue("name"))
+	if !ok {
+		writeProblem(w, r, problem.Problem{Status: http.StatusNotFound, Detail: "no trigger target at " + r.URL.Path})
+		return
+	}
+	res := t.fire(r, target)
+	if res.Error != "" && res.StatusCode == 0 {
+		writeProblem(w, r, problem.Problem{Status: http.StatusInternalServerError, Detail: target.Name + " could not be reached"})
+		return
+	}
+	code := http.StatusOK
+	if !res.OK {
+		code = http.StatusBadGateway
+	}
+	writeJSON(w, code, TriggerResponse{Service: target.Name, Status: res.Status})
+}
 
-	json.NewEncoder(w).Encode(TriggerResponse{
-		Service: "kafka-go",
-		Status:  resp.Status,
-	})
+// TargetResult reports one target of the all-services fan-out.
+type TargetResult struct {
+	Service    string  `json:"service"`
+	Status     string  `json:"status"`
+	StatusCode int     `json:"status_code,omitempty"`
+	OK         bool    `json:"ok"`
+	LatencyMS  float64 `json:"latency_ms"`
+	Error      string  `json:"error,omitempty"`
 }
 
-func TriggerGRPCUnaryOperation(w http.ResponseWriter, r *http.Request) {
-	resp, err := http.Get("http://localhost:8083/trigger-simple") // gRPC trigger endpoint
+// FanoutResponse is the body of /trigger/allservices. Status is "ok" when
+// every target succeeded, "failed" when none did and "degraded" otherwise.
+type FanoutResponse struct {
+	Status  string         `json:"status"`
+	Results []TargetResult `json:"results"`
+}
+
+func (t *Triggers) allServices(w http.ResponseWriter, r *http.Request) {
+	var fanout []registry.Target
+	for _, target := range t.Registry.Targets() {
+		if target.InFanout() {
+			fanout = append(fanout, target)
+		}
+	}
+	targets, err := selectTargets(fanout, r.URL.Query())
 	if err != nil {
-		log.Printf("error triggering gRPC operations: %v", err)
-		http.Error(w, "gRPC trigger failed", http.StatusInternalServerError)
+		writeProblem(w, r, problem.Problem{Status: http.StatusBadRequest, Detail: err.Error()})
 		return
 	}
-	defer resp.Body.Close()
 
-	json.NewEncoder(w).Encode(TriggerResponse{
-		Service: "grpc-service",
-		Status:  resp.Status,
-	})
+	results := make([]TargetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = t.fire(r, target)
+		}()
+	}
+	wg.Wait()
+
+	out := FanoutResponse{Status: "ok", Results: results}
+	failed := 0
+	for _, res := range results {
+		if !res.OK {
+			failed++
+		}
+	}
+	code := http.StatusOK
+	switch {
+	case failed == len(results):
+		out.Status, code = "failed", http.StatusBadGateway
+	case failed > 0:
+		out.Status, code = "degraded", http.StatusMultiStatus
+	}
+	writeJSON(w, code, out)
+}
+
+func (t *Triggers) timeout(target registry.Target) time.Duration {
+	if target.Timeout > 0 {
+		return target.Timeout
+	}
+	return t.Timeout
 }
 
-func TriggerGRPCStreamOperation(w http.ResponseWriter, r *http.Request) {
-	resp, err := http.Get("http://localhost:8083/trigger-stream") // gRPC trigger endpoint
+// fire calls one target under its own deadline.
+func (t *Triggers) fire(r *http.Request, target registry.Target) TargetResult {
+	ctx := r.Context()
+	if d := t.timeout(target); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	res := TargetResult{Service: target.Name}
+	start := time.Now()
+	resp, err := call(ctx, target)
+	res.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
 	if err != nil {
-		log.Printf("error triggering gRPC operations: %v", err)
-		http.Error(w, "gRPC trigger failed", http.StatusInternalServerError)
-		return
+		cmp.Or(t.Logger, slog.Default()).WarnContext(ctx, "trigger failed", "target", target.Name, "err", err)
+		triggerCalls.WithLabelValues(target.Name, "error").Inc()
+		res.Status = "FAILED"
+		res.Error = err.Error()
+		return res
 	}
 	defer resp.Body.Close()
+	// Drain a little so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
 
-	json.NewEncoder(w).Encode(TriggerResponse{
-		Service: "grpc-service",
-		Status:  resp.Status,
-	})
+	res.Status = resp.Status
+	res.StatusCode = resp.StatusCode
+	res.OK = target.Expects(resp.StatusCode)
+	if !res.OK {
+		res.Error = "unexpected status " + resp.Status
+		triggerCalls.WithLabelValues(target.Name, "unexpected_status").Inc()
+	} else {
+		triggerCalls.WithLabelValues(target.Name, "ok").Inc()
+	}
+	return res
 }
 
-func TriggerAllServices(w http.ResponseWriter, r *http.Request) {
-	services := []struct {
-		Name string
-		URL  string
-	}{
-		{"database-sql", "http://localhost:8081/trigger-crud"},
-		{"kafka-go", "http://localhost:8082/trigger-produce"},
-		{"grpc-unary", "http://localhost:8083/trigger-simple"},
-		{"grpc-stream", "http://localhost:8083/trigger-stream"},
-		{"http-rest", "https://jsonplaceholder.typicode.com/posts"},
-	}
-
-	var results []TriggerResponse
-
-	for _, s := range services {
-		resp, err := http.Get(s.URL)
-		if err != nil {
-			log.Printf("error triggering %s: %v", s.Name, err)
-			results = append(results, TriggerResponse{
-				Service: s.Name,
-				Status:  "FAILED",
-			})
-			continue
-		}
-		defer resp.Body.Close()
+func call(ctx context.Context, target registry.Target) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, target.Method, target.URL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return triggerClient.Do(req)
+}
 
-		results = append(results, TriggerResponse{
-			Service: s.Name,
-			Status:  resp.Status,
-		})
+// selectTargets applies the comma-separated ?only= and ?skip= lists.
+func selectTargets(all []registry.Target, q url.Values) ([]registry.Target, error) {
+	known := make(map[string]bool, len(all))
+	for _, t := range all {
+		known[t.Name] = true
+	}
+	parse := func(key string) (map[string]bool, error) {
+		set := make(map[string]bool)
+		for _, v := range q[key] {
+			for _, name := range strings.Split(v, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" {
+					continue
+				}
+				if !known[name] {
+					return nil, fmt.Errorf("unknown target %q in %s", name, key)
+				}
+				set[name] = true
+			}
+		}
+		return set, nil
+	}
+	only, err := parse("only")
+	if err != nil {
+		return nil, err
+	}
+	skip, err := parse("skip")
+	if err != nil {
+		return nil, err
 	}
 
-	// Return all results as JSON array
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	var out []registry.Target
+	for _, t := range all {
+		if (len(only) > 0 && !only[t.Name]) || skip[t.Name] {
+			continue
+		}
+		out = append(out, t)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no targets selected")
+	}
+	return out, nil
 }
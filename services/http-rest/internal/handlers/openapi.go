@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"http-rest/internal/events"
+	"http-rest/internal/idempotency"
+	"http-rest/internal/openapi"
+	"http-rest/internal/registry"
+	"http-rest/internal/search"
+	"http-rest/internal/types"
+)
+
+// OpenAPI describes the Books and /trigger/* routes. Body schemas are
+// derived from the Go types the handlers encode and decode; reg supplies
+// the trigger routes.
+func OpenAPI(reg *registry.Registry) *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "http-rest",
+		Version:     "1.0.0",
+		Description: "Books CRUD API and triggers for the downstream demo services.",
+	})
+	book := doc.Ref(types.Book{})
+	problem := doc.Ref(Problem{})
+	describeBooks(doc, book, problem)
+	describeTriggers(doc, reg, problem)
+	describeIdempotency(doc, problem)
+	describeSecurity(doc, problem)
+	return doc
+}
+
+func describeBooks(doc *openapi.Document, book, problem *openapi.Schema) {
+	input := doc.Ref(bookInput{})
+	page := &openapi.Schema{Type: openapi.Types{"array"}, Items: book}
+
+	s := doc.Component("Book")
+	s.Properties["id"].Minimum = openapi.Ptr(1.0)
+	s.Properties["version"].Minimum = openapi.Ptr(1.0)
+	s.Properties["version"].Description = "Grows by one on every change; the ETag is its quoted value."
+	s.Properties["isbn"].Description = "ISBN-13, unique across books."
+	describeBookFields(s)
+
+	s = doc.Component("BookInput")
+	s.Properties["title"].MinLength, s.Properties["title"].MaxLength = openapi.Ptr(1), openapi.Ptr(maxTitleLen)
+	s.Properties["author"].MinLength, s.Properties["author"].MaxLength = openapi.Ptr(1), openapi.Ptr(maxAuthorLen)
+	s.Properties["author"].Description = "The first author. Required unless authors is sent; if both are sent they must agree."
+	s.Properties["authors"].Items.MinLength, s.Properties["authors"].Items.MaxLength = openapi.Ptr(1), openapi.Ptr(maxAuthorLen)
+	s.Properties["isbn"].Description = "ISBN-10 or ISBN-13; hyphens and spaces are ignored. Stored as ISBN-13 and unique."
+	s.Properties["year"].Minimum = openapi.Ptr(1.0)
+	s.Properties["publisher"].MaxLength = openapi.Ptr(maxPublisherLen)
+	s.Properties["tags"].Items.MinLength, s.Properties["tags"].Items.MaxLength = openapi.Ptr(1), openapi.Ptr(maxTagLen)
+	describeBookFields(s)
+
+	// PUT treats missing or empty members as "unchanged"; a merge patch may
+	// also send null, which removes (and so fails validation for) a member.
+	update := &openapi.Schema{Type: s.Type, Properties: map[string]*openapi.Schema{}, AdditionalProperties: false}
+	patch := &openapi.Schema{Type: s.Type, Properties: map[string]*openapi.Schema{}, AdditionalProperties: false}
+	for name, p := range s.Properties {
+		u := *p
+		u.MinLength = nil
+		update.Properties[name] = &u
+		n := u
+		n.Type = append(slices.Clone(p.Type), "null")
+		patch.Properties[name] = &n
+	}
+	doc.Components.Schemas["BookUpdate"] = update
+	doc.Components.Schemas["BookPatch"] = patch
+
+	id := openapi.Parameter{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: openapi.Types{"integer"}, Format: "int64", Minimum: openapi.Ptr(1.0)}}
+	ifMatch := openapi.Parameter{Name: "If-Match", In: "header", Description: "Apply only if the book's ETag matches; otherwise 412.",
+		Schema: &openapi.Schema{Type: openapi.Types{"string"}}}
+	etag := map[string]openapi.Header{"ETag": {Description: "Strong entity tag of the book version.", Required: true, Schema: &openapi.Schema{Type: openapi.Types{"string"}}}}
+	problems := func(codes ...string) map[string]*openapi.Response {
+		out := make(map[string]*openapi.Response)
+		for _, c := range codes {
+			out[c] = &openapi.Response{Description: problemDescriptions[c], Content: jsonContent("application/problem+json", problem)}
+		}
+		return out
+	}
+	with := func(rs map[string]*openapi.Response, code string, r *openapi.Response) map[string]*openapi.Response {
+		rs[code] = r
+		return rs
+	}
+	str := func(name, desc string, enum ...any) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: desc, Schema: &openapi.Schema{Type: openapi.Types{"string"}, Enum: enum}}
+	}
+	integer := func(name, desc string, min float64) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: desc, Schema: &openapi.Schema{Type: openapi.Types{"integer"}, Minimum: openapi.Ptr(min)}}
+	}
+
+	doc.Path("/books").Post = &openapi.Operation{
+		OperationID: "createBook", Summary: "Create a book", Tags: []string{"books"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: jsonContent("application/json", input)},
+		Responses:   with(problems("400", "409", "413", "500"), "201", &openapi.Response{Description: "Created", Headers: etag, Content: jsonContent("application/json", book)}),
+	}
+	// match selects books; filters adds the ordering of listings.
+	match := []openapi.Parameter{
+		str("author", "Exact match on any of the authors."),
+		str("q", "Case-insensitive title substring."),
+		str("isbn", "ISBN-10 or ISBN-13 of the book."),
+		str("publisher", "Exact publisher match."),
+		str("language", "BCP 47 language tag."),
+		integer("year", "Publication year.", 1),
+		integer("year_from", "Earliest publication year.", 1),
+		integer("year_to", "Latest publication year.", 1),
+		{Name: "tag", In: "query", Description: "Repeatable; books must have every tag given.",
+			Schema: &openapi.Schema{Type: openapi.Types{"array"}, Items: &openapi.Schema{Type: openapi.Types{"string"}}}},
+	}
+	filters := append(slices.Clone(match),
+		str("sort", "Sort field; ties break on id.", "id", "title", "author", "added"),
+		str("order", "Sort direction.", "asc", "desc"),
+	)
+	doc.Path("/books").Get = &openapi.Operation{
+		OperationID: "listBooks", Summary: "List books one page at a time", Tags: []string{"books"},
+		Parameters: append(slices.Clone(filters),
+			openapi.Parameter{Name: "limit", In: "query", Description: "Page size.", Schema: &openapi.Schema{Type: openapi.Types{"integer"}, Minimum: openapi.Ptr(1.0)}},
+			str("page_token", "Opaque token from the previous page."),
+			str("cursor", "Alias of page_token."),
+		),
+		Responses: with(problems("400", "500"), "200", &openapi.Response{Description: "One page of books",
+			Headers: map[string]openapi.Header{
+				"Link":         {Description: `rel="next" link when more books follow.`, Schema: &openapi.Schema{Type: openapi.Types{"string"}}},
+				nextPageHeader: {Description: "page_token of the next page when more books follow.", Schema: &openapi.Schema{Type: openapi.Types{"string"}}},
+			},
+			Content: jsonContent("application/json", page)}),
+	}
+
+	// NDJSON bodies hold one schema instance per line.
+	csvText := openapi.MediaType{Schema: &openapi.Schema{Type: openapi.Types{"string"}, Description: "Headed CSV with columns " + strings.Join(csvColumns, ",") + "."}}
+	doc.Path("/books:import").Post = &openapi.Operation{
+		OperationID: "importBooks", Summary: "Create books from NDJSON or CSV", Tags: []string{"books"},
+		Parameters: []openapi.Parameter{str("mode", "all-or-nothing rejects the import if any line is invalid; best-effort stores the valid lines in batches. "+
+			"If a best-effort batch fails after others were stored, the 409 or 500 problem carries the partial report under import.",
+			importAllOrNothing, importBestEffort)},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			ndjsonType: {Schema: input},
+			csvType:    csvText,
+		}},
+		Responses: with(problems("400", "409", "413", "415", "422", "500"), "200", &openapi.Response{Description: "Import report",
+			Content: jsonContent("application/json", doc.Ref(importReport{}))}),
+	}
+	doc.Path("/books:export").Get = &openapi.Operation{
+		OperationID: "exportBooks", Summary: "Stream every matching book as NDJSON or CSV", Tags: []string{"books"},
+		Parameters: append(slices.Clone(filters), str("format", "Defaults to the Accept header, then ndjson.", "ndjson", "csv")),
+		Responses: with(problems("400", "500"), "200", &openapi.Response{Description: "The export", Content: map[string]openapi.MediaType{
+			ndjsonType: {Schema: book},
+			csvType:    csvText,
+		}}),
+	}
+	doc.Path("/books:search").Get = &openapi.Operation{
+		OperationID: "searchBooks", Summary: "Rank books by relevance to a query", Tags: []string{"books"},
+		Parameters: []openapi.Parameter{
+			str("q", "Words to find in titles and authors, ignoring case and accents; each must match a word or its start. Empty matches every book."),
+			str("author", "Exact match on any of the authors."),
+			str("facets", "Facets to count over all matches.", search.FacetAuthor),
+			integer("facet_limit", "Values returned per facet.", 1),
+			integer("limit", "Page size.", 1),
+			integer("offset", "Hits to skip.", 0),
+		},
+		Responses: with(problems("400", "501"), "200", &openapi.Response{Description: "One page of hits, best first",
+			Content: jsonContent("application/json", doc.Ref(searchResult{}))}),
+	}
+
+	item := doc.Path("/books/{id}")
+	item.Get = &openapi.Operation{
+		OperationID: "getBook", Summary: "Fetch a book", Tags: []string{"books"},
+		Parameters: []openapi.Parameter{id, {Name: "If-None-Match", In: "header", Description: "Answer 304 while the ETag still matches.",
+			Schema: &openapi.Schema{Type: openapi.Types{"string"}}}},
+		Responses: with(with(problems("400", "404", "500"),
+			"200", &openapi.Response{Description: "The book", Headers: etag, Content: jsonContent("application/json", book)}),
+			"304", &openapi.Response{Description: "Not modified", Headers: etag}),
+	}
+	item.Put = &openapi.Operation{
+		OperationID: "updateBook", Summary: "Update a book; empty members are left unchanged", Tags: []string{"books"},
+		Parameters:  []openapi.Parameter{id, ifMatch},
+		RequestBody: &openapi.RequestBody{Required: true, Content: jsonContent("application/json", &openapi.Schema{Ref: "#/components/schemas/BookUpdate"})},
+		Responses:   with(problems("400", "404", "409", "412", "413", "500"), "200", &openapi.Response{Description: "Updated", Headers: etag, Content: jsonContent("application/json", book)}),
+	}
+	item.Patch = &openapi.Operation{
+		OperationID: "patchBook", Summary: "Apply a JSON Merge Patch (RFC 7396)", Tags: []string{"books"},
+		Parameters:  []openapi.Parameter{id, ifMatch},
+		RequestBody: &openapi.RequestBody{Required: true, Content: jsonContent(mergePatchType, &openapi.Schema{Ref: "#/components/schemas/BookPatch"})},
+		Responses:   with(problems("400", "404", "409", "412", "413", "415", "500"), "200", &openapi.Response{Description: "Patched", Headers: etag, Content: jsonContent("application/json", book)}),
+	}
+	item.Delete = &openapi.Operation{
+		OperationID: "deleteBook", Summary: "Delete a book", Tags: []string{"books"},
+		Parameters: []openapi.Parameter{id, ifMatch},
+		Responses:  with(problems("400", "404", "412", "500"), "204", &openapi.Response{Description: "Deleted"}),
+	}
+
+	eventSchema := doc.Ref(bookEvent{})
+	doc.Component("BookEvent").Properties["type"].Enum = []any{string(events.Created), string(events.Updated), string(events.Deleted), eventReset}
+	doc.Component("BookEvent").Properties["book"].Description = "The book after the change; for deleted, as it was last stored. Absent on reset."
+	doc.Path("/books/events").Get = &openapi.Operation{
+		OperationID: "streamBookEvents", Summary: "Follow book changes as Server-Sent Events", Tags: []string{"books"},
+		Parameters: append(slices.Clone(match),
+			openapi.Parameter{Name: "Last-Event-ID", In: "header", Description: "Resume after this event; missed events still in the history are replayed, otherwise a reset event is sent first.",
+				Schema: &openapi.Schema{Type: openapi.Types{"string"}}},
+			str("last_event_id", "Same as Last-Event-ID, for clients that cannot set headers."),
+		),
+		Responses: with(problems("400", "501", "503"), "200", &openapi.Response{
+			Description: "An endless stream; each event's data is a BookEvent, its id the event ID and its event name the type. Idle streams carry heartbeat comments.",
+			Content:     map[string]openapi.MediaType{eventStreamType: {Schema: eventSchema}},
+		}),
+	}
+
+	doc.Path("/books/isbn/{isbn}").Get = &openapi.Operation{
+		OperationID: "getBookByISBN", Summary: "Fetch a book by ISBN", Tags: []string{"books"},
+		Parameters: []openapi.Parameter{
+			{Name: "isbn", In: "path", Required: true, Description: "ISBN-10 or ISBN-13; hyphens are ignored.", Schema: &openapi.Schema{Type: openapi.Types{"string"}}},
+			{Name: "If-None-Match", In: "header", Description: "Answer 304 while the ETag still matches.", Schema: &openapi.Schema{Type: openapi.Types{"string"}}},
+		},
+		Responses: with(with(problems("400", "404", "500"),
+			"200", &openapi.Response{Description: "The book", Headers: map[string]openapi.Header{
+				"ETag":             etag["ETag"],
+				"Content-Location": {Description: "The book's /books/{id} URL.", Required: true, Schema: &openapi.Schema{Type: openapi.Types{"string"}}},
+			}, Content: jsonContent("application/json", book)}),
+			"304", &openapi.Response{Description: "Not modified", Headers: etag}),
+	}
+}
+
+// describeBookFields documents the publication members shared by Book and
+// BookInput.
+func describeBookFields(s *openapi.Schema) {
+	s.Properties["authors"].Description = "Every author in credit order; the first is also author."
+	s.Properties["language"].Description = "BCP 47 language tag such as en or pt-BR, stored in canonical form."
+	s.Properties["tags"].Description = "Free-form labels, stored sorted and without duplicates."
+}
+
+var problemDescriptions = map[string]string{
+	"400": "Validation failed or the body is malformed",
+	"404": "No such book",
+	"412": "If-Match did not match the current ETag",
+	"409": "Another book already has this ISBN",
+	"413": "Body exceeds the size limit",
+	"415": "Unsupported Content-Type",
+	"422": "Some import lines are invalid; nothing was imported",
+	"500": "Storage failure",
+	"501": "No search index or change feed is configured",
+	"503": "The server is shutting down",
+}
+
+func describeTriggers(doc *openapi.Document, reg *registry.Registry, problem *openapi.Schema) {
+	failed := func(desc string) *openapi.Response {
+		return &openapi.Response{Description: desc, Content: jsonContent("application/problem+json", problem)}
+	}
+	list := func(name, desc string) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: desc, Schema: &openapi.Schema{Type: openapi.Types{"string"}}}
+	}
+
+	doc.Path("/trigger/targets").Get = &openapi.Operation{
+		OperationID: "listTriggerTargets", Summary: "List the loaded trigger targets", Tags: []string{"triggers"},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "Targets in declaration order", Content: jsonContent("application/json", &openapi.Schema{Type: openapi.Types{"array"}, Items: doc.Ref(targetView{})})},
+		},
+	}
+
+	fanout := jsonContent("application/json", doc.Ref(FanoutResponse{}))
+	doc.Component("FanoutResponse").Properties["status"].Enum = []any{"ok", "degraded", "failed"}
+	doc.Path("/trigger/allservices").Get = &openapi.Operation{
+		OperationID: "triggerAllServices", Summary: "Call every fan-out target in parallel", Tags: []string{"triggers"},
+		Parameters: []openapi.Parameter{
+			list("only", "Comma-separated target names to call."),
+			list("skip", "Comma-separated target names to leave out."),
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "Every target succeeded", Content: fanout},
+			"207": {Description: "Some targets failed", Content: fanout},
+			"502": {Description: "Every target failed", Content: fanout},
+			"400": failed("Unknown or empty target selection"),
+		},
+	}
+
+	var routes []any
+	for _, t := range reg.Targets() {
+		routes = append(routes, t.Route)
+	}
+	trigger := jsonContent("application/json", doc.Ref(TriggerResponse{}))
+	doc.Path("/trigger/{name}").Get = &openapi.Operation{
+		OperationID: "triggerTarget", Summary: "Call one target", Tags: []string{"triggers"},
+		Parameters: []openapi.Parameter{{Name: "name", In: "path", Required: true, Description: "Route of a registry target.",
+			Schema: &openapi.Schema{Type: openapi.Types{"string"}, Enum: routes}}},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The target answered with an expected status", Content: trigger},
+			"502": {Description: "The target answered with an unexpected status", Content: trigger},
+			"404": failed("Unknown target"),
+			"500": failed("The target could not be reached"),
+		},
+	}
+}
+
+// describeSecurity documents the credentials the router accepts and, on
+// every operation with a scope, the scope it requires.
+func describeSecurity(doc *openapi.Document, problem *openapi.Schema) {
+	doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+		"apiKey": {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "Static API key; also accepted as a bearer token."},
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "HS256 or RS256 token with the scopes in its scope or scp claim."},
+	}
+	for pattern, scope := range Scopes() {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, path = "", pattern
+		}
+		item, ok := doc.Paths[path]
+		if !ok {
+			continue
+		}
+		for _, m := range item.Methods() {
+			if method != "" && m != method {
+				continue
+			}
+			op := item.Operation(m)
+			op.Security = []openapi.SecurityRequirement{{"apiKey": {scope}}, {"bearer": {scope}}}
+			op.Responses["401"] = &openapi.Response{Description: "Missing or invalid credentials",
+				Headers: map[string]openapi.Header{"WWW-Authenticate": {Required: true, Schema: &openapi.Schema{Type: openapi.Types{"string"}}}},
+				Content: jsonContent("application/problem+json", problem)}
+			op.Responses["403"] = &openapi.Response{Description: "The credentials lack the " + scope + " scope",
+				Content: jsonContent("application/problem+json", problem)}
+		}
+	}
+}
+
+// describeIdempotency documents Idempotency-Key and the responses it adds
+// on every operation of IdempotentRoutes.
+func describeIdempotency(doc *openapi.Document, problem *openapi.Schema) {
+	key := openapi.Parameter{Name: idempotency.Header, In: "header",
+		Description: "Run the request once: repeats with the same key within IDEMPOTENCY_TTL (24h by default) get the first response again.",
+		Schema:      &openapi.Schema{Type: openapi.Types{"string"}, MinLength: openapi.Ptr(1), MaxLength: openapi.Ptr(255)}}
+	replayed := openapi.Header{Description: "Present, as true, when the response is a replay.", Schema: &openapi.Schema{Type: openapi.Types{"string"}, Enum: []any{"true"}}}
+	added := map[string]string{
+		"400": "The Idempotency-Key is invalid",
+		"409": "A request with this Idempotency-Key is still in progress",
+		"422": "The Idempotency-Key was used for a different request",
+	}
+	for _, pattern := range IdempotentRoutes() {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, path = "", pattern
+		}
+		item, ok := doc.Paths[path]
+		if !ok {
+			continue
+		}
+		for _, m := range item.Methods() {
+			if method != "" && m != method {
+				continue
+			}
+			op := item.Operation(m)
+			op.Parameters = append(op.Parameters, key)
+			for code, desc := range added {
+				r, ok := op.Responses[code]
+				if !ok {
+					op.Responses[code] = &openapi.Response{Description: desc, Content: jsonContent("application/problem+json", problem)}
+					continue
+				}
+				r.Description += "; or " + strings.ToLower(desc[:1]) + desc[1:]
+				if _, ok := r.Content["application/problem+json"]; !ok {
+					r.Content = maps.Clone(r.Content)
+					r.Content["application/problem+json"] = openapi.MediaType{Schema: problem}
+				}
+			}
+			// Responses below 500 are stored and may be replays.
+			for code, r := range op.Responses {
+				if code >= "500" {
+					continue
+				}
+				r.Headers = maps.Clone(r.Headers)
+				if r.Headers == nil {
+					r.Headers = make(map[string]openapi.Header)
+				}
+				r.Headers[idempotency.ReplayedHeader] = replayed
+			}
+		}
+	}
+}
+
+func jsonContent(mediaType string, s *openapi.Schema) map[string]openapi.MediaType {
+	return map[string]openapi.MediaType{mediaType: {Schema: s}}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"http-rest/internal/problem"
+	"http-rest/internal/types"
+)
+
+// mergePatchType is the RFC 7396 media type PATCH /books/{id} accepts.
+const mergePatchType = "application/merge-patch+json"
+
+const problemUnsupportedMediaType = "/problems/unsupported-media-type"
+
+// fieldErrors carries validation failures out of a store update callback.
+type fieldErrors []FieldError
+
+func (e fieldErrors) Error() string { return "invalid fields" }
+
+// Fields a patch may not touch because the server maintains them.
+var readOnlyFields = map[string]bool{"id": true, "added": true, "updated": true, "version": true}
+
+// patchableFields are the JSON names of bookInput.
+var patchableFields = jsonFields(bookInput{})
+
+// jsonFields returns the JSON member names of struct v.
+func jsonFields(v any) map[string]bool {
+	out := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			out[name] = true
+		}
+	}
+	return out
+}
+
+// mergePatch applies an RFC 7396 merge patch to target: members set to
+// null are removed, objects merge recursively and anything else replaces.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// applyBookPatch merges patch into b's editable fields and validates the
+// result with the same rules as POST /books.
+func applyBookPatch(b *types.Book, patch map[string]any) error {
+	var errs []FieldError
+	keys := make([]string, 0, len(patch))
+	for k := range patch {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		switch {
+		case readOnlyFields[k]:
+			errs = append(errs, FieldError{Field: k, Message: "is read-only"})
+		case !patchableFields[k]:
+			errs = append(errs, FieldError{Field: k, Message: "unknown field"})
+		}
+	}
+	if len(errs) > 0 {
+		return fieldErrors(errs)
+	}
+
+	var doc map[string]any
+	cur, _ := json.Marshal(inputFor(*b))
+	_ = json.Unmarshal(cur, &doc)
+	// author names the first of authors: a patch that sets only author
+	// replaces that entry and keeps the co-authors, and one that sets only
+	// authors lets author follow it; removing authors keeps only author.
+	_, setsAuthor := patch["author"]
+	_, setsAuthors := patch["authors"]
+	switch {
+	case setsAuthor && !setsAuthors:
+		if a, ok := patch["author"].(string); ok && len(b.Authors) > 0 {
+			doc["authors"].([]any)[0] = a
+		} else {
+			delete(doc, "authors")
+		}
+	case setsAuthors && !setsAuthor && patch["authors"] != nil:
+		delete(doc, "author")
+	}
+	merged, _ := json.Marshal(mergePatch(doc, patch))
+	var in bookInput
+	if err := json.Unmarshal(merged, &in); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return fieldErrors{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}
+		}
+		return err
+	}
+	if errs := in.validate(); len(errs) > 0 {
+		return fieldErrors(errs)
+	}
+	in.apply(b)
+	return nil
+}
+
+func (h *BookHandler) patch(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != mergePatchType {
+		w.Header().Set("Accept-Patch", mergePatchType)
+		writeProblem(w, r, problem.Problem{
+			Type:   problemUnsupportedMediaType,
+			Title:  "Unsupported media type",
+			Status: http.StatusUnsupportedMediaType,
+			Detail: "PATCH bodies must be " + mergePatchType,
+		})
+		return
+	}
+	var body any
+	if !decodeJSON(w, r, h.bodyLimit(), &body) {
+		return
+	}
+	patch, ok := body.(map[string]any)
+	if !ok {
+		writeProblem(w, r, problem.Problem{Type: problemMalformedBody, Title: "Malformed request body", Status: http.StatusBadRequest,
+			Detail: "merge patch must be a JSON object"})
+		return
+	}
+	b, err := h.store.Update(r.Context(), id, func(b *types.Book) error {
+		if err := checkIfMatch(r, *b); err != nil {
+			return err
+		}
+		return applyBookPatch(b, patch)
+	})
+	if err != nil {
+		h.storeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(b))
+	writeJSON(w, 200, b)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"http-rest/internal/problem"
+)
+
+// Problem is the RFC 7807 problem details body of the Books API: the
+// shared members plus the extensions of bulk imports.
+type Problem struct {
+	problem.Problem
+	// Lines lists the rejected lines of a bulk import.
+	Lines []LineError `json:"lines,omitempty"`
+	// Import is the partial report of a best-effort import that stopped
+	// at a failed batch.
+	Import *importReport `json:"import,omitempty"`
+}
+
+// FieldError is one violated constraint on a request field.
+type FieldError = problem.FieldError
+
+// Problem types used by the Books API. Statuses that need no further
+// explanation (404, 500) use "about:blank" as RFC 7807 suggests.
+const (
+	problemValidation    = "/problems/validation"
+	problemMalformedBody = "/problems/malformed-body"
+	problemBodyTooLarge  = "/problems/body-too-large"
+	problemDuplicateISBN = "/problems/duplicate-isbn"
+)
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem.Problem) {
+	problem.Write(w, r, &p)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problem.Problem{Status: http.StatusNotFound, Detail: "no book at " + r.URL.Path})
+}
+
+func validationProblem(w http.ResponseWriter, r *http.Request, errs ...FieldError) {
+	writeProblem(w, r, problem.Problem{
+		Type:   problemValidation,
+		Title:  "Request validation failed",
+		Status: http.StatusBadRequest,
+		Errors: errs,
+	})
+}
+
+// pathID parses the {id} path value, reporting a validation problem when it
+// is not a positive integer.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		validationProblem(w, r, FieldError{Field: "id", Message: "must be a positive integer"})
+		return 0, false
+	}
+	return id, true
+}
+
+// decodeJSON reads exactly one JSON object of at most limit bytes into v,
+// rejecting unknown fields. On failure it writes the problem and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, limit int64, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after JSON object")
+	}
+	if err == nil {
+		return true
+	}
+
+	var (
+		maxErr    *http.MaxBytesError
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxErr):
+		writeProblem(w, r, problem.Problem{
+			Type:   problemBodyTooLarge,
+			Title:  "Request body too large",
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("body must not exceed %d bytes", maxErr.Limit),
+		})
+	case errors.As(err, &typeErr):
+		validationProblem(w, r, FieldError{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		validationProblem(w, r, FieldError{Field: field, Message: "unknown field"})
+	case errors.Is(err, io.EOF):
+		writeProblem(w, r, problem.Problem{Type: problemMalformedBody, Title: "Malformed request body", Status: http.StatusBadRequest, Detail: "body must be a JSON object"})
+	case errors.As(err, &syntaxErr):
+		writeProblem(w, r, problem.Problem{Type: problemMalformedBody, Title: "Malformed request body", Status: http.StatusBadRequest,
+			Detail: fmt.Sprintf("invalid JSON at offset %d", syntaxErr.Offset)})
+	default:
+		writeProblem(w, r, problem.Problem{Type: problemMalformedBody, Title: "Malformed request body", Status: http.StatusBadRequest, Detail: err.Error()})
+	}
+	return false
+}
+
+// checkText validates a free-text field: required fields must be non-blank,
+// and every value must be at most max characters.
+func checkText(errs []FieldError, field, value string, required bool, max int) []FieldError {
+	switch {
+	case required && strings.TrimSpace(value) == "":
+		return append(errs, FieldError{Field: field, Message: "is required"})
+	case !required && value != "" && strings.TrimSpace(value) == "":
+		return append(errs, FieldError{Field: field, Message: "must not be blank"})
+	case utf8.RuneCountInString(value) > max:
+		return append(errs, FieldError{Field: field, Message: fmt.Sprintf("must be at most %d characters", max)})
+	}
+	return errs
+}
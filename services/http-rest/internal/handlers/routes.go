@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// Scopes a caller needs for the Books and trigger routes.
+const (
+	ScopeBooksRead   = "books:read"
+	ScopeBooksWrite  = "books:write"
+	ScopeTriggersRun = "triggers:run"
+)
+
+// Mux is the part of *http.ServeMux the handlers register routes on.
+type Mux interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// route is one mux pattern, the scope it requires and its handler.
+type route struct {
+	pattern string
+	scope   string
+	handler http.HandlerFunc
+}
+
+func register(mux Mux, routes []route) {
+	for _, rt := range routes {
+		mux.HandleFunc(rt.pattern, rt.handler)
+	}
+}
+
+// Scopes maps every pattern registered by BookHandler and Triggers to the
+// scope it requires.
+func Scopes() map[string]string {
+	out := make(map[string]string)
+	for _, rt := range append((*BookHandler)(nil).routes(), (*Triggers)(nil).routes()...) {
+		out[rt.pattern] = rt.scope
+	}
+	return out
+}
+
+// IdempotentRoutes lists the patterns that honour Idempotency-Key: those
+// whose repeats would create another book or call a target again.
+func IdempotentRoutes() []string {
+	out := []string{"POST /books"}
+	for _, rt := range (*Triggers)(nil).routes() {
+		out = append(out, rt.pattern)
+	}
+	return out
+}
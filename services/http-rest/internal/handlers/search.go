@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"cmp"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"http-rest/internal/problem"
+	"http-rest/internal/search"
+)
+
+const (
+	maxSearchOffset   = 10000
+	defaultFacetLimit = 20
+	maxFacetLimit     = 100
+)
+
+// searchResult is the body of GET /books:search.
+type searchResult struct {
+	Hits   []search.Hit                   `json:"hits"`
+	Total  int                            `json:"total"`
+	Facets map[string][]search.FacetCount `json:"facets,omitempty"`
+}
+
+// search ranks books by relevance to ?q= using the index; see search.Query.
+func (h *BookHandler) search(w http.ResponseWriter, r *http.Request) {
+	if h.Index == nil {
+		writeProblem(w, r, problem.Problem{Status: http.StatusNotImplemented, Detail: "no search index is configured"})
+		return
+	}
+	q := r.URL.Query()
+	query := search.Query{Text: q.Get("q"), Author: q.Get("author"), Limit: cmp.Or(h.DefaultPageSize, defaultPageSize)}
+	var errs []FieldError
+	intParam := func(name string, lo, hi int, dst *int) {
+		v := q.Get(name)
+		if v == "" {
+			return
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo {
+			errs = append(errs, FieldError{Field: name, Message: "must be an integer of at least " + strconv.Itoa(lo)})
+			return
+		}
+		*dst = min(n, hi)
+	}
+	intParam("limit", 1, cmp.Or(h.MaxPageSize, maxPageSize), &query.Limit)
+	intParam("offset", 0, maxSearchOffset, &query.Offset)
+	if v := q.Get("facets"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != search.FacetAuthor {
+				errs = append(errs, FieldError{Field: "facets", Message: "must be author"})
+				break
+			}
+			query.Facets = append(query.Facets, f)
+		}
+		query.FacetLimit = defaultFacetLimit
+		intParam("facet_limit", 1, maxFacetLimit, &query.FacetLimit)
+	}
+	if len(errs) > 0 {
+		validationProblem(w, r, errs...)
+		return
+	}
+
+	res := h.Index.Search(query)
+	writeJSON(w, http.StatusOK, searchResult{Hits: res.Hits, Total: res.Total, Facets: res.Facets})
+}
@@ -0,0 +1,61 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"runtime"
+)
+
+// Goroutines fails when the process runs more than max goroutines, a sign
+// of a leak that only a restart will cure.
+func Goroutines(max int) func(context.Context) error {
+	return func(context.Context) error {
+		if n := runtime.NumGoroutine(); n > max {
+			return fmt.Errorf("%d goroutines, limit %d", n, max)
+		}
+		return nil
+	}
+}
+
+// Flag fails with msg while ok returns false; use it to expose state such
+// as a server draining.
+func Flag(ok func() bool, msg string) func(context.Context) error {
+	return func(context.Context) error {
+		if !ok() {
+			return errors.New(msg)
+		}
+		return nil
+	}
+}
+
+// Dial checks that a TCP connection to rawURL's host can be opened. Only
+// the connection is tested: no request is sent, so endpoints with side
+// effects are safe to probe.
+func Dial(rawURL string) func(context.Context) error {
+	u, err := url.Parse(rawURL)
+	addr := ""
+	if err == nil {
+		addr = u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+	return func(ctx context.Context) error {
+		if err != nil {
+			return err
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
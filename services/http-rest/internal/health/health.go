@@ -0,0 +1,241 @@
+// Package health serves /livez and /readyz from registered checks. Results
+// are cached briefly so frequent probes do not hammer dependencies, and
+// every check keeps its last error for diagnosis.
+//
+// The JSON format is meant to be shared by every service:
+//
+//	{"status": "degraded", "checks": [{"name": "store", "status": "ok"}, ...]}
+//
+// ?verbose adds each check's latency, check time, criticality and last
+// error. status is "ok", "degraded" (only non-critical checks fail) or
+// "failing" (a critical check fails), which answers 503.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Statuses of a check and of a whole probe.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailing  = "failing"
+)
+
+// Check is one named probe.
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+	// Critical checks fail the endpoint with 503; others only degrade it.
+	Critical bool
+	// Timeout bounds one run; zero uses the checker's default.
+	Timeout time.Duration
+	// CacheTTL is how long a result is reused; zero uses the checker's
+	// default and a negative value runs the check on every probe.
+	CacheTTL time.Duration
+}
+
+// Result is the outcome of a check as reported in JSON. Only Name and
+// Status are set unless the probe is verbose.
+type Result struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Critical    *bool      `json:"critical,omitempty"`
+	Latency     string     `json:"latency,omitempty"`
+	LatencyMS   *float64   `json:"latency_ms,omitempty"`
+	CheckedAt   *time.Time `json:"checked_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Report is the body of /livez and /readyz.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Options configures a Checker.
+type Options struct {
+	Timeout  time.Duration // per check; defaults to 5s
+	CacheTTL time.Duration // defaults to 2s
+}
+
+// Checker runs the liveness and readiness checks.
+type Checker struct {
+	opts      Options
+	mu        sync.Mutex
+	liveness  []*entry
+	readiness []*entry
+}
+
+// entry is a registered check and its latest result.
+type entry struct {
+	Check
+	mu          sync.Mutex // held while the check runs, so probes coalesce
+	ran         bool
+	err         error
+	latency     time.Duration
+	checkedAt   time.Time
+	lastErr     error
+	lastErrorAt time.Time
+}
+
+// New returns a checker without checks.
+func New(opts Options) *Checker {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = 2 * time.Second
+	}
+	return &Checker{opts: opts}
+}
+
+// Liveness registers a check of the process itself. Liveness checks must
+// not depend on other services, or an outage would restart healthy pods.
+func (c *Checker) Liveness(ch Check) { c.add(&c.liveness, ch) }
+
+// Readiness registers a check of something needed to serve traffic.
+func (c *Checker) Readiness(ch Check) { c.add(&c.readiness, ch) }
+
+func (c *Checker) add(list *[]*entry, ch Check) {
+	if ch.Name == "" || ch.Func == nil {
+		panic("health: check needs a name and a func")
+	}
+	if ch.Timeout <= 0 {
+		ch.Timeout = c.opts.Timeout
+	}
+	if ch.CacheTTL == 0 {
+		ch.CacheTTL = c.opts.CacheTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*list = append(*list, &entry{Check: ch})
+}
+
+// Live runs the liveness checks.
+func (c *Checker) Live(ctx context.Context, verbose bool) Report {
+	return c.run(ctx, c.entries(&c.liveness), verbose)
+}
+
+// Ready runs the readiness checks.
+func (c *Checker) Ready(ctx context.Context, verbose bool) Report {
+	return c.run(ctx, c.entries(&c.readiness), verbose)
+}
+
+func (c *Checker) entries(list *[]*entry) []*entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*entry(nil), *list...)
+}
+
+// run runs the checks concurrently and summarises them.
+func (c *Checker) run(ctx context.Context, entries []*entry, verbose bool) Report {
+	results := make([]Result, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = e.result(ctx, verbose)
+		}()
+	}
+	wg.Wait()
+
+	rep := Report{Status: StatusOK, Checks: results}
+	for i, r := range results {
+		switch {
+		case r.Status == StatusOK:
+		case entries[i].Critical:
+			rep.Status = StatusFailing
+		case rep.Status == StatusOK:
+			rep.Status = StatusDegraded
+		}
+	}
+	return rep
+}
+
+// result runs the check unless a fresh cached result exists.
+func (e *entry) result(ctx context.Context, verbose bool) Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ran || e.CacheTTL < 0 || time.Since(e.checkedAt) >= e.CacheTTL {
+		e.runLocked(ctx)
+	}
+
+	r := Result{Name: e.Name, Status: StatusOK}
+	if e.err != nil {
+		r.Status = StatusFailing
+	}
+	if !verbose {
+		return r
+	}
+	ms := float64(e.latency.Microseconds()) / 1000
+	checked := e.checkedAt
+	r.Critical = &e.Critical
+	r.Latency, r.LatencyMS, r.CheckedAt = e.latency.String(), &ms, &checked
+	if e.err != nil {
+		r.Error = e.err.Error()
+	}
+	if e.lastErr != nil {
+		at := e.lastErrorAt
+		r.LastError, r.LastErrorAt = e.lastErr.Error(), &at
+	}
+	return r
+}
+
+func (e *entry) runLocked(ctx context.Context) {
+	// The probe's own cancellation must not be cached as a failure.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), e.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := safely(ctx, e.Func)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", e.Timeout)
+	}
+	e.ran, e.err, e.latency, e.checkedAt = true, err, time.Since(start), time.Now()
+	if err != nil {
+		e.lastErr, e.lastErrorAt = err, e.checkedAt
+	}
+}
+
+// safely runs f, reporting a panic as an error.
+func safely(ctx context.Context, f func(context.Context) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return f(ctx)
+}
+
+// LiveHandler serves the liveness report.
+func (c *Checker) LiveHandler() http.Handler { return c.handler(c.Live) }
+
+// ReadyHandler serves the readiness report.
+func (c *Checker) ReadyHandler() http.Handler { return c.handler(c.Ready) }
+
+func (c *Checker) handler(probe func(context.Context, bool) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verbose := r.URL.Query().Has("verbose") && r.URL.Query().Get("verbose") != "false"
+		rep := probe(r.Context(), verbose)
+		status := http.StatusOK
+		if rep.Status == StatusFailing {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(rep)
+	})
+}
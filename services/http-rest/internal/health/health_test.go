@@ -0,0 +1,95 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"http-rest/internal/health"
+)
+
+func probe(t *testing.T, h http.Handler, target string) (int, health.Report) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	var rep health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: Content-Type %q", target, ct)
+	}
+	return rec.Code, rep
+}
+
+func TestReadiness(t *testing.T) {
+	var draining atomic.Bool
+	checks := health.New(health.Options{})
+	checks.Liveness(health.Check{Name: "loop", Func: func(context.Context) error { return nil }, Critical: true})
+	checks.Readiness(health.Check{Name: "server", Func: health.Flag(func() bool { return !draining.Load() }, "draining"), Critical: true, CacheTTL: -1})
+	checks.Readiness(health.Check{Name: "target", Func: func(context.Context) error { return errors.New("unreachable") }})
+	live, ready := checks.LiveHandler(), checks.ReadyHandler()
+
+	for _, step := range []struct {
+		name     string
+		draining bool
+		h        http.Handler
+		code     int
+		status   string
+	}{
+		{"live", false, live, 200, health.StatusOK},
+		{"non-critical failure", false, ready, 200, health.StatusDegraded},
+		{"critical failure", true, ready, 503, health.StatusFailing},
+		{"liveness unaffected", true, live, 200, health.StatusOK},
+		{"recovered", false, ready, 200, health.StatusDegraded},
+	} {
+		draining.Store(step.draining)
+		code, rep := probe(t, step.h, "/")
+		if code != step.code || rep.Status != step.status {
+			t.Errorf("%s: %d %s, want %d %s", step.name, code, rep.Status, step.code, step.status)
+		}
+	}
+}
+
+func TestCacheAndVerbose(t *testing.T) {
+	var runs atomic.Int32
+	checks := health.New(health.Options{CacheTTL: time.Hour})
+	checks.Readiness(health.Check{Name: "store", Func: func(context.Context) error {
+		if runs.Add(1) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}})
+	checks.Readiness(health.Check{Name: "panics", CacheTTL: -1, Func: func(context.Context) error { panic("boom") }})
+	checks.Readiness(health.Check{Name: "slow", Timeout: time.Millisecond, CacheTTL: -1, Func: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	h := checks.ReadyHandler()
+
+	_, rep := probe(t, h, "/?verbose")
+	byName := map[string]health.Result{}
+	for _, r := range rep.Checks {
+		byName[r.Name] = r
+	}
+	if r := byName["store"]; r.Error != "connection refused" || r.Critical == nil || *r.Critical {
+		t.Errorf("store: %+v", r)
+	}
+	if r := byName["panics"]; r.Error != "panic: boom" {
+		t.Errorf("panics: %+v", r)
+	}
+	if r := byName["slow"]; r.Error != "timed out after 1ms" {
+		t.Errorf("slow: %+v", r)
+	}
+
+	// The cached failure is served again; the check does not rerun.
+	_, rep = probe(t, h, "/")
+	if runs.Load() != 1 || rep.Checks[0].Status != health.StatusFailing || rep.Checks[0].Error != "" {
+		t.Errorf("after %d runs: %+v, want the cached failure without details", runs.Load(), rep.Checks[0])
+	}
+}
@@ -0,0 +1,306 @@
+// Package idempotency makes retried requests safe: a request carrying an
+// Idempotency-Key header runs once, and repeats with the same key get the
+// stored response instead of running again. Keys live in a Store,
+// in-process by default.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"http-rest/internal/auth"
+	"http-rest/internal/problem"
+)
+
+// Header is the request header carrying the key; ReplayedHeader marks
+// stored responses sent again.
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxKeyLen bounds keys; UUIDs and similar tokens fit easily.
+const maxKeyLen = 255
+
+// pollEvery is how often a repeat checks whether the first request with
+// its key has finished.
+const pollEvery = 25 * time.Millisecond
+
+// requests counts keyed requests by outcome: executed, replayed,
+// in_progress (409) or mismatch (422).
+var requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "idempotent_requests_total",
+	Help: "Requests with an Idempotency-Key by outcome.",
+}, []string{"route", "outcome"})
+
+// Response is a stored response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Entry is the state of a key: the fingerprint of the request that
+// claimed it and, once that request has finished, its response.
+type Entry struct {
+	Fingerprint string
+	Response    *Response
+}
+
+// Store holds the keys. Implementations shared between instances (for
+// example on Redis) make keys work across a fleet; they must be safe for
+// concurrent use.
+type Store interface {
+	// Reserve claims key for a request with fingerprint and returns nil.
+	// If key is already claimed it changes nothing and returns the
+	// existing entry. Claims expire after ttl.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*Entry, error)
+	// Complete stores the response of the request that claimed key.
+	Complete(ctx context.Context, key string, resp Response) error
+	// Release drops the claim on key, so the next request runs.
+	Release(ctx context.Context, key string) error
+}
+
+// Options configures a Keeper. Zero values use the defaults.
+type Options struct {
+	// TTL is how long a key and its response are kept; default 24h.
+	TTL time.Duration
+	// Wait is how long a repeat waits for the first request with its key
+	// to finish before getting 409; zero answers 409 at once.
+	Wait time.Duration
+	// MaxBodyBytes bounds the request bodies read to fingerprint a
+	// request; default 1 MiB.
+	MaxBodyBytes int64
+	// MaxResponseBytes bounds the responses stored; a larger response is
+	// sent but not stored, and the key is released. Default 1 MiB.
+	MaxResponseBytes int
+}
+
+// Keeper applies Idempotency-Key handling to a set of routes.
+type Keeper struct {
+	store  Store
+	opts   Options
+	logger *slog.Logger
+}
+
+func New(store Store, opts Options, logger *slog.Logger) *Keeper {
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 1 << 20
+	}
+	if opts.MaxResponseBytes <= 0 {
+		opts.MaxResponseBytes = 1 << 20
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Keeper{store: store, opts: opts, logger: logger}
+}
+
+// Wrap handles keys on requests whose mux pattern is in patterns and
+// passes everything else through. Keys are scoped to the authenticated
+// caller, so Wrap must run after authentication. A request is identified
+// by its method, URI and exact body bytes; reusing a key for a different
+// request gets 422.
+//
+// Only responses below 500 are stored. After a server error or a panic
+// the key is released, so a retry runs the request again.
+func (k *Keeper) Wrap(mux *http.ServeMux, patterns []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, pattern := mux.Handler(r)
+		if !slices.Contains(patterns, pattern) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validKey(key) {
+			problem.Write(w, r, &problem.Problem{Type: "/problems/validation", Title: "Request validation failed", Status: http.StatusBadRequest,
+				Errors: []problem.FieldError{{Field: Header, Message: fmt.Sprintf("must be 1 to %d visible ASCII characters", maxKeyLen)}}})
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, k.opts.MaxBodyBytes+1))
+		if err != nil {
+			problem.Write(w, r, &problem.Problem{Type: "/problems/malformed-body", Title: "Malformed request body", Status: http.StatusBadRequest, Detail: "the body could not be read"})
+			return
+		}
+		if int64(len(body)) > k.opts.MaxBodyBytes {
+			problem.Write(w, r, &problem.Problem{Type: "/problems/body-too-large", Title: "Request body too large", Status: http.StatusRequestEntityTooLarge,
+				Detail: fmt.Sprintf("body exceeds %d bytes", k.opts.MaxBodyBytes)})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if p, ok := auth.FromContext(r.Context()); ok {
+			key = p.Subject + "\x00" + key
+		}
+		fp := fingerprint(r, body)
+
+		ctx := r.Context()
+		deadline := time.Now().Add(k.opts.Wait)
+		for {
+			e, err := k.store.Reserve(ctx, key, fp, k.opts.TTL)
+			if err != nil {
+				if ctx.Err() != nil {
+					return // the client went away while waiting
+				}
+				k.logger.Warn("idempotency store", "err", err, "route", pattern)
+				next.ServeHTTP(w, r)
+				return
+			}
+			switch {
+			case e == nil:
+				requests.WithLabelValues(pattern, "executed").Inc()
+				k.serve(w, r, pattern, key, next)
+				return
+			case e.Fingerprint != fp:
+				requests.WithLabelValues(pattern, "mismatch").Inc()
+				problem.Write(w, r, &problem.Problem{Type: "/problems/idempotency-key-reused", Title: "Idempotency key reused", Status: http.StatusUnprocessableEntity,
+					Detail: "the key was already used for a different request"})
+				return
+			case e.Response != nil:
+				requests.WithLabelValues(pattern, "replayed").Inc()
+				replay(w, e.Response)
+				return
+			case !time.Now().Before(deadline):
+				requests.WithLabelValues(pattern, "in_progress").Inc()
+				w.Header().Set("Retry-After", "1")
+				problem.Write(w, r, &problem.Problem{Type: "/problems/idempotency-key-in-use", Title: "Request in progress", Status: http.StatusConflict,
+					Detail: "a request with this key is still being processed; retry later"})
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollEvery):
+			}
+		}
+	})
+}
+
+// serve runs the request that claimed key and stores its response.
+func (k *Keeper) serve(w http.ResponseWriter, r *http.Request, pattern, key string, next http.Handler) {
+	// The outcome is recorded even if the client has gone away.
+	ctx := context.WithoutCancel(r.Context())
+	stored := false
+	defer func() {
+		if stored {
+			return
+		}
+		if err := k.store.Release(ctx, key); err != nil {
+			k.logger.Warn("idempotency store", "err", err, "route", pattern)
+		}
+	}()
+	rec := &recorder{ResponseWriter: w, before: w.Header().Clone(), max: k.opts.MaxResponseBytes}
+	next.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= 500 || rec.overflow {
+		return
+	}
+	if rec.header == nil {
+		rec.header = rec.changedHeader()
+	}
+	err := k.store.Complete(ctx, key, Response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()})
+	if err != nil {
+		k.logger.Warn("idempotency store", "err", err, "route", pattern)
+		return
+	}
+	stored = true
+}
+
+func replay(w http.ResponseWriter, resp *Response) {
+	h := w.Header()
+	for name, v := range resp.Header {
+		h[name] = slices.Clone(v)
+	}
+	h.Set(ReplayedHeader, "true")
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+// fingerprint identifies a request by method, URI and body.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func validKey(key string) bool {
+	if len(key) > maxKeyLen {
+		return false
+	}
+	for i := range len(key) {
+		if key[i] <= ' ' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// recorder passes a response through while keeping a copy of it, up to
+// max body bytes.
+type recorder struct {
+	http.ResponseWriter
+	before   http.Header // headers set by middleware before the handler
+	header   http.Header // headers the handler set, captured at WriteHeader
+	status   int
+	body     bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+		r.header = r.changedHeader()
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.overflow {
+		if r.body.Len()+len(p) > r.max {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// changedHeader returns the response headers that differ from before, so
+// a replay does not repeat another request's X-Request-ID or rate limit
+// headers.
+func (r *recorder) changedHeader() http.Header {
+	out := make(http.Header)
+	for name, v := range r.Header() {
+		if !slices.Equal(v, r.before[name]) {
+			out[name] = slices.Clone(v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps keys in process. State is lost on restart and not shared
+// between instances.
+type Memory struct {
+	// MaxKeys bounds the keys kept; when full, the oldest finished ones
+	// are forgotten early. Zero means 100000.
+	MaxKeys int
+
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	order   *list.List // of keys, oldest claim first
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	Entry
+	expires time.Time
+	elem    *list.Element // in order
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]*memoryEntry), order: list.New(), now: time.Now}
+}
+
+func (m *Memory) Reserve(_ context.Context, key, fingerprint string, ttl time.Duration) (*Entry, error) {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+	if e, ok := m.entries[key]; ok {
+		out := e.Entry
+		return &out, nil
+	}
+	m.entries[key] = &memoryEntry{
+		Entry:   Entry{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+		elem:    m.order.PushBack(key),
+	}
+	return nil, nil
+}
+
+func (m *Memory) Complete(_ context.Context, key string, resp Response) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		e.Response = &resp
+	}
+	return nil
+}
+
+func (m *Memory) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		m.order.Remove(e.elem)
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// expire drops the oldest keys while they have expired or, once finished,
+// while there are too many. Every key shares one TTL, so claim order is
+// expiry order; released keys have already left it. m.mu must be held.
+func (m *Memory) expire(now time.Time) {
+	limit := m.MaxKeys
+	if limit <= 0 {
+		limit = 100000
+	}
+	for el := m.order.Front(); el != nil; el = m.order.Front() {
+		key := el.Value.(string)
+		e := m.entries[key]
+		if now.Before(e.expires) && (len(m.entries) < limit || e.Response == nil) {
+			break
+		}
+		m.order.Remove(el)
+		delete(m.entries, key)
+	}
+}
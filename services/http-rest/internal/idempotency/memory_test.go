@@ -0,0 +1,43 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryReleaseForgetsClaim(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.MaxKeys = 10
+	for i := range 1000 {
+		key := fmt.Sprintf("k%d", i%3)
+		if e, err := m.Reserve(ctx, key, "f", time.Hour); err != nil || e != nil {
+			t.Fatalf("Reserve(%s) = %v, %v; want a new claim", key, e, err)
+		}
+		if err := m.Release(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.order.Len(); n != 0 {
+		t.Errorf("after releasing every claim, %d are still ordered", n)
+	}
+
+	// Finished keys beyond MaxKeys are forgotten oldest first.
+	for i := range 15 {
+		key := fmt.Sprintf("done%d", i)
+		if _, err := m.Reserve(ctx, key, "f", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Complete(ctx, key, Response{Status: 201}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(m.entries) != m.MaxKeys || m.order.Len() != m.MaxKeys {
+		t.Errorf("kept %d entries, %d ordered; want %d", len(m.entries), m.order.Len(), m.MaxKeys)
+	}
+	if _, ok := m.entries["done0"]; ok {
+		t.Error("oldest finished key was kept")
+	}
+}
@@ -0,0 +1,96 @@
+// Package isbn checks International Standard Book Numbers and converts
+// between their 10- and 13-digit forms.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid reports a value that is not a valid ISBN-10 or ISBN-13.
+var ErrInvalid = errors.New("isbn: not a valid ISBN-10 or ISBN-13")
+
+// Normalize checks s as an ISBN-10 or ISBN-13, ignoring hyphens and spaces,
+// and returns its 13-digit form, so both spellings of one book compare
+// equal.
+func Normalize(s string) (string, error) {
+	s = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		if r == 'x' {
+			return 'X'
+		}
+		return r
+	}, s)
+	switch {
+	case len(s) == 10 && valid10(s):
+		s = "978" + s[:9]
+		return s + string(check13(s)), nil
+	case len(s) == 13 && valid13(s):
+		return s, nil
+	}
+	return "", ErrInvalid
+}
+
+// To10 returns the ISBN-10 form of a normalized ISBN-13, or "" when it has
+// none: only 978-prefixed numbers do.
+func To10(isbn13 string) string {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+	s := isbn13[3:12]
+	sum := 0
+	for i := range 9 {
+		sum += int(s[i]-'0') * (10 - i)
+	}
+	c := (11 - sum%11) % 11
+	if c == 10 {
+		return s + "X"
+	}
+	return s + string(rune('0'+c))
+}
+
+// valid10 checks nine digits and a mod-11 check character, which may be X.
+func valid10(s string) bool {
+	sum := 0
+	for i := range 10 {
+		var d int
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c == 'X' && i == 9:
+			d = 10
+		default:
+			return false
+		}
+		sum += d * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// valid13 checks a 978 or 979 prefix and the mod-10 check digit.
+func valid13(s string) bool {
+	if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+		return false
+	}
+	for i := range 13 {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return check13(s[:12]) == s[12]
+}
+
+// check13 is the check digit for the first twelve digits of an ISBN-13.
+func check13(s string) byte {
+	sum := 0
+	for i := range 12 {
+		w := 1
+		if i%2 == 1 {
+			w = 3
+		}
+		sum += int(s[i]-'0') * w
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// HTTP records RED metrics for served requests: rate, errors and duration,
+// labelled by mux pattern, method and status class. Labelling by pattern
+// rather than path keeps the number of series bounded.
+type HTTP struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewHTTP registers the HTTP families on r; nil buckets use
+// prometheus.DefBuckets.
+func NewHTTP(r prometheus.Registerer, buckets []float64) *HTTP {
+	labels := []string{"route", "method", "status_class"}
+	f := promauto.With(r)
+	return &HTTP{
+		requests: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Requests served.",
+		}, labels),
+		errors: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_request_errors_total",
+			Help: "Requests answered with a 4xx or 5xx status.",
+		}, labels),
+		duration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time to serve a request, in seconds.",
+			Buckets: buckets,
+		}, labels),
+	}
+}
+
+// Observe records one request. An empty route (no pattern matched) is
+// reported as "unmatched".
+func (m *HTTP) Observe(route, method string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	method = knownMethod(method)
+	class := strconv.Itoa(status/100) + "xx"
+	m.requests.WithLabelValues(route, method, class).Inc()
+	if status >= 400 {
+		m.errors.WithLabelValues(route, method, class).Inc()
+	}
+	m.duration.WithLabelValues(route, method, class).Observe(d.Seconds())
+}
+
+// knownMethod folds methods outside RFC 9110 into "OTHER", so clients
+// cannot create series at will.
+func knownMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	}
+	return "OTHER"
+}
@@ -0,0 +1,82 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"http-rest/internal/metrics"
+)
+
+func TestHTTP(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.NewHTTP(reg, []float64{0.1, 1})
+	m.Observe("GET /books", "GET", 200, 50*time.Millisecond)
+	m.Observe("GET /books", "GET", 204, 500*time.Millisecond)
+	m.Observe("GET /books/{id}", "GET", 404, time.Millisecond)
+	m.Observe("", "BREW", 405, 2*time.Second)
+
+	want := `
+# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="GET /books",status_class="2xx"} 2
+http_requests_total{method="GET",route="GET /books/{id}",status_class="4xx"} 1
+http_requests_total{method="OTHER",route="unmatched",status_class="4xx"} 1
+# HELP http_request_errors_total Requests answered with a 4xx or 5xx status.
+# TYPE http_request_errors_total counter
+http_request_errors_total{method="GET",route="GET /books/{id}",status_class="4xx"} 1
+http_request_errors_total{method="OTHER",route="unmatched",status_class="4xx"} 1
+# HELP http_request_duration_seconds Time to serve a request, in seconds.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{method="GET",route="GET /books",status_class="2xx",le="0.1"} 1
+http_request_duration_seconds_bucket{method="GET",route="GET /books",status_class="2xx",le="1"} 2
+http_request_duration_seconds_bucket{method="GET",route="GET /books",status_class="2xx",le="+Inf"} 2
+http_request_duration_seconds_sum{method="GET",route="GET /books",status_class="2xx"} 0.55
+http_request_duration_seconds_count{method="GET",route="GET /books",status_class="2xx"} 2
+http_request_duration_seconds_bucket{method="GET",route="GET /books/{id}",status_class="4xx",le="0.1"} 1
+http_request_duration_seconds_bucket{method="GET",route="GET /books/{id}",status_class="4xx",le="1"} 1
+http_request_duration_seconds_bucket{method="GET",route="GET /books/{id}",status_class="4xx",le="+Inf"} 1
+http_request_duration_seconds_sum{method="GET",route="GET /books/{id}",status_class="4xx"} 0.001
+http_request_duration_seconds_count{method="GET",route="GET /books/{id}",status_class="4xx"} 1
+http_request_duration_seconds_bucket{method="OTHER",route="unmatched",status_class="4xx",le="0.1"} 0
+http_request_duration_seconds_bucket{method="OTHER",route="unmatched",status_class="4xx",le="1"} 0
+http_request_duration_seconds_bucket{method="OTHER",route="unmatched",status_class="4xx",le="+Inf"} 1
+http_request_duration_seconds_sum{method="OTHER",route="unmatched",status_class="4xx"} 2
+http_request_duration_seconds_count{method="OTHER",route="unmatched",status_class="4xx"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	ok := true
+	metrics.GaugeFunc(reg, "books_stored", "Books in the store.", func() (float64, bool) { return 42, ok })
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP books_stored Books in the store.
+# TYPE books_stored gauge
+books_stored 42
+`)); err != nil {
+		t.Error(err)
+	}
+	// A failed read leaves a gap rather than reporting zero.
+	ok = false
+	if n, err := testutil.GatherAndCount(reg); err != nil || n != 0 {
+		t.Errorf("failed read gathered %d samples, %v; want none", n, err)
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	if b, err := metrics.ParseBuckets(" 0.01, 0.5 ,1,"); err != nil || len(b) != 3 || b[1] != 0.5 {
+		t.Errorf("ParseBuckets = %v, %v", b, err)
+	}
+	for _, s := range []string{"", ",", "0.1,fast", "NaN", "+Inf"} {
+		if _, err := metrics.ParseBuckets(s); err == nil {
+			t.Errorf("ParseBuckets(%q) succeeded", s)
+		}
+	}
+}
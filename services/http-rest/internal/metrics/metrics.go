@@ -0,0 +1,56 @@
+// Package metrics holds the service's Prometheus helpers: request metrics
+// for the router, scrape-time gauges and bucket parsing. Metrics are kept
+// by github.com/prometheus/client_golang; cmd/api serves its default
+// registry.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gaugeFunc is a gauge read at scrape time that may have no value.
+type gaugeFunc struct {
+	desc *prometheus.Desc
+	f    func() (float64, bool)
+}
+
+func (g gaugeFunc) Describe(ch chan<- *prometheus.Desc) { ch <- g.desc }
+
+func (g gaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	if x, ok := g.f(); ok {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, x)
+	}
+}
+
+// GaugeFunc registers on r a gauge whose value f reads at every scrape.
+// When f reports false the sample is left out, so a failed read is a gap
+// rather than a misleading zero.
+func GaugeFunc(r prometheus.Registerer, name, help string, f func() (float64, bool)) {
+	r.MustRegister(gaugeFunc{prometheus.NewDesc(name, help, nil, nil), f})
+}
+
+// ParseBuckets reads comma-separated upper bounds such as
+// "0.01,0.05,0.1,0.5,1".
+func ParseBuckets(s string) ([]float64, error) {
+	var out []float64
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, fmt.Errorf("metrics: invalid bucket %q", f)
+		}
+		out = append(out, x)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("metrics: no buckets in %q", s)
+	}
+	return out, nil
+}
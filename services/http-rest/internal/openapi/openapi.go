@@ -0,0 +1,275 @@
+// Package openapi models the subset of OpenAPI 3.1 the service publishes,
+// derives JSON Schemas from Go types and validates JSON values against them.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Version is the OpenAPI release documents declare.
+const Version = "3.1.0"
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is an apiKey or http scheme.
+type SecurityScheme struct {
+	Type         string `json:"type"` // apiKey or http
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"` // apiKey
+	In           string `json:"in,omitempty"`   // apiKey
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// SecurityRequirement maps scheme names to the scopes they must grant; an
+// operation is allowed if any one of its requirements is met.
+type SecurityRequirement map[string][]string
+
+// PathItem holds the operations of one path template, keyed by method.
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path, query or header
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON Schema (draft 2020-12) restricted to the keywords the
+// service uses.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        Types              `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []any              `json:"enum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	// AdditionalProperties is a *Schema for maps; derived structs set it to
+	// false so undocumented members fail validation.
+	AdditionalProperties any      `json:"additionalProperties,omitempty"`
+	MinLength            *int     `json:"minLength,omitempty"`
+	MaxLength            *int     `json:"maxLength,omitempty"`
+	Minimum              *float64 `json:"minimum,omitempty"`
+}
+
+// Types is the JSON Schema "type" keyword. A single type is written as a
+// string; OpenAPI 3.1 expresses nullable values as ["string", "null"].
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*t = Types{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// Has reports whether name is one of the allowed types.
+func (t Types) Has(name string) bool {
+	for _, v := range t {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Ptr returns a pointer to v, for the optional numeric keywords.
+func Ptr[T any](v T) *T { return &v }
+
+// New returns an empty document.
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      make(map[string]*PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}
+}
+
+// Path returns the item for a path template, creating it if needed.
+func (d *Document) Path(path string) *PathItem {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	return item
+}
+
+// Operation returns the operation for method, or nil.
+func (p *PathItem) Operation(method string) *Operation {
+	switch method {
+	case "GET":
+		return p.Get
+	case "PUT":
+		return p.Put
+	case "POST":
+		return p.Post
+	case "DELETE":
+		return p.Delete
+	case "PATCH":
+		return p.Patch
+	}
+	return nil
+}
+
+// Methods lists the methods p defines, in a stable order.
+func (p *PathItem) Methods() []string {
+	var out []string
+	for _, m := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		if p.Operation(m) != nil {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Ref returns a reference to the component schema derived from v's type,
+// deriving and registering it on first use.
+func (d *Document) Ref(v any) *Schema {
+	return d.schemaFor(reflect.TypeOf(v))
+}
+
+// Component returns the registered schema for name, or nil.
+func (d *Document) Component(name string) *Schema {
+	return d.Components.Schemas[name]
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+func (d *Document) schemaFor(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: Types{"string"}, Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return d.schemaFor(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: Types{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: Types{"integer"}}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: Types{"integer"}, Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: Types{"number"}}
+	case reflect.String:
+		return &Schema{Type: Types{"string"}}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: Types{"array"}, Items: d.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: Types{"object"}, AdditionalProperties: d.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := componentName(t)
+		if _, ok := d.Components.Schemas[name]; !ok {
+			s := &Schema{Type: Types{"object"}, Properties: make(map[string]*Schema), AdditionalProperties: false}
+			d.Components.Schemas[name] = s // registered first so recursive types terminate
+			d.addFields(s, t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+// addFields adds the JSON members of struct t to s, flattening embedded
+// structs the way encoding/json does.
+func (d *Document) addFields(s *Schema, t reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			d.addFields(s, f.Type)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = d.schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// componentName upper-cases the first letter so unexported Go types get
+// conventional schema names.
+func componentName(t reflect.Type) string {
+	r, n := utf8.DecodeRuneInString(t.Name())
+	return string(unicode.ToUpper(r)) + t.Name()[n:]
+}
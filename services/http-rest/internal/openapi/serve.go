@@ -0,0 +1,135 @@
+package openapi
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Handler serves d as application/json. The document is encoded once.
+func Handler(d *Document) http.Handler {
+	body, err := json.MarshalIndent(d, "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	})
+}
+
+// DocsHandler serves a self-contained HTML overview of d that links to
+// specURL for the full document. It needs no external assets.
+func DocsHandler(d *Document, specURL string) http.Handler {
+	type op struct {
+		Method, Path string
+		*Operation
+	}
+	type group struct {
+		Tag string
+		Ops []op
+	}
+	var groups []*group
+	byTag := make(map[string]*group)
+	paths := make([]string, 0, len(d.Paths))
+	for p := range d.Paths {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		for _, m := range d.Paths[p].Methods() {
+			o := d.Paths[p].Operation(m)
+			tag := "default"
+			if len(o.Tags) > 0 {
+				tag = o.Tags[0]
+			}
+			g, ok := byTag[tag]
+			if !ok {
+				g = &group{Tag: tag}
+				byTag[tag] = g
+				groups = append(groups, g)
+			}
+			g.Ops = append(g.Ops, op{Method: m, Path: p, Operation: o})
+		}
+	}
+	data := struct {
+		Info    Info
+		SpecURL string
+		Groups  []*group
+	}{d.Info, specURL, groups}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = docsPage.Execute(w, data)
+	})
+}
+
+var docsPage = template.Must(template.New("docs").Funcs(template.FuncMap{
+	"lower":  strings.ToLower,
+	"schema": schemaLabel,
+	"codes": func(rs map[string]*Response) []string {
+		out := make([]string, 0, len(rs))
+		for c := range rs {
+			out = append(out, c)
+		}
+		slices.Sort(out)
+		return out
+	},
+}).Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Info.Title}}</title>
+<style>
+body{font:15px/1.5 system-ui,sans-serif;max-width:960px;margin:2em auto;padding:0 1em;color:#222}
+code{font:13px ui-monospace,monospace}
+.op{border:1px solid #ddd;border-radius:6px;margin:.8em 0;padding:.6em 1em}
+.m{display:inline-block;min-width:4.5em;font-weight:600;text-transform:uppercase}
+.get{color:#0969da}.post{color:#1a7f37}.put,.patch{color:#9a6700}.delete{color:#cf222e}
+table{border-collapse:collapse;margin:.4em 0}td,th{border-bottom:1px solid #eee;padding:.2em .8em .2em 0;text-align:left;vertical-align:top}
+</style>
+</head>
+<body>
+<h1>{{.Info.Title}} <small>{{.Info.Version}}</small></h1>
+<p>{{.Info.Description}}</p>
+<p>Machine-readable document: <a href="{{.SpecURL}}"><code>{{.SpecURL}}</code></a> (OpenAPI 3.1).</p>
+{{range .Groups}}
+<h2>{{.Tag}}</h2>
+{{range .Ops}}
+<div class="op" id="{{.OperationID}}">
+<div><span class="m {{lower .Method}}">{{.Method}}</span> <code>{{.Path}}</code> — {{.Summary}}</div>
+{{if .Parameters}}<table><tr><th>Parameter</th><th>In</th><th>Schema</th><th>Description</th></tr>
+{{range .Parameters}}<tr><td><code>{{.Name}}</code>{{if .Required}}*{{end}}</td><td>{{.In}}</td><td><code>{{schema .Schema}}</code></td><td>{{.Description}}</td></tr>
+{{end}}</table>{{end}}
+{{with .RequestBody}}<p>Body: {{range $ct, $mt := .Content}}<code>{{$ct}}</code> <code>{{schema $mt.Schema}}</code> {{end}}</p>{{end}}
+<table><tr><th>Status</th><th>Description</th><th>Body</th></tr>
+{{$rs := .Responses}}{{range codes $rs}}{{$r := index $rs .}}<tr><td>{{.}}</td><td>{{$r.Description}}</td><td>{{range $ct, $mt := $r.Content}}<code>{{$ct}}</code> <code>{{schema $mt.Schema}}</code> {{end}}</td></tr>
+{{end}}</table>
+</div>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// schemaLabel is a short human-readable name for s.
+func schemaLabel(s *Schema) string {
+	switch {
+	case s == nil:
+		return ""
+	case s.Ref != "":
+		return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	case s.Items != nil:
+		return schemaLabel(s.Items) + "[]"
+	case len(s.Enum) > 0:
+		parts := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			b, _ := json.Marshal(e)
+			parts[i] = string(b)
+		}
+		return strings.Join(parts, " | ")
+	}
+	return strings.Join(s.Type, " | ")
+}
@@ -0,0 +1,116 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Validate checks v, a value decoded by encoding/json into any, against s.
+// References are resolved against d's components.
+func (d *Document) Validate(s *Schema, v any) error {
+	return d.validate(s, v, "$")
+}
+
+func (d *Document) validate(s *Schema, v any, at string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		target := d.Components.Schemas[name]
+		if !ok || target == nil {
+			return fmt.Errorf("%s: unresolved reference %s", at, s.Ref)
+		}
+		return d.validate(target, v, at)
+	}
+	if len(s.Type) > 0 && !s.Type.Has(jsonType(v)) && !(jsonType(v) == "integer" && s.Type.Has("number")) {
+		return fmt.Errorf("%s: got %s, want %s", at, jsonType(v), strings.Join(s.Type, " or "))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fmt.Errorf("%s: %v is not one of %v", at, v, s.Enum)
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d characters", at, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: longer than %d characters", at, *s.MaxLength)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, v)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: %v is less than %v", at, v, *s.Minimum)
+		}
+	case []any:
+		for i, item := range v {
+			if err := d.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required member %q", at, name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			child := at + "." + k
+			if ps, ok := s.Properties[k]; ok {
+				if err := d.validate(ps, v[k], child); err != nil {
+					return err
+				}
+				continue
+			}
+			switch ap := s.AdditionalProperties.(type) {
+			case bool:
+				if !ap {
+					return fmt.Errorf("%s: undocumented member", child)
+				}
+			case *Schema:
+				if err := d.validate(ap, v[k], child); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType names the JSON Schema type of a decoded value.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,116 @@
+package outbox
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Memory is an in-process stand-in for a Kafka cluster, for running the
+// outbox and its producers without brokers. Like a real topic with a
+// hashing balancer, it keeps messages with the same key in one partition,
+// in write order. It is safe for concurrent use.
+type Memory struct {
+	Partitions int // per topic; zero means 1
+
+	mu     sync.Mutex
+	topics map[string][][]kafka.Message
+	fail   []failure
+}
+
+// failure is one injected write error; partition -1 fails the whole write.
+type failure struct {
+	err       error
+	partition int
+}
+
+func NewMemory() *Memory {
+	return &Memory{topics: make(map[string][][]kafka.Message)}
+}
+
+// Writer returns a Writer that appends to topic.
+func (m *Memory) Writer(topic string) Writer {
+	return memoryWriter{m: m, topic: topic}
+}
+
+// FailNext makes the next writes return errs, one per write, without
+// storing any message.
+func (m *Memory) FailNext(errs ...error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, err := range errs {
+		m.fail = append(m.fail, failure{err: err, partition: -1})
+	}
+}
+
+// FailPartition makes the next write reject the messages bound for
+// partition p with err, as when that partition's leader is unavailable,
+// and store the rest. The write returns kafka.WriteErrors, one entry per
+// message, like kafka-go.
+func (m *Memory) FailPartition(p int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fail = append(m.fail, failure{err: err, partition: p})
+}
+
+// Messages returns the messages of topic, partition by partition.
+func (m *Memory) Messages(topic string) []kafka.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []kafka.Message
+	for _, p := range m.topics[topic] {
+		out = append(out, p...)
+	}
+	return out
+}
+
+type memoryWriter struct {
+	m     *Memory
+	topic string
+}
+
+func (w memoryWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m := w.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := failure{partition: -1}
+	if len(m.fail) > 0 {
+		f, m.fail = m.fail[0], m.fail[1:]
+		if f.partition < 0 {
+			return f.err
+		}
+	}
+	parts := m.topics[w.topic]
+	if parts == nil {
+		parts = make([][]kafka.Message, max(m.Partitions, 1))
+	}
+	var errs kafka.WriteErrors
+	if f.err != nil {
+		errs = make(kafka.WriteErrors, len(msgs))
+	}
+	for i, msg := range msgs {
+		h := fnv.New32a()
+		h.Write(msg.Key)
+		p := int(h.Sum32() % uint32(len(parts)))
+		if p == f.partition {
+			errs[i] = f.err
+			continue
+		}
+		msg.Topic, msg.Partition, msg.Offset = w.topic, p, int64(len(parts[p]))
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		parts[p] = append(parts[p], msg)
+	}
+	m.topics[w.topic] = parts
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Package outbox delivers Kafka messages in the background, so request
+// handlers can emit events without waiting for, or failing with, the
+// brokers. Messages wait in a bounded in-process queue and are written in
+// order, in batches, retrying with backoff.
+//
+// The queue lives in memory: messages still queued when the process dies
+// are lost, and a message is redelivered if a write fails after the broker
+// stored it, so consumers should tolerate both gaps and duplicates.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_messages_total",
+		Help: "Outbox messages by outcome: delivered, failed or overflow.",
+	}, []string{"topic", "outcome"})
+	writeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_write_errors_total",
+		Help: "Failed attempts to write a batch to Kafka.",
+	}, []string{"topic"})
+)
+
+// NewWriter returns a kafka.Writer for topic that sends each key to one
+// partition, so a key's messages stay in order. It makes a single attempt
+// per write and leaves retries to the Outbox.
+func NewWriter(brokers []string, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		MaxAttempts:            1,
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}
+}
+
+// Writer writes messages to Kafka. *kafka.Writer and Memory's writers
+// implement it.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Options configures an Outbox. Zero values use the defaults.
+type Options struct {
+	// Topic labels metrics and logs; the Writer decides where messages go.
+	Topic string
+	// Capacity bounds the queue; messages enqueued beyond it are counted
+	// as overflow and dropped. Default 10000.
+	Capacity int
+	// BatchSize is the most messages written at once; default 100.
+	BatchSize int
+	// MaxAttempts is how often a batch is tried before its messages are
+	// counted as failed and dropped; default 10.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential wait between
+	// attempts; defaults 100ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// WriteTimeout bounds each attempt; default 10s.
+	WriteTimeout time.Duration
+}
+
+// Outbox queues messages and delivers them from one goroutine, so they
+// reach Kafka in the order they were enqueued. It is safe for concurrent
+// use.
+type Outbox struct {
+	w      Writer
+	opts   Options
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	queue   []kafka.Message
+	closed  bool
+	lastErr error
+
+	wake  chan struct{}
+	ctx   context.Context // cancelled to abandon delivery
+	abort context.CancelFunc
+	done  chan struct{}
+}
+
+// New starts delivering to w. Call Close to flush and stop.
+func New(w Writer, opts Options, logger *slog.Logger) *Outbox {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 10000
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 10
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	opts.MaxBackoff = max(opts.MaxBackoff, opts.MinBackoff)
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, abort := context.WithCancel(context.Background())
+	o := &Outbox{w: w, opts: opts, logger: logger, wake: make(chan struct{}, 1), ctx: ctx, abort: abort, done: make(chan struct{})}
+	go o.run()
+	return o
+}
+
+// Enqueue queues msgs for delivery without blocking. It reports false, and
+// counts the messages as overflow, when the queue is full or closed.
+func (o *Outbox) Enqueue(msgs ...kafka.Message) bool {
+	o.mu.Lock()
+	if o.closed || len(o.queue)+len(msgs) > o.opts.Capacity {
+		o.mu.Unlock()
+		messages.WithLabelValues(o.opts.Topic, "overflow").Add(float64(len(msgs)))
+		o.logger.Warn("outbox full or closed; dropping messages", "topic", o.opts.Topic, "messages", len(msgs))
+		return false
+	}
+	o.queue = append(o.queue, msgs...)
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Pending returns the number of queued messages, including the batch
+// being written.
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queue)
+}
+
+// Err returns the error of the latest write attempt, or nil once a write
+// succeeds. It suits a non-critical readiness check.
+func (o *Outbox) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.lastErr
+}
+
+// Close stops accepting messages and waits for the queued ones to be
+// delivered. When ctx ends first, delivery is abandoned and the messages
+// left are counted as failed.
+func (o *Outbox) Close(ctx context.Context) error {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case <-o.done:
+		return nil
+	case <-ctx.Done():
+		o.abort()
+		<-o.done
+		return fmt.Errorf("outbox: %w with messages undelivered", ctx.Err())
+	}
+}
+
+func (o *Outbox) run() {
+	defer close(o.done)
+	defer o.abort()
+	for {
+		o.mu.Lock()
+		batch := o.queue[:min(len(o.queue), o.opts.BatchSize)]
+		closed := o.closed
+		o.mu.Unlock()
+		if o.ctx.Err() != nil {
+			o.drop()
+			return
+		}
+		if len(batch) > 0 {
+			o.deliver(batch)
+			o.mu.Lock()
+			clear(batch)
+			o.queue = o.queue[len(batch):]
+			o.mu.Unlock()
+			continue
+		}
+		if closed {
+			return
+		}
+		select {
+		case <-o.wake:
+		case <-o.ctx.Done():
+		}
+	}
+}
+
+// deliver writes batch, retrying the messages that failed until they all
+// succeed or the attempts run out.
+func (o *Outbox) deliver(batch []kafka.Message) {
+	pending := batch
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(o.ctx, o.opts.WriteTimeout)
+		err := o.w.WriteMessages(ctx, pending...)
+		cancel()
+		failed := pending
+		var partial kafka.WriteErrors
+		if errors.As(err, &partial) && len(partial) == len(pending) {
+			// Only retry the messages the brokers rejected.
+			failed = failed[:0:0]
+			for i, e := range partial {
+				if e != nil {
+					failed = append(failed, pending[i])
+				}
+			}
+		}
+		if err == nil {
+			failed = nil
+		}
+		messages.WithLabelValues(o.opts.Topic, "delivered").Add(float64(len(pending) - len(failed)))
+		o.mu.Lock()
+		o.lastErr = err
+		o.mu.Unlock()
+		if len(failed) == 0 {
+			return
+		}
+		writeErrors.WithLabelValues(o.opts.Topic).Inc()
+		if attempt == o.opts.MaxAttempts || o.ctx.Err() != nil {
+			messages.WithLabelValues(o.opts.Topic, "failed").Add(float64(len(failed)))
+			o.logger.Error("outbox delivery failed; dropping messages", "topic", o.opts.Topic, "messages", len(failed), "attempts", attempt, "err", err)
+			return
+		}
+		o.logger.Warn("outbox write failed; retrying", "topic", o.opts.Topic, "messages", len(failed), "attempt", attempt, "err", err)
+		pending = failed
+		select {
+		case <-time.After(o.backoff(attempt)):
+		case <-o.ctx.Done():
+		}
+	}
+}
+
+// backoff doubles from MinBackoff after each attempt, up to MaxBackoff.
+func (o *Outbox) backoff(attempt int) time.Duration {
+	d := o.opts.MinBackoff << min(attempt-1, 30)
+	if d <= 0 || d > o.opts.MaxBackoff {
+		return o.opts.MaxBackoff
+	}
+	return d
+}
+
+// drop counts the queued messages as failed and discards them.
+func (o *Outbox) drop() {
+	o.mu.Lock()
+	n := len(o.queue)
+	o.queue = nil
+	o.mu.Unlock()
+	if n > 0 {
+		messages.WithLabelValues(o.opts.Topic, "failed").Add(float64(n))
+		o.logger.Error("outbox closed; dropping messages", "topic", o.opts.Topic, "messages", n)
+	}
+}
@@ -0,0 +1,55 @@
+// Package problem writes RFC 7807 problem details, so that the handlers
+// and the middleware in front of them answer errors in one shape.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of problem details bodies.
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors lists per-field violations for validation problems.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is one violated constraint on a request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Body is a *Problem or a pointer to a struct embedding one, for problems
+// that carry extension members.
+type Body interface {
+	problem() *Problem
+}
+
+func (p *Problem) problem() *Problem { return p }
+
+// Write answers r with b. An empty type defaults to "about:blank", an
+// empty title to the status text and an empty instance to the request
+// path. Headers set on w beforehand are kept.
+func Write(w http.ResponseWriter, r *http.Request, b Body) {
+	p := b.problem()
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(b)
+}
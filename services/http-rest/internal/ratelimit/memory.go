@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often Memory drops buckets that have refilled.
+const sweepEvery = time.Minute
+
+// Memory keeps buckets in process. State is lost on restart and not shared
+// between instances.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), now: time.Now, lastSweep: time.Now()}
+}
+
+func (m *Memory) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) > sweepEvery {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.refill(now, limit)
+
+	res := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return res, nil
+}
+
+// refill adds the tokens earned since the last call under limit, which
+// may differ from the previous call's if the policy changed.
+func (b *bucket) refill(now time.Time, limit Limit) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * limit.Rate
+	}
+	b.tokens = min(b.tokens, float64(limit.Burst))
+	b.last = now
+	b.limit = limit
+}
+
+// sweep drops buckets that would be full by now; a fresh bucket behaves
+// the same. m.mu must be held.
+func (m *Memory) sweep(now time.Time) {
+	for k, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(m.buckets, k)
+		}
+	}
+	m.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
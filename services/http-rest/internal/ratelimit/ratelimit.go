@@ -0,0 +1,327 @@
+// Package ratelimit is a token-bucket rate limiter for the router. Limits
+// are set per mux pattern and keyed by client IP, authenticated caller or
+// route; the buckets live in a Backend, in-process by default.
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"http-rest/internal/problem"
+)
+
+// KeyKind selects what a rule's buckets are keyed by.
+type KeyKind string
+
+const (
+	// KeyIP gives every client address its own bucket.
+	KeyIP KeyKind = "ip"
+	// KeyAPIKey gives every authenticated caller its own bucket. Requests
+	// whose credentials are missing or do not authenticate fall back to
+	// their client address, so made-up keys cannot mint fresh buckets.
+	KeyAPIKey KeyKind = "api_key"
+	// KeyRoute shares one bucket between all clients of the route.
+	KeyRoute KeyKind = "route"
+)
+
+// Limit is a token bucket: Burst tokens, refilled at Rate per second.
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Rule limits the requests matched by one mux pattern, such as
+// "GET /books/{id}" or "/trigger/allservices".
+type Rule struct {
+	Pattern string  `yaml:"pattern"`
+	Key     KeyKind `yaml:"key"` // defaults to ip
+	Limit   `yaml:",inline"`
+}
+
+// Policy is the complete limiter configuration. Routes without a rule use
+// Default; a nil Default leaves them unlimited.
+type Policy struct {
+	Default *Rule  `yaml:"default"`
+	Routes  []Rule `yaml:"routes"`
+	// ClientIPHeader names a comma-separated address list that trusted
+	// proxies append to (for example X-Forwarded-For). Empty uses the
+	// connection's remote address.
+	ClientIPHeader string `yaml:"client_ip_header"`
+	// TrustedHops is how many proxies in front of the service append to
+	// ClientIPHeader; the client is the address that many entries from the
+	// right. Entries further left are client-supplied and never used.
+	// Defaults to 1.
+	TrustedHops int `yaml:"trusted_hops"`
+}
+
+// Validate checks p and fills in defaults.
+func (p *Policy) Validate() error {
+	check := func(r *Rule, what string) error {
+		if r.Key == "" {
+			r.Key = KeyIP
+		}
+		switch r.Key {
+		case KeyIP, KeyAPIKey, KeyRoute:
+		default:
+			return fmt.Errorf("%s: unknown key %q", what, r.Key)
+		}
+		if r.Rate <= 0 || math.IsInf(r.Rate, 0) || math.IsNaN(r.Rate) {
+			return fmt.Errorf("%s: rate must be positive", what)
+		}
+		if r.Burst < 1 {
+			r.Burst = max(1, int(math.Ceil(r.Rate)))
+		}
+		return nil
+	}
+	if p.TrustedHops < 0 {
+		return errors.New("trusted_hops must not be negative")
+	}
+	if p.TrustedHops == 0 {
+		p.TrustedHops = 1
+	}
+	if p.Default != nil {
+		if err := check(p.Default, "default"); err != nil {
+			return err
+		}
+	}
+	seen := make(map[string]bool, len(p.Routes))
+	for i := range p.Routes {
+		r := &p.Routes[i]
+		if r.Pattern == "" {
+			return fmt.Errorf("route %d: pattern is required", i)
+		}
+		if seen[r.Pattern] {
+			return fmt.Errorf("route %q: duplicate pattern", r.Pattern)
+		}
+		seen[r.Pattern] = true
+		if err := check(r, fmt.Sprintf("route %q", r.Pattern)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rule returns the rule for a mux pattern, or nil if it is unlimited.
+func (p *Policy) rule(pattern string) *Rule {
+	for i := range p.Routes {
+		if p.Routes[i].Pattern == pattern {
+			return &p.Routes[i]
+		}
+	}
+	return p.Default
+}
+
+// Parse reads a YAML or JSON policy (JSON is valid YAML).
+func Parse(data []byte) (*Policy, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse rate limits: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("parse rate limits: %w", err)
+	}
+	return &p, nil
+}
+
+// LoadFile reads a policy from a YAML or JSON file.
+func LoadFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Load reads the policy from the file named by RATE_LIMITS_FILE or the
+// inline document in RATE_LIMITS. It returns nil when neither is set.
+func Load() (*Policy, error) {
+	if path := os.Getenv("RATE_LIMITS_FILE"); path != "" {
+		return LoadFile(path)
+	}
+	if doc := os.Getenv("RATE_LIMITS"); doc != "" {
+		return Parse([]byte(doc))
+	}
+	return nil, nil
+}
+
+// Result is the state of a bucket after one Take.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until a token is available; zero if Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Backend holds the buckets. Implementations shared between instances
+// (for example on Redis) let a fleet enforce one limit; they must be safe
+// for concurrent use and apply the limit passed on each call, so policy
+// changes take effect without clearing state.
+type Backend interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// rejected counts 429 responses by mux pattern.
+var rejected = expvar.NewMap("http_rate_limited")
+
+// Identify returns a stable name for the authenticated caller of r, or ""
+// if r has no valid credentials.
+type Identify func(r *http.Request) string
+
+// Limiter applies a Policy to requests. The policy can be replaced at any
+// time with SetPolicy.
+type Limiter struct {
+	backend  Backend
+	policy   atomic.Pointer[Policy]
+	logger   *slog.Logger
+	identify Identify
+}
+
+// New returns a limiter enforcing p on backend; a nil backend keeps the
+// buckets in memory. p must have been validated.
+func New(p *Policy, backend Backend, logger *slog.Logger) *Limiter {
+	if backend == nil {
+		backend = NewMemory()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	l := &Limiter{backend: backend, logger: logger}
+	l.SetPolicy(p)
+	return l
+}
+
+// SetPolicy atomically replaces the policy; requests already admitted are
+// unaffected. A nil policy disables limiting.
+func (l *Limiter) SetPolicy(p *Policy) {
+	if p == nil {
+		p = &Policy{}
+		_ = p.Validate()
+	}
+	l.policy.Store(p)
+}
+
+// Policy returns the policy in force.
+func (l *Limiter) Policy() *Policy { return l.policy.Load() }
+
+// IdentifyBy sets how api_key rules learn the caller. Without it every
+// request is keyed by client address. Call it before serving requests.
+func (l *Limiter) IdentifyBy(f Identify) { l.identify = f }
+
+// Wrap limits requests to next by the rule of the mux pattern they match.
+// Backend failures are logged and the request is let through.
+func (l *Limiter) Wrap(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := l.policy.Load()
+		_, pattern := mux.Handler(r)
+		rule := p.rule(pattern)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := pattern + "|" + string(rule.Key)
+		switch rule.Key {
+		case KeyIP:
+			key += ":" + clientIP(r, p.ClientIPHeader, p.TrustedHops)
+		case KeyAPIKey:
+			if id := l.caller(r); id != "" {
+				key += ":caller:" + id
+			} else {
+				key += ":ip:" + clientIP(r, p.ClientIPHeader, p.TrustedHops)
+			}
+		}
+		res, err := l.backend.Take(r.Context(), key, rule.Limit)
+		if err != nil {
+			l.logger.Warn("rate limit backend", "err", err, "route", pattern)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(rule.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Burst, ceilSeconds(time.Duration(float64(rule.Burst)/rule.Rate*float64(time.Second)))))
+		if res.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rejected.Add(pattern, 1)
+		retry := ceilSeconds(res.RetryAfter)
+		h.Set("Retry-After", strconv.Itoa(retry))
+		problem.Write(w, r, &problem.Problem{
+			Type:   "/problems/rate-limited",
+			Title:  "Too many requests",
+			Status: http.StatusTooManyRequests,
+			Detail: fmt.Sprintf("rate limit of %g requests per second exceeded; retry in %d s", rule.Rate, retry),
+		})
+	})
+}
+
+func (l *Limiter) caller(r *http.Request) string {
+	if l.identify == nil {
+		return ""
+	}
+	return l.identify(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientIP is the address hops entries from the right of header, if set
+// and that long, else the connection's remote host. Repeated header lines
+// are read as one list.
+func clientIP(r *http.Request, header string, hops int) string {
+	if header != "" {
+		var addrs []string
+		for _, v := range r.Header.Values(header) {
+			addrs = append(addrs, strings.Split(v, ",")...)
+		}
+		if hops > 0 && hops <= len(addrs) {
+			if ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-hops])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ErrNoPolicy is returned by Reload when no policy source is configured.
+var ErrNoPolicy = errors.New("ratelimit: RATE_LIMITS_FILE and RATE_LIMITS are unset")
+
+// Reload re-reads the policy with Load and installs it.
+func (l *Limiter) Reload() error {
+	p, err := Load()
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return ErrNoPolicy
+	}
+	l.SetPolicy(p)
+	return nil
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyRuleIgnoresUnauthenticatedKeys(t *testing.T) {
+	p, err := Parse([]byte(`
+routes:
+  - pattern: POST /books:import
+    key: api_key
+    rate: 0.001
+    burst: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemory()
+	l := New(p, mem, slog.New(slog.DiscardHandler))
+	l.IdentifyBy(func(r *http.Request) string {
+		if r.Header.Get("X-API-Key") == "valid" {
+			return "api_key:admin"
+		}
+		return ""
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /books:import", func(http.ResponseWriter, *http.Request) {})
+	h := l.Wrap(mux, mux)
+
+	send := func(key, addr string) int {
+		req := httptest.NewRequest("POST", "/books:import", nil)
+		req.RemoteAddr = addr + ":1234"
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A made-up key per request shares the client address's bucket.
+	for i := range 5 {
+		want := http.StatusOK
+		if i >= 2 {
+			want = http.StatusTooManyRequests
+		}
+		if got := send(fmt.Sprintf("guess-%d", i), "192.0.2.1"); got != want {
+			t.Errorf("made-up key %d: status %d, want %d", i, got, want)
+		}
+	}
+	if got := len(mem.buckets); got != 1 {
+		t.Errorf("made-up keys created %d buckets, want 1", got)
+	}
+
+	// An authenticated caller has its own bucket wherever it connects from.
+	for i, addr := range []string{"192.0.2.1", "198.51.100.7", "203.0.113.9"} {
+		want := http.StatusOK
+		if i >= 2 {
+			want = http.StatusTooManyRequests
+		}
+		if got := send("valid", addr); got != want {
+			t.Errorf("valid key from %s: status %d, want %d", addr, got, want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		hops   int
+		xff    []string
+		want   string
+	}{
+		{"no header configured", "", 1, []string{"198.51.100.1"}, "192.0.2.1"},
+		{"header absent", "X-Forwarded-For", 1, nil, "192.0.2.1"},
+		{"one proxy", "X-Forwarded-For", 1, []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entries ignored", "X-Forwarded-For", 1, []string{"203.0.113.66, 198.51.100.1"}, "198.51.100.1"},
+		{"two proxies", "X-Forwarded-For", 2, []string{"203.0.113.66, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"repeated header lines", "X-Forwarded-For", 2, []string{"203.0.113.66, 198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"shorter than hops", "X-Forwarded-For", 3, []string{"198.51.100.1, 10.0.0.2"}, "192.0.2.1"},
+		{"not an address", "X-Forwarded-For", 1, []string{"198.51.100.1, unknown"}, "192.0.2.1"},
+		{"ipv6", "X-Forwarded-For", 1, []string{"2001:DB8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(req, tt.header, tt.hops); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyTrustedHops(t *testing.T) {
+	p, err := Parse([]byte("client_ip_header: X-Forwarded-For\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TrustedHops != 1 {
+		t.Errorf("TrustedHops defaulted to %d, want 1", p.TrustedHops)
+	}
+	if _, err := Parse([]byte("trusted_hops: -1\n")); err == nil {
+		t.Error("negative trusted_hops accepted")
+	}
+	if _, err := Parse([]byte("api_key_header: X-API-Key\n")); err == nil {
+		t.Error("api_key_header accepted")
+	}
+}
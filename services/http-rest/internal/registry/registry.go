@@ -0,0 +1,196 @@
+// Package registry holds the named downstream services the /trigger/*
+// routes call. Targets come from a YAML/JSON document or the environment,
+// so adding one needs no code change.
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Target is one downstream trigger endpoint.
+type Target struct {
+	Name string `yaml:"name"`
+	// Route is the last segment of /trigger/{route}; defaults to Name.
+	Route   string `yaml:"route"`
+	BaseURL string `yaml:"base_url"`
+	Path    string `yaml:"path"`
+	Method  string `yaml:"method"` // defaults to GET
+	// Timeout bounds a single call; zero uses the caller's default.
+	Timeout time.Duration `yaml:"timeout"`
+	// ExpectedStatus lists the codes counted as success; empty means any 2xx.
+	ExpectedStatus []int `yaml:"expected_status"`
+	// Fanout includes the target in /trigger/allservices; defaults to true.
+	Fanout *bool `yaml:"fanout"`
+}
+
+// URL is the full address called for t.
+func (t Target) URL() string {
+	return strings.TrimRight(t.BaseURL, "/") + t.Path
+}
+
+// Expects reports whether code counts as a successful call.
+func (t Target) Expects(code int) bool {
+	if len(t.ExpectedStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, c := range t.ExpectedStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// InFanout reports whether t takes part in /trigger/allservices.
+func (t Target) InFanout() bool { return t.Fanout == nil || *t.Fanout }
+
+// Registry is an immutable, validated set of targets.
+type Registry struct {
+	targets []Target
+	byRoute map[string]Target
+}
+
+var routeRE = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// reserved routes are served by the trigger handler itself.
+var reserved = map[string]bool{"allservices": true, "targets": true}
+
+// New validates targets and fills in defaults.
+func New(targets []Target) (*Registry, error) {
+	r := &Registry{byRoute: make(map[string]Target, len(targets))}
+	names := make(map[string]bool, len(targets))
+	for i, t := range targets {
+		if t.Name == "" {
+			return nil, fmt.Errorf("target %d: name is required", i)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("target %q: duplicate name", t.Name)
+		}
+		names[t.Name] = true
+		if t.Route == "" {
+			t.Route = t.Name
+		}
+		if !routeRE.MatchString(t.Route) || reserved[t.Route] {
+			return nil, fmt.Errorf("target %q: invalid route %q", t.Name, t.Route)
+		}
+		if _, dup := r.byRoute[t.Route]; dup {
+			return nil, fmt.Errorf("target %q: route %q already used", t.Name, t.Route)
+		}
+		u, err := url.Parse(t.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("target %q: base_url must be an absolute http(s) URL", t.Name)
+		}
+		if t.Path != "" && !strings.HasPrefix(t.Path, "/") {
+			t.Path = "/" + t.Path
+		}
+		t.Method = strings.ToUpper(t.Method)
+		if t.Method == "" {
+			t.Method = http.MethodGet
+		}
+		if t.Timeout < 0 {
+			return nil, fmt.Errorf("target %q: negative timeout", t.Name)
+		}
+		for _, c := range t.ExpectedStatus {
+			if c < 100 || c > 599 {
+				return nil, fmt.Errorf("target %q: invalid expected status %d", t.Name, c)
+			}
+		}
+		r.targets = append(r.targets, t)
+		r.byRoute[t.Route] = t
+	}
+	return r, nil
+}
+
+// Targets returns the targets in declaration order.
+func (r *Registry) Targets() []Target {
+	return append([]Target(nil), r.targets...)
+}
+
+// Lookup finds the target served at /trigger/{route}.
+func (r *Registry) Lookup(route string) (Target, bool) {
+	t, ok := r.byRoute[route]
+	return t, ok
+}
+
+// document is the on-disk layout: a top-level "targets" list.
+type document struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// Parse reads a YAML or JSON document (JSON is valid YAML).
+func Parse(data []byte) (*Registry, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var doc document
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse targets: %w", err)
+	}
+	if len(doc.Targets) == 0 {
+		return nil, errors.New("parse targets: no targets defined")
+	}
+	return New(doc.Targets)
+}
+
+// LoadFile reads targets from a YAML or JSON file.
+func LoadFile(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Load builds the registry from, in order of preference, the file named by
+// TRIGGER_TARGETS_FILE, an inline document in TRIGGER_TARGETS, or the
+// built-in demo targets.
+func Load() (*Registry, error) {
+	if path := os.Getenv("TRIGGER_TARGETS_FILE"); path != "" {
+		return LoadFile(path)
+	}
+	if doc := os.Getenv("TRIGGER_TARGETS"); doc != "" {
+		return Parse([]byte(doc))
+	}
+	return New(Defaults())
+}
+
+// Defaults are the local demo services. Base URLs honour DB_SERVICE_URL,
+// KAFKA_SERVICE_URL and GRPC_CLIENT_URL, falling back to localhost on the
+// PORT_DB, PORT_KAFKA and PORT_GRPC_CLIENT ports used by multi-set builds.
+// EXTERNAL_API_URL adds an optional public "external-api" target.
+func Defaults() []Target {
+	db := env("DB_SERVICE_URL", "http://localhost:"+env("PORT_DB", "8081"))
+	kafka := env("KAFKA_SERVICE_URL", "http://localhost:"+env("PORT_KAFKA", "8082"))
+	grpc := env("GRPC_CLIENT_URL", "http://localhost:"+env("PORT_GRPC_CLIENT", "8083"))
+	targets := []Target{
+		{Name: "database-sql", Route: "db", BaseURL: db, Path: "/trigger-crud", Timeout: envDuration("DB_SERVICE_TIMEOUT")},
+		{Name: "kafka-go", Route: "kafka", BaseURL: kafka, Path: "/trigger-produce", Timeout: envDuration("KAFKA_SERVICE_TIMEOUT")},
+		{Name: "grpc-unary", Route: "grpcunary", BaseURL: grpc, Path: "/trigger-simple", Timeout: envDuration("GRPC_CLIENT_TIMEOUT")},
+		{Name: "grpc-stream", Route: "grpcstream", BaseURL: grpc, Path: "/trigger-stream", Timeout: envDuration("GRPC_CLIENT_TIMEOUT")},
+	}
+	if ext := os.Getenv("EXTERNAL_API_URL"); ext != "" {
+		targets = append(targets, Target{Name: "external-api", BaseURL: ext})
+	}
+	return targets
+}
+
+func env(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
+
+func envDuration(k string) time.Duration {
+	d, _ := time.ParseDuration(os.Getenv(k))
+	return d
+}
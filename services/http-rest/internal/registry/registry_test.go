@@ -0,0 +1,166 @@
+package registry_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"http-rest/internal/registry"
+)
+
+const yamlDoc = `
+targets:
+  - name: database-sql
+    route: db
+    base_url: http://db:8081/
+    path: trigger-crud
+    method: post
+    timeout: 10s
+    expected_status: [200, 201]
+  - name: external-api
+    base_url: https://example.com
+    fanout: false
+`
+
+const jsonDoc = `{"targets": [
+  {"name": "database-sql", "route": "db", "base_url": "http://db:8081/", "path": "trigger-crud",
+   "method": "post", "timeout": "10s", "expected_status": [200, 201]},
+  {"name": "external-api", "base_url": "https://example.com", "fanout": false}
+]}`
+
+// routes lists name@route per target, in declaration order.
+func routes(reg *registry.Registry) []string {
+	var out []string
+	for _, t := range reg.Targets() {
+		out = append(out, t.Name+"@"+t.Route)
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	for name, doc := range map[string]string{"yaml": yamlDoc, "json": jsonDoc} {
+		t.Run(name, func(t *testing.T) {
+			reg, err := registry.Parse([]byte(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := routes(reg), []string{"database-sql@db", "external-api@external-api"}; !slices.Equal(got, want) {
+				t.Fatalf("targets %v, want %v", got, want)
+			}
+			db, ok := reg.Lookup("db")
+			if !ok {
+				t.Fatal("no target at /trigger/db")
+			}
+			if db.URL() != "http://db:8081/trigger-crud" || db.Method != "POST" || db.Timeout != 10*time.Second || !db.InFanout() {
+				t.Errorf("db: %s %s, timeout %v, fanout %v", db.Method, db.URL(), db.Timeout, db.InFanout())
+			}
+			if !db.Expects(201) || db.Expects(204) {
+				t.Errorf("db expects %v", db.ExpectedStatus)
+			}
+			ext, _ := reg.Lookup("external-api")
+			if ext.Method != "GET" || ext.InFanout() || !ext.Expects(204) || ext.Expects(301) {
+				t.Errorf("external-api: %+v", ext)
+			}
+			if _, ok := reg.Lookup("database-sql"); ok {
+				t.Error("a target with a route is also served under its name")
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		doc  string
+		want string
+	}{
+		{`targets: []`, "no targets defined"},
+		{`{"targets": [{"name": "a", "base_url": "http://a", "retries": 3}]}`, "field retries not found"},
+		{`targets: [{base_url: "http://a"}]`, "target 0: name is required"},
+		{`targets: [{name: a, base_url: "http://a"}, {name: a, route: b, base_url: "http://b"}]`, `"a": duplicate name`},
+		{`targets: [{name: a, base_url: "http://a"}, {name: b, route: a, base_url: "http://b"}]`, `route "a" already used`},
+		{`targets: [{name: a, route: allservices, base_url: "http://a"}]`, `invalid route "allservices"`},
+		{`targets: [{name: A, base_url: "http://a"}]`, `invalid route "A"`},
+		{`targets: [{name: a, base_url: "a:8080"}]`, "base_url must be an absolute http(s) URL"},
+		{`targets: [{name: a, base_url: "http://a", timeout: -1s}]`, "negative timeout"},
+		{`targets: [{name: a, base_url: "http://a", expected_status: [600]}]`, "invalid expected status 600"},
+	} {
+		if _, err := registry.Parse([]byte(tt.doc)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.doc, err, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, k := range []string{"TRIGGER_TARGETS_FILE", "TRIGGER_TARGETS", "DB_SERVICE_URL", "KAFKA_SERVICE_URL", "GRPC_CLIENT_URL",
+		"PORT_DB", "PORT_KAFKA", "PORT_GRPC_CLIENT", "DB_SERVICE_TIMEOUT", "KAFKA_SERVICE_TIMEOUT", "GRPC_CLIENT_TIMEOUT", "EXTERNAL_API_URL"} {
+		t.Setenv(k, "")
+	}
+	load := func(t *testing.T) *registry.Registry {
+		t.Helper()
+		reg, err := registry.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reg
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv("PORT_KAFKA", "9092")
+		t.Setenv("GRPC_CLIENT_URL", "http://grpc:50051")
+		t.Setenv("GRPC_CLIENT_TIMEOUT", "3s")
+		t.Setenv("EXTERNAL_API_URL", "https://example.com")
+		reg := load(t)
+		want := []string{"database-sql@db", "kafka-go@kafka", "grpc-unary@grpcunary", "grpc-stream@grpcstream", "external-api@external-api"}
+		if got := routes(reg); !slices.Equal(got, want) {
+			t.Fatalf("targets %v, want %v", got, want)
+		}
+		for route, url := range map[string]string{
+			"db":         "http://localhost:8081/trigger-crud",
+			"kafka":      "http://localhost:9092/trigger-produce",
+			"grpcstream": "http://grpc:50051/trigger-stream",
+		} {
+			if target, _ := reg.Lookup(route); target.URL() != url {
+				t.Errorf("%s: URL %s, want %s", route, target.URL(), url)
+			}
+		}
+		if target, _ := reg.Lookup("grpcunary"); target.Timeout != 3*time.Second {
+			t.Errorf("grpcunary: timeout %v, want 3s", target.Timeout)
+		}
+	})
+
+	t.Run("inline", func(t *testing.T) {
+		t.Setenv("TRIGGER_TARGETS", jsonDoc)
+		if got := routes(load(t)); len(got) != 2 {
+			t.Errorf("targets %v, want the two inline ones", got)
+		}
+	})
+
+	t.Run("file over inline", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "targets.yaml")
+		if err := os.WriteFile(path, []byte("targets: [{name: only, base_url: \"http://only\"}]"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TRIGGER_TARGETS_FILE", path)
+		t.Setenv("TRIGGER_TARGETS", jsonDoc)
+		if got := routes(load(t)); !slices.Equal(got, []string{"only@only"}) {
+			t.Errorf("targets %v, want the file's", got)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("TRIGGER_TARGETS_FILE", filepath.Join(t.TempDir(), "nope.yaml"))
+		if _, err := registry.Load(); !os.IsNotExist(err) {
+			t.Errorf("error %v, want not exist", err)
+		}
+	})
+
+	t.Run("example", func(t *testing.T) {
+		t.Setenv("TRIGGER_TARGETS_FILE", "../../targets.example.yaml")
+		if got := routes(load(t)); len(got) == 0 {
+			t.Error("the example file defines no targets")
+		}
+	})
+}
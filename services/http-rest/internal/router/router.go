@@ -1,27 +1,194 @@
 package router
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"http-rest/internal/auth"
+	"http-rest/internal/idempotency"
+	"http-rest/internal/metrics"
+	"http-rest/internal/problem"
+	"http-rest/internal/ratelimit"
 )
 
-func New(mux *http.ServeMux, h http.Handler) http.Handler {
+// Config controls the middleware stack built by New.
+type Config struct {
+	Logger *slog.Logger
+	// SuccessSampleRate is the fraction (0..1) of non-error requests that
+	// are access-logged. 4xx/5xx responses are always logged.
+	SuccessSampleRate float64
+	// RateLimiter, if set, rejects requests over their route's limit with
+	// 429 before they reach the handler. Rejections are still logged.
+	RateLimiter *ratelimit.Limiter
+	// Auth, if set, authenticates requests and rejects those without the
+	// scope Scopes maps their mux pattern to. It runs after rate limiting,
+	// so on limited routes failed attempts spend tokens: credentials that
+	// do not authenticate are limited by client address, not by the key.
+	// The limiter and Auth share one verification per request.
+	Auth   *auth.Authenticator
+	Scopes map[string]string
+	// Idempotency, if set, handles Idempotency-Key on IdempotentRoutes.
+	// It runs after authentication, as keys are scoped to the caller.
+	Idempotency      *idempotency.Keeper
+	IdempotentRoutes []string
+	// Metrics, if set, records every request, sampled out of the access
+	// log or not.
+	Metrics *metrics.HTTP
+}
+
+// panicsRecovered counts handler panics turned into 500 responses.
+var panicsRecovered = expvar.NewInt("http_panics_recovered")
+
+func New(mux *http.ServeMux, h http.Handler, cfg Config) http.Handler {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
 	// Attach basic middleware stack
-	return loggingMiddleware(recoverMiddleware(h))
+	if cfg.Idempotency != nil {
+		h = cfg.Idempotency.Wrap(mux, cfg.IdempotentRoutes, h)
+	}
+	h = recoverMiddleware(cfg.Logger, h)
+	if cfg.Auth != nil {
+		h = cfg.Auth.Wrap(mux, cfg.Scopes, h)
+	}
+	if cfg.RateLimiter != nil {
+		h = cfg.RateLimiter.Wrap(mux, h)
+	}
+	h = loggingMiddleware(mux, cfg, h)
+	return tracingMiddleware(mux, h)
 }
 
-func recoverMiddleware(next http.Handler) http.Handler {
+func recoverMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			if err := recover(); err != nil {
-				http.Error(w, "internal", http.StatusInternalServerError)
+			err := recover()
+			if err == nil {
+				return
+			}
+			if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+				panic(err)
 			}
+			panicsRecovered.Add(1)
+			logger.Error("panic recovered",
+				"request_id", RequestID(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", err,
+				"stack", string(debug.Stack()),
+			)
+			if rec, ok := w.(*statusRecorder); ok && rec.wroteHeader {
+				return // too late to change the response
+			}
+			// Headers the handler set for its own response do not apply.
+			w.Header().Del("Content-Length")
+			problem.Write(w, r, &problem.Problem{Status: http.StatusInternalServerError})
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
-func loggingMiddleware(next http.Handler) http.Handler {
+
+func loggingMiddleware(mux *http.ServeMux, cfg Config, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// tiny request log
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx, principal := auth.Track(withRequestID(r.Context(), id))
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		_, route := mux.Handler(r)
+		latency := time.Since(start)
+		if cfg.Metrics != nil {
+			cfg.Metrics.Observe(route, r.Method, rec.status, latency)
+		}
+		if rec.status < 400 && rand.Float64() >= cfg.SuccessSampleRate {
+			return
+		}
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("latency", latency),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if p := principal(); p != nil {
+			attrs = append(attrs, slog.String("principal", p.Subject), slog.String("auth_method", p.Method))
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			attrs = append(attrs,
+				slog.String("trace_id", sc.TraceID().String()),
+				slog.String("span_id", sc.SpanID().String()),
+			)
+		}
+		cfg.Logger.LogAttrs(r.Context(), level, "http request", attrs...)
 	})
 }
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush and deadlines underneath.
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = crand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts client-supplied IDs that are short, printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
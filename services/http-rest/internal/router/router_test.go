@@ -0,0 +1,52 @@
+package router_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"http-rest/internal/problem"
+	"http-rest/internal/router"
+)
+
+func TestRecover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		panic("boom")
+	})
+	mux.HandleFunc("GET /late", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("boom")
+	})
+	mux.HandleFunc("GET /abort", func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) })
+	h := router.New(mux, mux, router.Config{Logger: slog.New(slog.DiscardHandler)})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	var p problem.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("body %q: %v", rec.Body, err)
+	}
+	if rec.Code != 500 || rec.Header().Get("Content-Type") != problem.ContentType || p.Status != 500 || p.Instance != "/panic" {
+		t.Errorf("panic: %d %s %+v, want a 500 problem", rec.Code, rec.Header().Get("Content-Type"), p)
+	}
+
+	// Once the status is out it is left alone.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/late", nil))
+	if rec.Code != http.StatusAccepted || rec.Body.Len() != 0 {
+		t.Errorf("late panic: %d %q, want the handler's 202 untouched", rec.Code, rec.Body)
+	}
+
+	// http.ErrAbortHandler is passed on for net/http to cut the connection.
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	t.Error("ErrAbortHandler was swallowed")
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"http-rest/internal/telemetry"
+)
+
+// tracingMiddleware continues the caller's trace (or starts one) with a
+// server span named after the matched route.
+func tracingMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		_, route := mux.Handler(r)
+
+		name := r.Method
+		attrs := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.URLScheme(scheme(r)),
+			semconv.NetworkProtocolVersion(strings.TrimPrefix(r.Proto, "HTTP/")),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		}
+		if route != "" {
+			// Patterns may carry a method prefix ("GET /books/{id}"); the
+			// route attribute is only the path template.
+			if _, path, ok := strings.Cut(route, " "); ok {
+				route = path
+			}
+			name += " " + route
+			attrs = append(attrs, semconv.HTTPRoute(route))
+		}
+		if host, port, err := net.SplitHostPort(r.Host); err == nil {
+			attrs = append(attrs, semconv.ServerAddress(host))
+			if p, err := strconv.Atoi(port); err == nil {
+				attrs = append(attrs, semconv.ServerPort(p))
+			}
+		} else if r.Host != "" {
+			attrs = append(attrs, semconv.ServerAddress(r.Host))
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			attrs = append(attrs, semconv.ClientAddress(host))
+		}
+
+		ctx, span := otel.Tracer(telemetry.InstrumentationName).Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+			span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(rec.status)))
+		}
+	})
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,159 @@
+package router_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"http-rest/internal/handlers"
+	"http-rest/internal/registry"
+	"http-rest/internal/router"
+	"http-rest/internal/telemetry"
+)
+
+// record installs a tracer provider exporting to memory and returns a
+// func that flushes it and lists the spans ended so far.
+func record(t *testing.T) func() tracetest.SpanStubs {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	shutdown, err := telemetry.Setup(context.Background(), telemetry.Config{ServiceName: "test", SpanExporter: exp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+	return func() tracetest.SpanStubs {
+		t.Helper()
+		if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return exp.GetSpans()
+	}
+}
+
+func attrs(s tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	out := make(map[attribute.Key]attribute.Value, len(s.Attributes))
+	for _, kv := range s.Attributes {
+		out[kv.Key] = kv.Value
+	}
+	return out
+}
+
+func TestServerSpan(t *testing.T) {
+	spans := record(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /books/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("id") {
+		case "404":
+			http.NotFound(w, r)
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	h := router.New(mux, mux, router.Config{Logger: slog.New(slog.DiscardHandler)})
+
+	tests := []struct {
+		path   string
+		name   string
+		route  string // "" for no http.route attribute
+		status int
+		code   codes.Code
+	}{
+		{"/books/1", "GET /books/{id}", "/books/{id}", 200, codes.Unset},
+		{"/books/404", "GET /books/{id}", "/books/{id}", 404, codes.Unset},
+		{"/books/500", "GET /books/{id}", "/books/{id}", 500, codes.Error},
+		{"/nowhere", "GET", "", 404, codes.Unset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			before := len(spans())
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+			got := spans()[before:]
+			if len(got) != 1 {
+				t.Fatalf("%d spans, want 1", len(got))
+			}
+			s := got[0]
+			if s.Name != tt.name || s.SpanKind != trace.SpanKindServer {
+				t.Errorf("span %q of kind %v, want %q, server", s.Name, s.SpanKind, tt.name)
+			}
+			a := attrs(s)
+			if route, ok := a[semconv.HTTPRouteKey]; ok != (tt.route != "") || route.AsString() != tt.route {
+				t.Errorf("%s = %q, want %q", semconv.HTTPRouteKey, route.AsString(), tt.route)
+			}
+			if v := a[semconv.HTTPResponseStatusCodeKey]; v.AsInt64() != int64(tt.status) {
+				t.Errorf("%s = %v, want %d", semconv.HTTPResponseStatusCodeKey, v.Emit(), tt.status)
+			}
+			if v := a[semconv.URLPathKey]; v.AsString() != tt.path {
+				t.Errorf("%s = %q, want %q", semconv.URLPathKey, v.AsString(), tt.path)
+			}
+			if s.Status.Code != tt.code {
+				t.Errorf("span status %v, want %v", s.Status.Code, tt.code)
+			}
+		})
+	}
+}
+
+// TestTriggerPropagation follows one trace from an incoming traceparent
+// through the server span and the trigger's client span to the target.
+func TestTriggerPropagation(t *testing.T) {
+	spans := record(t)
+	var sent http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Clone()
+	}))
+	defer target.Close()
+	reg, err := registry.New([]registry.Target{{Name: "ok", BaseURL: target.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	handlers.NewTriggers(reg, 0).Register(mux)
+	h := router.New(mux, mux, router.Config{Logger: slog.New(slog.DiscardHandler)})
+
+	const incoming = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	req := httptest.NewRequest("GET", "/trigger/ok", nil)
+	req.Header.Set("traceparent", incoming)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var server, client tracetest.SpanStub
+	for _, s := range spans() {
+		switch s.SpanKind {
+		case trace.SpanKindServer:
+			server = s
+		case trace.SpanKindClient:
+			client = s
+		}
+	}
+	if server.Name != "GET /trigger/{name}" {
+		t.Fatalf("server span %q, want GET /trigger/{name}", server.Name)
+	}
+	if got := server.SpanContext.TraceID().String(); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("server span in trace %s, want the incoming one", got)
+	}
+	if got := server.Parent.SpanID().String(); got != "b7ad6b7169203331" || !server.Parent.IsRemote() {
+		t.Errorf("server span parent %s, want the remote caller", got)
+	}
+	if client.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("the trigger's client span is not a child of the server span")
+	}
+
+	sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(sent)))
+	if sc.TraceID() != server.SpanContext.TraceID() || sc.SpanID() != client.SpanContext.SpanID() {
+		t.Errorf("target got traceparent %q, want trace %s, span %s",
+			sent.Get("traceparent"), server.SpanContext.TraceID(), client.SpanContext.SpanID())
+	}
+}
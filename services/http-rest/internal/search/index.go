@@ -0,0 +1,528 @@
+// Package search keeps an in-process inverted index of the catalog for
+// ranked, accent- and case-insensitive title and author search with
+// prefix matching and author facets.
+package search
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"http-rest/internal/types"
+)
+
+// Fields a term can occur in, as a bit mask.
+const (
+	fieldTitle uint8 = 1 << iota
+	fieldAuthor
+)
+
+// Relative weight of a match in each field, and of a prefix match against
+// an exact one.
+const (
+	titleWeight  = 2.0
+	authorWeight = 1.0
+	prefixWeight = 0.5
+)
+
+// Index maps terms to the books containing them. It is safe for concurrent
+// use.
+//
+// Postings are kept sorted by book ID, so queries intersect them by
+// galloping through the longer lists instead of visiting every book.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[int64]*doc
+	ids      []int64              // sorted keys of docs, for empty queries
+	postings map[string][]posting // term -> books containing it, by ID
+	terms    []string             // sorted keys of postings, for prefixes
+	byAuthor map[string][]int64   // exact author -> sorted book IDs
+}
+
+type doc struct {
+	book  types.Book
+	terms map[string]uint8
+}
+
+// posting is one book containing a term, and the fields it occurs in.
+type posting struct {
+	id     int64
+	fields uint8
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[int64]*doc),
+		postings: make(map[string][]posting),
+		byAuthor: make(map[string][]int64),
+	}
+}
+
+// Len returns the number of indexed books.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// Put indexes b, replacing any earlier version of it. New books usually
+// have the highest ID, so their postings are appended.
+func (x *Index) Put(b types.Book) {
+	terms := make(map[string]uint8)
+	for _, t := range Tokens(b.Title) {
+		terms[t] |= fieldTitle
+	}
+	for _, a := range authors(b) {
+		for _, t := range Tokens(a) {
+			terms[t] |= fieldAuthor
+		}
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(b.ID)
+	x.docs[b.ID] = &doc{book: b, terms: terms}
+	x.ids = insertID(x.ids, b.ID)
+	for t, f := range terms {
+		p, ok := x.postings[t]
+		if !ok {
+			i, _ := slices.BinarySearch(x.terms, t)
+			x.terms = slices.Insert(x.terms, i, t)
+		}
+		i, _ := slices.BinarySearchFunc(p, b.ID, postingCmp)
+		x.postings[t] = slices.Insert(p, i, posting{id: b.ID, fields: f})
+	}
+	for _, a := range authors(b) {
+		x.byAuthor[a] = insertID(x.byAuthor[a], b.ID)
+	}
+}
+
+// Remove drops the book with id, if indexed.
+func (x *Index) Remove(id int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *Index) removeLocked(id int64) {
+	d, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	delete(x.docs, id)
+	x.ids = deleteID(x.ids, id)
+	for t := range d.terms {
+		p := x.postings[t]
+		if i, found := slices.BinarySearchFunc(p, id, postingCmp); found {
+			p = slices.Delete(p, i, i+1)
+		}
+		if len(p) > 0 {
+			x.postings[t] = p
+			continue
+		}
+		delete(x.postings, t)
+		if i, found := slices.BinarySearch(x.terms, t); found {
+			x.terms = slices.Delete(x.terms, i, i+1)
+		}
+	}
+	for _, a := range authors(d.book) {
+		if ids := deleteID(x.byAuthor[a], id); len(ids) > 0 {
+			x.byAuthor[a] = ids
+		} else {
+			delete(x.byAuthor, a)
+		}
+	}
+}
+
+func postingCmp(p posting, id int64) int { return cmp.Compare(p.id, id) }
+
+func insertID(ids []int64, id int64) []int64 {
+	i, found := slices.BinarySearch(ids, id)
+	if found {
+		return ids
+	}
+	return slices.Insert(ids, i, id)
+}
+
+func deleteID(ids []int64, id int64) []int64 {
+	if i, found := slices.BinarySearch(ids, id); found {
+		return slices.Delete(ids, i, i+1)
+	}
+	return ids
+}
+
+// Query is one search. Every term of Text must match a title or author
+// word, either exactly or as a prefix; an empty Text matches every book.
+type Query struct {
+	Text string
+	// Author keeps only books with exactly this author among their authors.
+	Author string
+	// Facets names the fields to count matches by; only "author" is known.
+	Facets []string
+	// FacetLimit caps the values returned per facet; zero means no cap.
+	FacetLimit int
+	Offset     int
+	Limit      int // zero means no limit
+}
+
+// Hit is a matching book and its relevance; higher scores rank first.
+type Hit struct {
+	Book  types.Book `json:"book"`
+	Score float64    `json:"score"`
+}
+
+// FacetCount is the number of matches with one facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Result is one page of hits, the total number of matches and the
+// requested facets, computed over all matches.
+type Result struct {
+	Hits   []Hit
+	Total  int
+	Facets map[string][]FacetCount
+}
+
+// FacetAuthor is the only facet Search computes.
+const FacetAuthor = "author"
+
+// token is a query token and the indexed words it matches, exactly or as
+// a prefix. size counts a book once per matching word.
+type token struct {
+	text  string
+	words []word
+	size  int
+}
+
+// word is an indexed word a token matches, with its postings and the
+// weight of a match on it (see termScore).
+type word struct {
+	postings []posting
+	weight   float64
+}
+
+// score is the score of a match on w in fields f.
+func (w word) score(f uint8) float64 { return fieldScore(w.weight, f) }
+
+// candidate is a book matching the tokens seen so far, and its score.
+type candidate struct {
+	id    int64
+	score float64
+}
+
+// Search ranks the books matching q. Terms score by rarity (idf), weighted
+// by field and discounted for prefix matches; ties go to the lower ID.
+//
+// The cost follows the smallest candidate set rather than the catalog:
+// the rarest token's books, or the author's if fewer, are intersected
+// with the other tokens' postings, and only the page of hits asked for is
+// kept and sorted. Total and facets still visit every match.
+func (x *Index) Search(q Query) Result {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	wantFacet := slices.Contains(q.Facets, FacetAuthor)
+	text := Tokens(q.Text)
+	if len(text) == 0 && q.Author == "" {
+		return x.all(q, wantFacet)
+	}
+	n := float64(len(x.docs))
+	tokens := make([]token, len(text))
+	for i, t := range text {
+		tokens[i] = x.expand(t, n)
+	}
+	slices.SortFunc(tokens, func(a, b token) int { return cmp.Compare(a.size, b.size) })
+
+	var cands []candidate
+	if byAuthor, ok := x.byAuthor[q.Author]; q.Author != "" {
+		if !ok {
+			tokens = nil // no such author: nothing matches
+		} else if len(tokens) == 0 || len(byAuthor) < tokens[0].size {
+			cands = make([]candidate, len(byAuthor))
+			for i, id := range byAuthor {
+				cands[i] = candidate{id: id}
+			}
+		} else {
+			cands = candidates(tokens[0])
+			tokens = tokens[1:]
+			cands = intersectIDs(cands, byAuthor)
+		}
+	} else {
+		cands = candidates(tokens[0])
+		tokens = tokens[1:]
+	}
+	for _, t := range tokens {
+		if len(cands) == 0 {
+			break
+		}
+		cands = x.refine(cands, t, n)
+	}
+
+	res := Result{Total: len(cands)}
+	if wantFacet {
+		counts := make(map[string]int)
+		for _, c := range cands {
+			for _, a := range authors(x.docs[c.id].book) {
+				counts[a]++
+			}
+		}
+		res.Facets = map[string][]FacetCount{FacetAuthor: authorFacet(counts, q.FacetLimit)}
+	}
+	page := topPage(cands, q.Offset, q.Limit)
+	res.Hits = make([]Hit, len(page))
+	for i, c := range page {
+		res.Hits[i] = Hit{Book: x.docs[c.id].book, Score: c.score}
+	}
+	return res
+}
+
+// all answers a query without text or author: every book matches with
+// score 0, so the page is a slice of the sorted IDs and the facet comes
+// from the per-author lists.
+func (x *Index) all(q Query, wantFacet bool) Result {
+	res := Result{Total: len(x.ids)}
+	start := min(q.Offset, len(x.ids))
+	end := len(x.ids)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, end)
+	}
+	res.Hits = make([]Hit, 0, end-start)
+	for _, id := range x.ids[start:end] {
+		res.Hits = append(res.Hits, Hit{Book: x.docs[id].book})
+	}
+	if wantFacet {
+		counts := make(map[string]int, len(x.byAuthor))
+		for a, ids := range x.byAuthor {
+			counts[a] = len(ids)
+		}
+		res.Facets = map[string][]FacetCount{FacetAuthor: authorFacet(counts, q.FacetLimit)}
+	}
+	return res
+}
+
+// expand finds the indexed words tok matches among n books.
+func (x *Index) expand(tok string, n float64) token {
+	t := token{text: tok}
+	for i := sort.SearchStrings(x.terms, tok); i < len(x.terms) && strings.HasPrefix(x.terms[i], tok); i++ {
+		p := x.postings[x.terms[i]]
+		t.words = append(t.words, word{postings: p, weight: termWeight(tok, x.terms[i], len(p), n)})
+		t.size += len(p)
+	}
+	return t
+}
+
+// candidates scores every book containing one of t's words, by ID. A
+// book's score is that of its best-matching word. The words' postings are
+// merged rather than sorted, as a short prefix can match most books.
+func candidates(t token) []candidate {
+	out := make([]candidate, 0, t.size)
+	if len(t.words) == 1 {
+		w := t.words[0]
+		for _, e := range w.postings {
+			out = append(out, candidate{id: e.id, score: w.score(e.fields)})
+		}
+		return out
+	}
+	words := slices.Clone(t.words)
+	for len(words) > 0 {
+		// Take the lowest head ID, scored by its best word, from all lists.
+		id := words[0].postings[0].id
+		for _, w := range words[1:] {
+			id = min(id, w.postings[0].id)
+		}
+		best := 0.0
+		for i := 0; i < len(words); {
+			w := &words[i]
+			if w.postings[0].id == id {
+				best = max(best, w.score(w.postings[0].fields))
+				if w.postings = w.postings[1:]; len(w.postings) == 0 {
+					words = slices.Delete(words, i, i+1)
+					continue
+				}
+			}
+			i++
+		}
+		out = append(out, candidate{id: id, score: best})
+	}
+	return out
+}
+
+// refine adds t's score to each candidate, dropping those t does not
+// match, and reuses cands' storage. A token with many words, such as a
+// one-letter prefix, is checked against the candidates' own words instead
+// of its postings.
+func (x *Index) refine(cands []candidate, t token, n float64) []candidate {
+	out := cands[:0]
+	if len(t.words) > 8 {
+		for _, c := range cands {
+			best := 0.0
+			for term, f := range x.docs[c.id].terms {
+				if strings.HasPrefix(term, t.text) {
+					best = max(best, termScore(t.text, term, f, len(x.postings[term]), n))
+				}
+			}
+			if best > 0 {
+				out = append(out, candidate{id: c.id, score: c.score + best})
+			}
+		}
+		return out
+	}
+	// Galloping keeps each list's cost near len(cands) * log(len(p)/len(cands)).
+	pos := make([]int, len(t.words))
+	for _, c := range cands {
+		best := 0.0
+		for i, w := range t.words {
+			p := w.postings
+			pos[i] = gallop(p, pos[i], c.id, func(e posting) int64 { return e.id })
+			if pos[i] < len(p) && p[pos[i]].id == c.id {
+				best = max(best, w.score(p[pos[i]].fields))
+			}
+		}
+		if best > 0 {
+			out = append(out, candidate{id: c.id, score: c.score + best})
+		}
+	}
+	return out
+}
+
+// intersectIDs keeps the candidates whose ID is in ids, reusing cands'
+// storage.
+func intersectIDs(cands []candidate, ids []int64) []candidate {
+	out := cands[:0]
+	i := 0
+	for _, c := range cands {
+		i = gallop(ids, i, c.id, func(id int64) int64 { return id })
+		if i < len(ids) && ids[i] == c.id {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// gallop returns the first index at or after from whose ID is at least
+// id, in s sorted by ID. It probes exponentially growing steps before a
+// binary search, so a pass over a long list skips most of it.
+func gallop[T any](s []T, from int, id int64, key func(T) int64) int {
+	if from >= len(s) || key(s[from]) >= id {
+		return from
+	}
+	lo, step := from, 1
+	for lo+step < len(s) && key(s[lo+step]) < id {
+		lo += step
+		step *= 2
+	}
+	hi := min(lo+step, len(s))
+	// key(s[lo]) < id, and hi is len(s) or key(s[hi]) >= id.
+	return lo + 1 + sort.Search(hi-lo-1, func(i int) bool { return key(s[lo+1+i]) >= id })
+}
+
+// topPage returns the candidates ranked offset to offset+limit (all from
+// offset if limit is 0), with scores rounded as reported. Only that many
+// are selected and sorted, in batches, rather than every match.
+func topPage(cands []candidate, offset, limit int) []candidate {
+	for i := range cands {
+		cands[i].score = math.Round(cands[i].score*1000) / 1000
+	}
+	keep := len(cands)
+	if limit > 0 {
+		keep = min(offset+limit, keep)
+	}
+	top := make([]candidate, 0, min(2*keep+64, len(cands)))
+	for _, c := range cands {
+		top = append(top, c)
+		if len(top) == cap(top) && len(top) > keep {
+			sortCandidates(top)
+			top = top[:keep]
+		}
+	}
+	sortCandidates(top)
+	top = top[:min(keep, len(top))]
+	return top[min(offset, len(top)):]
+}
+
+func sortCandidates(cs []candidate) {
+	slices.SortFunc(cs, func(a, b candidate) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.id, b.id)
+	})
+}
+
+// termScore weighs a match of query token tok on word term, found in the
+// fields f of a book, when df of n books contain term.
+func termScore(tok, term string, f uint8, df int, n float64) float64 {
+	return fieldScore(termWeight(tok, term, df, n), f)
+}
+
+// termWeight is the field-independent part of termScore: the rarity of
+// term, discounted if tok only matches it as a prefix.
+func termWeight(tok, term string, df int, n float64) float64 {
+	w := math.Log(1 + n/float64(df))
+	if term != tok {
+		w *= prefixWeight * float64(len(tok)) / float64(len(term))
+	}
+	return w
+}
+
+// fieldScore weighs a match of weight w by the fields f it occurs in.
+func fieldScore(w float64, f uint8) float64 {
+	s := 0.0
+	if f&fieldTitle != 0 {
+		s += w * titleWeight
+	}
+	if f&fieldAuthor != 0 {
+		s += w * authorWeight
+	}
+	return s
+}
+
+// authors lists b's authors, falling back to Author for books stored
+// before Authors existed.
+func authors(b types.Book) []string {
+	if len(b.Authors) > 0 {
+		return b.Authors
+	}
+	return []string{b.Author}
+}
+
+// authorFacet lists matches per author, most frequent first. A book counts
+// once for each of its authors.
+func authorFacet(counts map[string]int, limit int) []FacetCount {
+	out := make([]FacetCount, 0, len(counts))
+	for v, c := range counts {
+		out = append(out, FacetCount{Value: v, Count: c})
+	}
+	slices.SortFunc(out, func(a, b FacetCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Tokens splits s into lower-case words with accents removed, so "Émile
+// Zola" and "emile zola" index alike.
+func Tokens(s string) []string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return strings.FieldsFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
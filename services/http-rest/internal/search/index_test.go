@@ -0,0 +1,210 @@
+package search
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"http-rest/internal/types"
+)
+
+var (
+	words = strings.Fields(`shadow river crown winter garden empire silent night iron
+		forest ocean glass memory storm hollow golden broken secret island winds
+		stone fire ashes mirror raven harbor lantern thorn summer echo wolves
+		kingdom orchard letters paper hunger tide valley north bridge daughter`)
+	first = strings.Fields("Ana Émile Jürgen Zoë José Chloé Mary Kenji Olu Sofia Ivan Priya Liam Noor")
+	last  = strings.Fields("Zola Müller Brontë Okafor García Tanaka Novak Singh Walsh Haddad Kowalski Ruiz")
+)
+
+// catalog returns n books whose titles draw on a fixed vocabulary plus a
+// unique, fixed-width serial word, so postings of common words grow with n
+// while a serial or a serial prefix matches the same books at every size.
+// Every tenth book has a co-author.
+func catalog(n int) []types.Book {
+	rng := rand.New(rand.NewPCG(1, 2))
+	out := make([]types.Book, n)
+	for i := range out {
+		title := make([]string, 2+rng.IntN(4))
+		for j := range title {
+			title[j] = words[rng.IntN(len(words))]
+		}
+		title = append(title, fmt.Sprintf("v%07d", i))
+		author := func() string { return first[rng.IntN(len(first))] + " " + last[rng.IntN(len(last))] }
+		b := types.Book{
+			ID:     int64(i + 1),
+			Title:  strings.Join(title, " "),
+			Author: author(),
+			Added:  time.Unix(int64(i), 0),
+		}
+		if co := author(); i%10 == 0 && co != b.Author {
+			b.Authors = []string{b.Author, co}
+		}
+		out[i] = b
+	}
+	return out
+}
+
+func newCatalogIndex(n int) *Index {
+	x := NewIndex()
+	for _, b := range catalog(n) {
+		x.Put(b)
+	}
+	return x
+}
+
+// scan is Search done the slow way, over every book, as a reference.
+func scan(books []types.Book, q Query) Result {
+	x := NewIndex()
+	for _, b := range books {
+		x.Put(b)
+	}
+	n := float64(len(books))
+	var hits []Hit
+	counts := make(map[string]int)
+outer:
+	for _, b := range books {
+		if q.Author != "" && !slices.Contains(authors(b), q.Author) {
+			continue
+		}
+		score := 0.0
+		for _, tok := range Tokens(q.Text) {
+			best := 0.0
+			for term, f := range x.docs[b.ID].terms {
+				if strings.HasPrefix(term, tok) {
+					best = max(best, termScore(tok, term, f, len(x.postings[term]), n))
+				}
+			}
+			if best == 0 {
+				continue outer
+			}
+			score += best
+		}
+		hits = append(hits, Hit{Book: b, Score: math.Round(score*1000) / 1000})
+		for _, a := range authors(b) {
+			counts[a]++
+		}
+	}
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if a.Score != b.Score {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		return cmp.Compare(a.Book.ID, b.Book.ID)
+	})
+	res := Result{Total: len(hits)}
+	start := min(q.Offset, len(hits))
+	end := len(hits)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, end)
+	}
+	res.Hits = hits[start:end]
+	if slices.Contains(q.Facets, FacetAuthor) {
+		res.Facets = map[string][]FacetCount{FacetAuthor: authorFacet(counts, q.FacetLimit)}
+	}
+	return res
+}
+
+func TestSearchMatchesScan(t *testing.T) {
+	books := catalog(3000)
+	x := NewIndex()
+	for _, b := range books {
+		x.Put(b)
+	}
+	// Replace and remove some books so the postings have been edited.
+	for i := 0; i < len(books); i += 7 {
+		books[i].Title = "renamed " + books[i].Title
+		x.Put(books[i])
+	}
+	for i := 5; i < len(books); i += 11 {
+		x.Remove(books[i].ID)
+	}
+	books = slices.DeleteFunc(books, func(b types.Book) bool { return b.ID%11 == 6 })
+
+	facet := []string{FacetAuthor}
+	for _, q := range []Query{
+		{Text: "v0000123"},
+		{Text: "v00001", Limit: 5},
+		{Text: "emile zola", Limit: 20, Facets: facet},
+		{Text: "silent raven", Limit: 10, Offset: 3},
+		{Text: "s", Limit: 20},
+		{Text: "silent s", Limit: 20},
+		{Text: "renamed w", Limit: 20, Facets: facet, FacetLimit: 3},
+		{Text: "lantern", Author: "Ana Zola", Facets: facet},
+		{Text: "l", Author: "Noor Ruiz", Limit: 4},
+		{Author: "Mary Singh", Limit: 10, Offset: 2, Facets: facet},
+		{Author: "Nobody"},
+		{Text: "nothing"},
+		{Text: "silent nothing"},
+		{Limit: 20, Offset: 40, Facets: facet, FacetLimit: 5},
+		{},
+	} {
+		got, want := x.Search(q), scan(books, q)
+		if got.Total != want.Total || len(got.Hits) != len(want.Hits) {
+			t.Errorf("Search(%+v): %d hits of %d, want %d of %d", q, len(got.Hits), got.Total, len(want.Hits), want.Total)
+			continue
+		}
+		for i := range got.Hits {
+			if got.Hits[i].Book.ID != want.Hits[i].Book.ID || got.Hits[i].Score != want.Hits[i].Score {
+				t.Errorf("Search(%+v) hit %d = %d (%g), want %d (%g)", q, i,
+					got.Hits[i].Book.ID, got.Hits[i].Score, want.Hits[i].Book.ID, want.Hits[i].Score)
+				break
+			}
+		}
+		if !slices.Equal(got.Facets[FacetAuthor], want.Facets[FacetAuthor]) {
+			t.Errorf("Search(%+v) facet = %v, want %v", q, got.Facets[FacetAuthor], want.Facets[FacetAuthor])
+		}
+	}
+}
+
+// BenchmarkSearch runs each query on catalogs of growing size. Selective
+// queries should cost the same at every size; queries matching a fixed
+// share of the catalog grow with their matches, which Total and the
+// facets must visit, and stay well under a page-sized sort of them all.
+func BenchmarkSearch(b *testing.B) {
+	facet := []string{FacetAuthor}
+	queries := []struct {
+		name string
+		q    Query
+	}{
+		{"rare word", Query{Text: "v0000123", Limit: 20}},
+		{"rare prefix", Query{Text: "v000012", Limit: 20}},
+		{"rare and common", Query{Text: "v0000123 silent", Limit: 20}},
+		{"author, accents", Query{Text: "emile zola", Limit: 20}},
+		{"two common words", Query{Text: "silent raven", Limit: 20}},
+		{"common word", Query{Text: "lantern", Limit: 20}},
+		{"common word + facet", Query{Text: "lantern", Limit: 20, Facets: facet, FacetLimit: 20}},
+		{"short prefix", Query{Text: "s", Limit: 20}},
+		{"author filter", Query{Author: "Ana Zola", Limit: 20}},
+		{"empty", Query{Limit: 20}},
+		{"empty, deep page", Query{Limit: 20, Offset: 5000}},
+		{"empty + facet", Query{Limit: 20, Facets: facet, FacetLimit: 20}},
+	}
+	for _, n := range []int{1000, 10000, 100000} {
+		x := newCatalogIndex(n)
+		for _, q := range queries {
+			b.Run(fmt.Sprintf("books=%d/%s", n, q.name), func(b *testing.B) {
+				b.ReportAllocs()
+				b.ReportMetric(float64(x.Search(q.q).Total), "matches")
+				for b.Loop() {
+					x.Search(q.q)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	books := catalog(100000)
+	b.ReportAllocs()
+	for b.Loop() {
+		x := NewIndex()
+		for _, bk := range books[:10000] {
+			x.Put(bk)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"context"
+	"sync"
+
+	"http-rest/internal/store"
+	"http-rest/internal/types"
+)
+
+// loadBatch is the page size used to index an existing catalog.
+const loadBatch = 1000
+
+// IndexedStore is a BookStore that keeps an Index in step with every
+// write. The index only sees writes made through this process, so it suits
+// a single instance or a store no one else writes to.
+type IndexedStore struct {
+	store.BookStore
+	index *Index
+	// mu orders writes so the index applies them in the store's order.
+	mu sync.Mutex
+}
+
+// NewIndexedStore indexes every book already in s and returns s wrapped.
+func NewIndexedStore(ctx context.Context, s store.BookStore) (*IndexedStore, error) {
+	idx := NewIndex()
+	opts := store.ListOptions{Limit: loadBatch}
+	for {
+		page, err := s.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range page {
+			idx.Put(b)
+		}
+		if len(page) < loadBatch {
+			break
+		}
+		c := store.CursorFor(page[len(page)-1], opts.Sort)
+		opts.After = &c
+	}
+	return &IndexedStore{BookStore: s, index: idx}, nil
+}
+
+// Index returns the index to search.
+func (s *IndexedStore) Index() *Index { return s.index }
+
+func (s *IndexedStore) Create(ctx context.Context, b types.Book) (types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.BookStore.Create(ctx, b)
+	if err == nil {
+		s.index.Put(b)
+	}
+	return b, err
+}
+
+func (s *IndexedStore) CreateMany(ctx context.Context, books []types.Book) ([]types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out, err := s.BookStore.CreateMany(ctx, books)
+	if err == nil {
+		for _, b := range out {
+			s.index.Put(b)
+		}
+	}
+	return out, err
+}
+
+func (s *IndexedStore) Update(ctx context.Context, id int64, fn func(*types.Book) error) (types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.BookStore.Update(ctx, id, fn)
+	if err == nil {
+		s.index.Put(b)
+	}
+	return b, err
+}
+
+func (s *IndexedStore) Delete(ctx context.Context, id int64, check func(types.Book) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.BookStore.Delete(ctx, id, check)
+	if err == nil {
+		s.index.Remove(id)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// certReloader serves the latest certificate pair loaded from disk.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	modTime           time.Time // of the newer file, when last loaded
+	logger            *slog.Logger
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	mod, err := c.modified()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(mod); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// modified returns the later modification time of the two files.
+func (c *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("tls: %w", err)
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) load(mod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err // already prefixed "tls: "
+	}
+	c.cert.Store(&cert)
+	c.modTime = mod
+	return nil
+}
+
+// watch reloads the pair whenever either file changes, until ctx is done.
+// A pair that fails to load is logged and the current one kept, so a
+// renewal that writes the two files one after the other is picked up on
+// the next tick.
+func (c *certReloader) watch(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		mod, err := c.modified()
+		if err != nil {
+			c.logger.Error("tls certificate check", "err", err)
+			continue
+		}
+		if mod.Equal(c.modTime) {
+			continue
+		}
+		if err := c.load(mod); err != nil {
+			c.logger.Error("tls certificate reload", "err", err)
+			continue
+		}
+		c.logger.Info("tls certificate reloaded", "cert", c.certFile)
+	}
+}
@@ -0,0 +1,145 @@
+// Package server runs the HTTP server: hardened defaults, optional TLS with
+// certificates reloaded from disk, and a graceful drain that first reports
+// not-ready so load balancers stop sending traffic.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Options configures a Server. Zero durations disable the timeout they
+// name, except where a default is noted.
+type Options struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration // defaults to 10s
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int // defaults to http.DefaultMaxHeaderBytes
+
+	// DrainDelay is how long the server keeps serving, while reporting
+	// not-ready, before it stops accepting connections.
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds the wait for in-flight requests; connections
+	// still open after it are closed. Defaults to 30s.
+	ShutdownTimeout time.Duration
+
+	// TLSCertFile and TLSKeyFile enable HTTPS. The pair is re-read when
+	// either file changes, checked every TLSReloadInterval (default 1m).
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSReloadInterval time.Duration
+}
+
+// Server is an http.Server with readiness and graceful shutdown.
+type Server struct {
+	opts   Options
+	srv    *http.Server
+	certs  *certReloader
+	ready  atomic.Bool
+	logger *slog.Logger
+}
+
+// New returns a server for opts. With TLS configured the certificate pair
+// is loaded now, so a bad pair fails start-up rather than the first
+// handshake.
+func New(opts Options, logger *slog.Logger) (*Server, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.ReadHeaderTimeout == 0 {
+		opts.ReadHeaderTimeout = 10 * time.Second
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = 30 * time.Second
+	}
+	if opts.TLSReloadInterval == 0 {
+		opts.TLSReloadInterval = time.Minute
+	}
+	s := &Server{opts: opts, logger: logger}
+	s.srv = &http.Server{
+		Addr:              opts.Addr,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+		MaxHeaderBytes:    opts.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	if (opts.TLSCertFile == "") != (opts.TLSKeyFile == "") {
+		return nil, errors.New("server: TLS needs both a certificate and a key file")
+	}
+	if opts.TLSCertFile != "" {
+		certs, err := newCertReloader(opts.TLSCertFile, opts.TLSKeyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		s.certs = certs
+		s.srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+	return s, nil
+}
+
+// Ready reports whether the server is accepting traffic: true from Run
+// until shutdown begins. Readiness probes should fail while it is false.
+func (s *Server) Ready() bool { return s.ready.Load() }
+
+// RegisterOnShutdown calls f when shutdown begins, for handlers such as
+// long-lived streams that would otherwise hold the drain open.
+func (s *Server) RegisterOnShutdown(f func()) { s.srv.RegisterOnShutdown(f) }
+
+// Run serves handler until ctx is done, then drains: it reports not-ready,
+// waits DrainDelay, stops accepting connections and waits up to
+// ShutdownTimeout for in-flight requests. It returns nil after a clean
+// drain.
+func (s *Server) Run(ctx context.Context, handler http.Handler) error {
+	s.srv.Handler = handler
+	ln, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.certs != nil {
+			serveErr <- s.srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- s.srv.Serve(ln)
+		}
+	}()
+	if s.certs != nil {
+		go s.certs.watch(ctx, s.opts.TLSReloadInterval)
+	}
+	s.ready.Store(true)
+	s.logger.Info("http server", "addr", ln.Addr().String(), "tls", s.certs != nil)
+
+	select {
+	case err := <-serveErr:
+		s.ready.Store(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	s.ready.Store(false)
+	s.logger.Info("draining", "delay", s.opts.DrainDelay, "timeout", s.opts.ShutdownTimeout)
+	time.Sleep(s.opts.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		s.logger.Warn("drain deadline passed; closing open connections", "err", err)
+		_ = s.srv.Close()
+		return err
+	}
+	s.logger.Info("server drained")
+	return nil
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"http-rest/internal/types"
+)
+
+// MemoryStore keeps books in a map. Contents are lost on restart.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	data  map[int64]types.Book
+	isbns map[string]int64
+	next  int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[int64]types.Book), isbns: make(map[string]int64), next: 1}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, b types.Book) (types.Book, error) {
+	out, err := s.CreateMany(ctx, []types.Book{b})
+	if err != nil {
+		return types.Book{}, err
+	}
+	return out[0], nil
+}
+
+func (s *MemoryStore) CreateMany(_ context.Context, books []types.Book) ([]types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Check every ISBN first, so a clash stores nothing.
+	seen := make(map[string]bool)
+	for _, b := range books {
+		if b.ISBN == "" {
+			continue
+		}
+		if _, taken := s.isbns[b.ISBN]; taken || seen[b.ISBN] {
+			return nil, ErrDuplicateISBN
+		}
+		seen[b.ISBN] = true
+	}
+	out := make([]types.Book, len(books))
+	for i, b := range books {
+		out[i] = s.create(b)
+	}
+	return out, nil
+}
+
+// create stores b under the next ID; s.mu must be held.
+func (s *MemoryStore) create(b types.Book) types.Book {
+	b.ID = s.next
+	s.next++
+	b.Version = 1
+	if b.Updated.IsZero() {
+		b.Updated = b.Added
+	}
+	s.put(b)
+	return b
+}
+
+// put stores a copy of b, so callers cannot change it through its slices;
+// s.mu must be held.
+func (s *MemoryStore) put(b types.Book) {
+	b.Authors, b.Tags = slices.Clone(b.Authors), slices.Clone(b.Tags)
+	if old, ok := s.data[b.ID]; ok && old.ISBN != "" {
+		delete(s.isbns, old.ISBN)
+	}
+	if b.ISBN != "" {
+		s.isbns[b.ISBN] = b.ID
+	}
+	s.data[b.ID] = b
+}
+
+func (s *MemoryStore) Get(_ context.Context, id int64) (types.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.data[id]
+	if !ok {
+		return types.Book{}, ErrNotFound
+	}
+	return b, nil
+}
+
+func (s *MemoryStore) GetByISBN(ctx context.Context, isbn string) (types.Book, error) {
+	s.mu.RLock()
+	id, ok := s.isbns[isbn]
+	s.mu.RUnlock()
+	if !ok {
+		return types.Book{}, ErrNotFound
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *MemoryStore) List(_ context.Context, opts ListOptions) ([]types.Book, error) {
+	s.mu.RLock()
+	out := make([]types.Book, 0, len(s.data))
+	for _, b := range s.data {
+		if opts.Filter.Match(b) {
+			out = append(out, b)
+		}
+	}
+	s.mu.RUnlock()
+
+	order := func(a, b Cursor) int {
+		c := compareCursors(opts.Sort, a, b)
+		if opts.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(out, func(a, b types.Book) int {
+		return order(CursorFor(a, opts.Sort), CursorFor(b, opts.Sort))
+	})
+	if opts.After != nil {
+		start, _ := slices.BinarySearchFunc(out, *opts.After, func(b types.Book, c Cursor) int {
+			if order(CursorFor(b, opts.Sort), c) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		out = out[start:]
+	}
+	if opts.Limit > 0 && len(out) > opts.Limit {
+		out = out[:opts.Limit]
+	}
+	return out, nil
+}
+
+// compareCursors orders two positions by sort key, then by ID.
+func compareCursors(f SortField, a, b Cursor) int {
+	var c int
+	switch f {
+	case SortTitle, SortAuthor:
+		c = strings.Compare(a.Key, b.Key)
+	case SortAdded:
+		ta, _ := time.Parse(time.RFC3339Nano, a.Key)
+		tb, _ := time.Parse(time.RFC3339Nano, b.Key)
+		c = ta.Compare(tb)
+	}
+	if c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+func (s *MemoryStore) Count(context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.data)), nil
+}
+
+func (s *MemoryStore) Update(_ context.Context, id int64, fn func(*types.Book) error) (types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.data[id]
+	if !ok {
+		return types.Book{}, ErrNotFound
+	}
+	version := b.Version
+	b.Authors, b.Tags = slices.Clone(b.Authors), slices.Clone(b.Tags)
+	if err := fn(&b); err != nil {
+		return types.Book{}, err
+	}
+	if owner, taken := s.isbns[b.ISBN]; b.ISBN != "" && taken && owner != id {
+		return types.Book{}, ErrDuplicateISBN
+	}
+	b.ID = id
+	b.Version = version + 1
+	b.Updated = time.Now().UTC()
+	s.put(b)
+	return b, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, id int64, check func(types.Book) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.data[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if check != nil {
+		if err := check(b); err != nil {
+			return err
+		}
+	}
+	delete(s.data, id)
+	if b.ISBN != "" {
+		delete(s.isbns, b.ISBN)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error { return nil }
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"  // PostgreSQL
+	_ "modernc.org/sqlite" // SQLite (pure Go)
+)
+
+// Open connects to the store named by driver. "memory" needs no DSN.
+func Open(ctx context.Context, driver, dsn string) (BookStore, error) {
+	var dialect Dialect
+	switch driver {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "postgres":
+		dialect = Postgres
+	case "sqlite":
+		dialect = SQLite
+	default:
+		return nil, fmt.Errorf("unknown book store %q", driver)
+	}
+
+	db, err := sql.Open(string(dialect), dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == SQLite {
+		// A single connection serialises writers and avoids SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := NewSQLStore(db, dialect)
+	if err := s.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
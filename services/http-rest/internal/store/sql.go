@@ -0,0 +1,548 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"http-rest/internal/types"
+)
+
+// Dialect selects the SQL flavour spoken by a SQLStore.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// SQLStore keeps books in a database/sql database.
+type SQLStore struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+func NewSQLStore(db *sql.DB, dialect Dialect) *SQLStore {
+	return &SQLStore{DB: db, Dialect: dialect}
+}
+
+// Migrate creates the books tables and indexes if they do not exist yet,
+// and brings tables created by older releases up to date: each missing
+// column is added, and each book stored before book_authors existed gets
+// its author copied there. Both dialects run DDL in transactions, so an
+// interrupted upgrade leaves nothing half done. The backfill is recorded
+// in books_migrations, independently of the columns, so it runs exactly
+// once even on databases an earlier, non-transactional upgrade left
+// without it.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	ts, ddl := "TIMESTAMPTZ", `
+CREATE TABLE IF NOT EXISTS books (
+  id BIGSERIAL PRIMARY KEY,
+  title   TEXT NOT NULL,
+  author  TEXT NOT NULL,
+  added   TIMESTAMPTZ NOT NULL,
+  updated TIMESTAMPTZ NOT NULL,
+  version BIGINT NOT NULL DEFAULT 1,
+  isbn      TEXT,
+  year      INTEGER,
+  publisher TEXT,
+  language  TEXT
+)`
+	if s.Dialect == SQLite {
+		ts = "TIMESTAMP"
+		ddl = `
+CREATE TABLE IF NOT EXISTS books (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title   TEXT NOT NULL,
+  author  TEXT NOT NULL,
+  added   TIMESTAMP NOT NULL,
+  updated TIMESTAMP NOT NULL,
+  version BIGINT NOT NULL DEFAULT 1,
+  isbn      TEXT,
+  year      INTEGER,
+  publisher TEXT,
+  language  TEXT
+)`
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, ddl); err != nil {
+		return err
+	}
+	cols, err := s.columns(ctx, tx)
+	if err != nil {
+		return err
+	}
+	var stmts []string
+	if !cols["updated"] {
+		stmts = append(stmts,
+			`ALTER TABLE books ADD COLUMN updated `+ts,
+			`UPDATE books SET updated = added WHERE updated IS NULL`)
+	}
+	for _, c := range []struct{ name, ddl string }{
+		{"version", "BIGINT NOT NULL DEFAULT 1"},
+		{"isbn", "TEXT"},
+		{"year", "INTEGER"},
+		{"publisher", "TEXT"},
+		{"language", "TEXT"},
+	} {
+		if !cols[c.name] {
+			stmts = append(stmts, `ALTER TABLE books ADD COLUMN `+c.name+` `+c.ddl)
+		}
+	}
+	stmts = append(stmts,
+		`CREATE TABLE IF NOT EXISTS book_authors (
+  book_id  BIGINT NOT NULL REFERENCES books (id),
+  position INTEGER NOT NULL,
+  name     TEXT NOT NULL,
+  PRIMARY KEY (book_id, position)
+)`,
+		`CREATE TABLE IF NOT EXISTS book_tags (
+  book_id BIGINT NOT NULL REFERENCES books (id),
+  tag     TEXT NOT NULL,
+  PRIMARY KEY (book_id, tag)
+)`,
+		`CREATE TABLE IF NOT EXISTS books_migrations (name TEXT PRIMARY KEY)`,
+		`CREATE INDEX IF NOT EXISTS books_title_id ON books (title, id)`,
+		`CREATE INDEX IF NOT EXISTS books_author_id ON books (author, id)`,
+		`CREATE INDEX IF NOT EXISTS books_added_id ON books (added, id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS `+isbnIndex+` ON books (isbn)`,
+		`CREATE INDEX IF NOT EXISTS book_authors_name ON book_authors (name)`,
+		`CREATE INDEX IF NOT EXISTS book_tags_tag ON book_tags (tag)`,
+	)
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := s.backfillAuthors(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// authorsBackfill names the books_migrations row of backfillAuthors.
+const authorsBackfill = "book_authors_backfill"
+
+// backfillAuthors copies the author of every book without book_authors
+// rows into book_authors, unless that was done before.
+func (s *SQLStore) backfillAuthors(ctx context.Context, tx *sql.Tx) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM books_migrations WHERE name=?`), authorsBackfill).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO book_authors (book_id, position, name)
+SELECT id, 0, author FROM books b WHERE NOT EXISTS (SELECT 1 FROM book_authors a WHERE a.book_id = b.id)`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO books_migrations (name) VALUES (?)`), authorsBackfill)
+	return err
+}
+
+// isbnIndex keeps ISBNs unique; NULLs, for books without one, never clash.
+const isbnIndex = "books_isbn"
+
+// columns reports which columns the books table has.
+func (s *SQLStore) columns(ctx context.Context, q queryer) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, `SELECT * FROM books LIMIT 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]bool, len(names))
+	for _, n := range names {
+		cols[strings.ToLower(n)] = true
+	}
+	return cols, nil
+}
+
+const bookColumns = `id,title,author,added,updated,version,isbn,year,publisher,language`
+
+// scanBook reads the books row; authors and tags are loaded separately by
+// loadLists.
+func scanBook(row interface{ Scan(...any) error }) (types.Book, error) {
+	var (
+		b                         types.Book
+		isbn, publisher, language sql.NullString
+		year                      sql.NullInt64
+	)
+	if err := row.Scan(&b.ID, &b.Title, &b.Author, &b.Added, &b.Updated, &b.Version, &isbn, &year, &publisher, &language); err != nil {
+		return types.Book{}, err
+	}
+	b.Added, b.Updated = b.Added.UTC(), b.Updated.UTC()
+	b.ISBN, b.Year, b.Publisher, b.Language = isbn.String, int(year.Int64), publisher.String, language.String
+	return b, nil
+}
+
+// nullable stores a zero value as NULL, so absent ISBNs do not collide
+// and absent years do not match year filters.
+func nullable[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+// loadLists fills in the authors and tags of books with one query each.
+func (s *SQLStore) loadLists(ctx context.Context, q queryer, books []types.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	at := make(map[int64]int, len(books))
+	ids := make([]any, len(books))
+	for i, b := range books {
+		at[b.ID] = i
+		ids[i] = b.ID
+	}
+	in := "(" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+	for _, l := range []struct {
+		query string
+		add   func(*types.Book, string)
+	}{
+		{`SELECT book_id, name FROM book_authors WHERE book_id IN ` + in + ` ORDER BY book_id, position`,
+			func(b *types.Book, v string) { b.Authors = append(b.Authors, v) }},
+		{`SELECT book_id, tag FROM book_tags WHERE book_id IN ` + in + ` ORDER BY book_id, tag`,
+			func(b *types.Book, v string) { b.Tags = append(b.Tags, v) }},
+	} {
+		rows, err := q.QueryContext(ctx, s.rebind(l.query), ids...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var (
+				id int64
+				v  string
+			)
+			if err := rows.Scan(&id, &v); err != nil {
+				rows.Close()
+				return err
+			}
+			l.add(&books[at[id]], v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLists replaces the stored authors and tags of b.
+func (s *SQLStore) writeLists(ctx context.Context, tx *sql.Tx, b types.Book) error {
+	for _, stmt := range []string{`DELETE FROM book_authors WHERE book_id=?`, `DELETE FROM book_tags WHERE book_id=?`} {
+		if _, err := tx.ExecContext(ctx, s.rebind(stmt), b.ID); err != nil {
+			return err
+		}
+	}
+	for i, a := range b.Authors {
+		if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO book_authors (book_id, position, name) VALUES (?,?,?)`), b.ID, i, a); err != nil {
+			return err
+		}
+	}
+	for _, t := range b.Tags {
+		if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO book_tags (book_id, tag) VALUES (?,?)`), b.ID, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) Create(ctx context.Context, b types.Book) (types.Book, error) {
+	out, err := s.CreateMany(ctx, []types.Book{b})
+	if err != nil {
+		return types.Book{}, err
+	}
+	return out[0], nil
+}
+
+func (s *SQLStore) CreateMany(ctx context.Context, books []types.Book) ([]types.Book, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	out := make([]types.Book, len(books))
+	for i, b := range books {
+		if out[i], err = s.insert(ctx, tx, b); err != nil {
+			return nil, err
+		}
+	}
+	return out, tx.Commit()
+}
+
+func (s *SQLStore) insert(ctx context.Context, tx *sql.Tx, b types.Book) (types.Book, error) {
+	// Postgres keeps microseconds; truncate so callers see what a later Get returns.
+	b.Added = b.Added.UTC().Truncate(time.Microsecond)
+	if b.Updated.IsZero() {
+		b.Updated = b.Added
+	}
+	b.Updated = b.Updated.UTC().Truncate(time.Microsecond)
+	b.Version = 1
+	err := tx.QueryRowContext(ctx, s.rebind(`INSERT INTO books (title,author,added,updated,version,isbn,year,publisher,language) VALUES (?,?,?,?,?,?,?,?,?) RETURNING id`),
+		b.Title, b.Author, b.Added, b.Updated, b.Version, nullable(b.ISBN), nullable(b.Year), nullable(b.Publisher), nullable(b.Language)).Scan(&b.ID)
+	if err != nil {
+		return types.Book{}, mapError(err)
+	}
+	if err := s.writeLists(ctx, tx, b); err != nil {
+		return types.Book{}, err
+	}
+	return b, nil
+}
+
+func (s *SQLStore) Get(ctx context.Context, id int64) (types.Book, error) {
+	return s.get(ctx, s.DB, "id=?", id, "")
+}
+
+func (s *SQLStore) GetByISBN(ctx context.Context, isbn string) (types.Book, error) {
+	return s.get(ctx, s.DB, "isbn=?", isbn, "")
+}
+
+func (s *SQLStore) List(ctx context.Context, opts ListOptions) ([]types.Book, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if opts.Author != "" {
+		where = append(where, "(author = ? OR EXISTS (SELECT 1 FROM book_authors a WHERE a.book_id = books.id AND a.name = ?))")
+		args = append(args, opts.Author, opts.Author)
+	}
+	if opts.Query != "" {
+		where = append(where, `LOWER(title) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(strings.ToLower(opts.Query))+"%")
+	}
+	for _, c := range []struct {
+		cond string
+		v    any
+	}{
+		{"isbn = ?", nullable(opts.ISBN)},
+		{"publisher = ?", nullable(opts.Publisher)},
+		{"language = ?", nullable(opts.Language)},
+		{"year >= ?", nullable(opts.YearFrom)},
+		{"year <= ?", nullable(opts.YearTo)},
+	} {
+		if c.v != nil {
+			where = append(where, c.cond)
+			args = append(args, c.v)
+		}
+	}
+	for _, t := range opts.Tags {
+		where = append(where, "EXISTS (SELECT 1 FROM book_tags t WHERE t.book_id = books.id AND t.tag = ?)")
+		args = append(args, t)
+	}
+
+	col, dir, op := "id", "ASC", ">"
+	if opts.Sort.Valid() {
+		col = string(opts.Sort)
+	}
+	if opts.Desc {
+		dir, op = "DESC", "<"
+	}
+	if c := opts.After; c != nil {
+		if col == "id" {
+			where = append(where, "id "+op+" ?")
+			args = append(args, c.ID)
+		} else {
+			var key any = c.Key
+			if opts.Sort == SortAdded {
+				t, err := time.Parse(time.RFC3339Nano, c.Key)
+				if err != nil {
+					return nil, err
+				}
+				key = t
+			}
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", col, op))
+			args = append(args, key, key, c.ID)
+		}
+	}
+
+	query := `SELECT ` + bookColumns + ` FROM books`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + col + " " + dir
+	if col != "id" {
+		query += ", id " + dir
+	}
+	if opts.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(opts.Limit)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []types.Book{}
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Release the connection first: SQLite has only one.
+	rows.Close()
+	return out, s.loadLists(ctx, s.DB, out)
+}
+
+func (s *SQLStore) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM books").Scan(&n)
+	return n, err
+}
+
+func (s *SQLStore) Update(ctx context.Context, id int64, fn func(*types.Book) error) (types.Book, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return types.Book{}, err
+	}
+	defer tx.Rollback()
+
+	b, err := s.get(ctx, tx, "id=?", id, s.lockClause())
+	if err != nil {
+		return types.Book{}, err
+	}
+	version := b.Version
+	if err := fn(&b); err != nil {
+		return types.Book{}, err
+	}
+	b.ID = id
+	b.Added = b.Added.UTC().Truncate(time.Microsecond)
+	b.Updated = time.Now().UTC().Truncate(time.Microsecond)
+	b.Version = version + 1
+	if _, err := tx.ExecContext(ctx, s.rebind(`UPDATE books SET title=?, author=?, added=?, updated=?, version=?, isbn=?, year=?, publisher=?, language=? WHERE id=?`),
+		b.Title, b.Author, b.Added, b.Updated, b.Version, nullable(b.ISBN), nullable(b.Year), nullable(b.Publisher), nullable(b.Language), id); err != nil {
+		return types.Book{}, mapError(err)
+	}
+	if err := s.writeLists(ctx, tx, b); err != nil {
+		return types.Book{}, err
+	}
+	return b, tx.Commit()
+}
+
+func (s *SQLStore) Delete(ctx context.Context, id int64, check func(types.Book) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if check != nil {
+		b, err := s.get(ctx, tx, "id=?", id, s.lockClause())
+		if err != nil {
+			return err
+		}
+		if err := check(b); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range []string{`DELETE FROM book_authors WHERE book_id=?`, `DELETE FROM book_tags WHERE book_id=?`} {
+		if _, err := tx.ExecContext(ctx, s.rebind(stmt), id); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM books WHERE id=?`), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// lockClause keeps a row read inside a transaction from changing before
+// the transaction writes it. SQLite serialises writers on its own.
+func (s *SQLStore) lockClause() string {
+	if s.Dialect == Postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+func (s *SQLStore) Close() error { return s.DB.Close() }
+
+// queryer is a *sql.DB or *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// get reads the one book matching cond, such as "id=?", with its lists.
+func (s *SQLStore) get(ctx context.Context, q queryer, cond string, arg any, suffix string) (types.Book, error) {
+	b, err := scanBook(q.QueryRowContext(ctx, s.rebind(`SELECT `+bookColumns+` FROM books WHERE `+cond+suffix), arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Book{}, ErrNotFound
+	}
+	if err != nil {
+		return types.Book{}, err
+	}
+	books := []types.Book{b}
+	if err := s.loadLists(ctx, q, books); err != nil {
+		return types.Book{}, err
+	}
+	return books[0], nil
+}
+
+// mapError turns a violation of the ISBN index into ErrDuplicateISBN.
+func mapError(err error) error {
+	var (
+		pqErr     *pq.Error
+		sqliteErr *sqlite.Error
+	)
+	switch {
+	case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == isbnIndex,
+		errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE && strings.Contains(sqliteErr.Error(), "books.isbn"):
+		return ErrDuplicateISBN
+	}
+	return err
+}
+
+// rebind rewrites ? placeholders into the $N form Postgres expects.
+func (s *SQLStore) rebind(query string) string {
+	if s.Dialect != Postgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
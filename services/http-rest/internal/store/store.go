@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-rest/internal/types"
+)
+
+// ErrNotFound is returned when no book exists with the requested ID.
+var ErrNotFound = errors.New("book not found")
+
+// ErrDuplicateISBN is returned when a write would give two books the same
+// ISBN.
+var ErrDuplicateISBN = errors.New("isbn already in use")
+
+// Filter narrows the books returned by List. Zero values match everything.
+type Filter struct {
+	Author    string   // exact match on any of the authors
+	Query     string   // case-insensitive substring of the title
+	ISBN      string   // exact match on the 13-digit form
+	Publisher string   // exact match
+	Language  string   // exact match on the language tag
+	YearFrom  int      // inclusive lower bound on the publication year
+	YearTo    int      // inclusive upper bound on the publication year
+	Tags      []string // every one must be among the book's tags
+}
+
+// Match reports whether b passes f, for stores that filter in memory.
+func (f Filter) Match(b types.Book) bool {
+	switch {
+	case f.Author != "" && b.Author != f.Author && !slices.Contains(b.Authors, f.Author):
+		return false
+	case f.Query != "" && !strings.Contains(strings.ToLower(b.Title), strings.ToLower(f.Query)):
+		return false
+	case f.ISBN != "" && b.ISBN != f.ISBN:
+		return false
+	case f.Publisher != "" && b.Publisher != f.Publisher:
+		return false
+	case f.Language != "" && b.Language != f.Language:
+		return false
+	case f.YearFrom != 0 && (b.Year == 0 || b.Year < f.YearFrom):
+		return false
+	case f.YearTo != 0 && (b.Year == 0 || b.Year > f.YearTo):
+		return false
+	}
+	for _, t := range f.Tags {
+		if !slices.Contains(b.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortField names the column List orders by. Ties are broken by ID.
+type SortField string
+
+const (
+	SortID     SortField = "id"
+	SortTitle  SortField = "title"
+	SortAuthor SortField = "author"
+	SortAdded  SortField = "added"
+)
+
+// Valid reports whether f is a known sort field.
+func (f SortField) Valid() bool {
+	switch f {
+	case SortID, SortTitle, SortAuthor, SortAdded:
+		return true
+	}
+	return false
+}
+
+// Cursor marks the last book of a previous page: its sort key and ID.
+type Cursor struct {
+	Key string
+	ID  int64
+}
+
+// CursorFor returns the cursor positioned just after b when ordering by f.
+func CursorFor(b types.Book, f SortField) Cursor {
+	c := Cursor{ID: b.ID}
+	switch f {
+	case SortTitle:
+		c.Key = b.Title
+	case SortAuthor:
+		c.Key = b.Author
+	case SortAdded:
+		c.Key = b.Added.UTC().Format(time.RFC3339Nano)
+	default:
+		c.Key = strconv.FormatInt(b.ID, 10)
+	}
+	return c
+}
+
+// ListOptions selects one page of books. The zero value lists everything
+// ordered by ascending ID.
+type ListOptions struct {
+	Filter
+	Sort  SortField // defaults to SortID
+	Desc  bool
+	After *Cursor // resume after this position; nil starts at the beginning
+	Limit int     // maximum number of books; 0 means no limit
+}
+
+// BookStore persists books. Implementations must be safe for concurrent use.
+// Writes that would store an ISBN another book already has fail with
+// ErrDuplicateISBN.
+type BookStore interface {
+	// Create assigns b a new ID and version 1 and stores it. A zero
+	// Updated is set to Added.
+	Create(ctx context.Context, b types.Book) (types.Book, error)
+	// CreateMany creates books in order as Create does, atomically: either
+	// all of them are stored or, on error, none.
+	CreateMany(ctx context.Context, books []types.Book) ([]types.Book, error)
+	Get(ctx context.Context, id int64) (types.Book, error)
+	// GetByISBN returns the book with the given 13-digit ISBN.
+	GetByISBN(ctx context.Context, isbn string) (types.Book, error)
+	// List returns the books matching opts in a stable order.
+	List(ctx context.Context, opts ListOptions) ([]types.Book, error)
+	// Count returns the number of stored books.
+	Count(ctx context.Context) (int64, error)
+	// Update loads the book, applies fn and stores the result atomically,
+	// bumping Version and setting Updated to the current time.
+	// If fn returns an error nothing is written and the error is returned.
+	Update(ctx context.Context, id int64, fn func(*types.Book) error) (types.Book, error)
+	// Delete removes the book. A non-nil check sees the current book first
+	// and can veto the delete by returning an error, which Delete returns.
+	Delete(ctx context.Context, id int64, check func(types.Book) error) error
+	Close() error
+}
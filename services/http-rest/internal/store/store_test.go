@@ -0,0 +1,86 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"http-rest/internal/store"
+	"http-rest/internal/store/storetest"
+)
+
+// TEST_POSTGRES_DSN points the suite at a scratch Postgres database with no
+// books in it; the suite deletes everything it creates.
+const postgresDSNEnv = "TEST_POSTGRES_DSN"
+
+func TestMemoryStore(t *testing.T) {
+	if err := storetest.TestStore(store.NewMemoryStore()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "books.db") + "?_pragma=busy_timeout(5000)"
+	testOpen(t, "sqlite", dsn)
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresDSNEnv)
+	}
+	testOpen(t, "postgres", dsn)
+}
+
+// TestSQLiteUpgrade starts from a books table that already has the
+// publication columns but whose authors were never copied to book_authors,
+// as an interrupted upgrade by an older release could leave it.
+func TestSQLiteUpgrade(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, `
+CREATE TABLE books (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title  TEXT NOT NULL,
+  author TEXT NOT NULL,
+  added  TIMESTAMP NOT NULL,
+  isbn   TEXT
+);
+INSERT INTO books (title, author, added) VALUES ('Nana', 'Émile Zola', '2024-01-02 03:04:05');
+`); err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewSQLStore(db, store.SQLite)
+	for range 2 {
+		if err := s.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := s.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Authors) != 1 || b.Authors[0] != "Émile Zola" || b.Version != 1 || !b.Updated.Equal(b.Added) {
+		t.Errorf("upgraded book = %+v, want author copied, version 1, updated = added", b)
+	}
+}
+
+func testOpen(t *testing.T, driver, dsn string) {
+	t.Helper()
+	ctx := context.Background()
+	s, err := store.Open(ctx, driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := storetest.TestStore(s); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,545 @@
+// Package storetest implements a conformance suite for store.BookStore
+// implementations, in the spirit of testing/fstest.
+package storetest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"http-rest/internal/store"
+	"http-rest/internal/types"
+)
+
+// TestStore exercises s and reports the first behaviour that deviates from
+// the BookStore contract. s must be empty when TestStore is called.
+func TestStore(s store.BookStore) error {
+	ctx := context.Background()
+	checks := []struct {
+		name string
+		fn   func(context.Context, store.BookStore) error
+	}{
+		{"create and get", testCreateGet},
+		{"create many", testCreateMany},
+		{"list filters", testList},
+		{"publication data", testPublication},
+		{"isbn uniqueness", testISBN},
+		{"pagination", testPagination},
+		{"update", testUpdate},
+		{"update aborted", testUpdateAborted},
+		{"delete", testDelete},
+		{"delete vetoed", testDeleteVetoed},
+		{"not found", testNotFound},
+		{"concurrent creates", testConcurrentCreates},
+	}
+	for _, c := range checks {
+		if err := c.fn(ctx, s); err != nil {
+			return fmt.Errorf("storetest: %s: %w", c.name, err)
+		}
+		if err := reset(ctx, s); err != nil {
+			return fmt.Errorf("storetest: %s: cleanup: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+func newBook(title, author string) types.Book {
+	return types.Book{Title: title, Author: author, Added: time.Now().UTC()}
+}
+
+func sameBook(a, b types.Book) bool {
+	return a.ID == b.ID && a.Title == b.Title && a.Author == b.Author && a.Version == b.Version &&
+		slices.Equal(a.Authors, b.Authors) && slices.Equal(a.Tags, b.Tags) && a.ISBN == b.ISBN &&
+		a.Year == b.Year && a.Publisher == b.Publisher && a.Language == b.Language &&
+		a.Added.Truncate(time.Microsecond).Equal(b.Added.Truncate(time.Microsecond)) &&
+		a.Updated.Truncate(time.Microsecond).Equal(b.Updated.Truncate(time.Microsecond))
+}
+
+func reset(ctx context.Context, s store.BookStore) error {
+	all, err := s.List(ctx, store.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, b := range all {
+		if err := s.Delete(ctx, b.ID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testCreateGet(ctx context.Context, s store.BookStore) error {
+	a, err := s.Create(ctx, newBook("Dune", "Frank Herbert"))
+	if err != nil {
+		return err
+	}
+	b, err := s.Create(ctx, newBook("Emma", "Jane Austen"))
+	if err != nil {
+		return err
+	}
+	if a.ID == 0 || b.ID == 0 || a.ID == b.ID {
+		return fmt.Errorf("ids not unique and non-zero: %d, %d", a.ID, b.ID)
+	}
+	if a.Version != 1 || !a.Updated.Equal(a.Added) {
+		return fmt.Errorf("new book has version %d and updated %v, want 1 and %v", a.Version, a.Updated, a.Added)
+	}
+	got, err := s.Get(ctx, a.ID)
+	if err != nil {
+		return err
+	}
+	if !sameBook(got, a) {
+		return fmt.Errorf("Get(%d) = %+v, want %+v", a.ID, got, a)
+	}
+	return nil
+}
+
+func testCreateMany(ctx context.Context, s store.BookStore) error {
+	in := []types.Book{newBook("Beloved", "Toni Morrison"), newBook("Jazz", "Toni Morrison"), newBook("Sula", "Toni Morrison")}
+	created, err := s.CreateMany(ctx, in)
+	if err != nil {
+		return err
+	}
+	if len(created) != len(in) {
+		return fmt.Errorf("CreateMany returned %d books, want %d", len(created), len(in))
+	}
+	if n, err := s.Count(ctx); err != nil || n != int64(len(in)) {
+		return fmt.Errorf("Count() = %d, %v; want %d", n, err, len(in))
+	}
+	for i, b := range created {
+		if b.Title != in[i].Title || b.Version != 1 || (i > 0 && b.ID <= created[i-1].ID) {
+			return fmt.Errorf("CreateMany[%d] = %+v", i, b)
+		}
+		got, err := s.Get(ctx, b.ID)
+		if err != nil {
+			return err
+		}
+		if !sameBook(got, b) {
+			return fmt.Errorf("Get(%d) = %+v, want %+v", b.ID, got, b)
+		}
+	}
+	return nil
+}
+
+func testList(ctx context.Context, s store.BookStore) error {
+	var created []types.Book
+	for _, in := range []types.Book{
+		newBook("The Hobbit", "J.R.R. Tolkien"),
+		newBook("The Silmarillion", "J.R.R. Tolkien"),
+		newBook("100% Pure_Fiction", "Anon"),
+		newBook("Persuasion", "Jane Austen"),
+	} {
+		b, err := s.Create(ctx, in)
+		if err != nil {
+			return err
+		}
+		created = append(created, b)
+	}
+
+	cases := []struct {
+		f    store.ListOptions
+		want []types.Book
+	}{
+		{store.ListOptions{}, created},
+		{store.ListOptions{Filter: store.Filter{Author: "J.R.R. Tolkien"}}, created[:2]},
+		{store.ListOptions{Filter: store.Filter{Query: "the"}}, created[:2]},
+		{store.ListOptions{Filter: store.Filter{Query: "SILM"}}, created[1:2]},
+		{store.ListOptions{Filter: store.Filter{Query: "0% pure_"}}, created[2:3]},
+		{store.ListOptions{Filter: store.Filter{Query: "_"}}, created[2:3]},
+		{store.ListOptions{Filter: store.Filter{Author: "Jane Austen", Query: "hobbit"}}, nil},
+	}
+	for _, c := range cases {
+		got, err := s.List(ctx, c.f)
+		if err != nil {
+			return err
+		}
+		if len(got) != len(c.want) {
+			return fmt.Errorf("List(%+v) returned %d books, want %d", c.f, len(got), len(c.want))
+		}
+		for i := range got {
+			if !sameBook(got[i], c.want[i]) {
+				return fmt.Errorf("List(%+v)[%d] = %+v, want %+v", c.f, i, got[i], c.want[i])
+			}
+		}
+	}
+	return nil
+}
+
+func testPublication(ctx context.Context, s store.BookStore) error {
+	var created []types.Book
+	for _, in := range []types.Book{
+		{Title: "Good Omens", Author: "Terry Pratchett", Authors: []string{"Terry Pratchett", "Neil Gaiman"},
+			ISBN: "9780060853983", Year: 1990, Publisher: "Gollancz", Language: "en", Tags: []string{"comedy", "fantasy"}},
+		{Title: "Coraline", Author: "Neil Gaiman", Authors: []string{"Neil Gaiman"},
+			Year: 2002, Publisher: "Bloomsbury", Language: "en", Tags: []string{"fantasy"}},
+		{Title: "Ensaio sobre a Cegueira", Author: "José Saramago", Authors: []string{"José Saramago"},
+			Year: 1995, Publisher: "Caminho", Language: "pt"},
+		{Title: "Untitled", Author: "Anon"},
+	} {
+		in.Added = time.Now().UTC()
+		b, err := s.Create(ctx, in)
+		if err != nil {
+			return err
+		}
+		got, err := s.Get(ctx, b.ID)
+		if err != nil {
+			return err
+		}
+		if !sameBook(got, b) || !slices.Equal(got.Authors, in.Authors) || !slices.Equal(got.Tags, in.Tags) || got.ISBN != in.ISBN {
+			return fmt.Errorf("Get(%d) = %+v, want %+v", b.ID, got, in)
+		}
+		created = append(created, b)
+	}
+
+	cases := []struct {
+		f    store.Filter
+		want []types.Book
+	}{
+		{store.Filter{Author: "Neil Gaiman"}, created[:2]},
+		{store.Filter{ISBN: "9780060853983"}, created[:1]},
+		{store.Filter{Publisher: "Caminho"}, created[2:3]},
+		{store.Filter{Language: "en"}, created[:2]},
+		{store.Filter{YearFrom: 1991}, created[1:3]},
+		{store.Filter{YearFrom: 1990, YearTo: 1995}, []types.Book{created[0], created[2]}},
+		{store.Filter{YearTo: 1989}, nil},
+		{store.Filter{Tags: []string{"fantasy"}}, created[:2]},
+		{store.Filter{Tags: []string{"fantasy", "comedy"}}, created[:1]},
+		{store.Filter{Tags: []string{"fantasy"}, Author: "Terry Pratchett", Language: "en"}, created[:1]},
+	}
+	for _, c := range cases {
+		got, err := s.List(ctx, store.ListOptions{Filter: c.f})
+		if err != nil {
+			return err
+		}
+		if len(got) != len(c.want) {
+			return fmt.Errorf("List(%+v) returned %d books, want %d", c.f, len(got), len(c.want))
+		}
+		for i := range got {
+			if !sameBook(got[i], c.want[i]) {
+				return fmt.Errorf("List(%+v)[%d] = %+v, want %+v", c.f, i, got[i], c.want[i])
+			}
+		}
+	}
+
+	updated, err := s.Update(ctx, created[1].ID, func(b *types.Book) error {
+		b.Authors = append(b.Authors, "Dave McKean")
+		b.Tags = nil
+		b.Year = 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	got, err := s.Get(ctx, updated.ID)
+	if err != nil {
+		return err
+	}
+	if !sameBook(got, updated) || len(got.Authors) != 2 || len(got.Tags) != 0 || got.Year != 0 {
+		return fmt.Errorf("Get after Update = %+v, want %+v", got, updated)
+	}
+	return nil
+}
+
+func testISBN(ctx context.Context, s store.BookStore) error {
+	const isbn = "9780441013593"
+	dune := newBook("Dune", "Frank Herbert")
+	dune.ISBN = isbn
+	a, err := s.Create(ctx, dune)
+	if err != nil {
+		return err
+	}
+	got, err := s.GetByISBN(ctx, isbn)
+	if err != nil {
+		return err
+	}
+	if !sameBook(got, a) {
+		return fmt.Errorf("GetByISBN = %+v, want %+v", got, a)
+	}
+	if _, err := s.GetByISBN(ctx, "9780000000002"); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("GetByISBN of a missing ISBN error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.Create(ctx, dune); !errors.Is(err, store.ErrDuplicateISBN) {
+		return fmt.Errorf("Create with a taken ISBN error = %v, want ErrDuplicateISBN", err)
+	}
+	// Books without an ISBN never clash.
+	if _, err := s.CreateMany(ctx, []types.Book{newBook("A", "X"), newBook("B", "X")}); err != nil {
+		return err
+	}
+	n, err := s.Count(ctx)
+	if err != nil {
+		return err
+	}
+	other := newBook("Other", "Someone")
+	other.ISBN = "9780306406157"
+	if _, err := s.CreateMany(ctx, []types.Book{other, dune}); !errors.Is(err, store.ErrDuplicateISBN) {
+		return fmt.Errorf("CreateMany with a taken ISBN error = %v, want ErrDuplicateISBN", err)
+	}
+	if m, err := s.Count(ctx); err != nil || m != n {
+		return fmt.Errorf("failed CreateMany stored books: Count() = %d, %v; want %d", m, err, n)
+	}
+
+	b, err := s.Create(ctx, other)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Update(ctx, b.ID, func(b *types.Book) error {
+		b.ISBN = isbn
+		return nil
+	}); !errors.Is(err, store.ErrDuplicateISBN) {
+		return fmt.Errorf("Update to a taken ISBN error = %v, want ErrDuplicateISBN", err)
+	}
+	if got, err := s.GetByISBN(ctx, other.ISBN); err != nil || got.ID != b.ID {
+		return fmt.Errorf("failed Update changed the book: %+v, %v", got, err)
+	}
+
+	// Deleting a book frees its ISBN.
+	if err := s.Delete(ctx, a.ID, nil); err != nil {
+		return err
+	}
+	if _, err := s.Update(ctx, b.ID, func(b *types.Book) error {
+		b.ISBN = isbn
+		return nil
+	}); err != nil {
+		return err
+	}
+	if _, err := s.GetByISBN(ctx, other.ISBN); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("GetByISBN of a replaced ISBN error = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func testPagination(ctx context.Context, s store.BookStore) error {
+	// Duplicate authors and timestamps force the ID tie-breaker to matter.
+	added := time.Now().UTC()
+	var created []types.Book
+	for _, in := range []struct{ title, author string }{
+		{"Mansfield Park", "Austen"}, {"Beloved", "Morrison"}, {"Emma", "Austen"},
+		{"Ulysses", "Joyce"}, {"Dubliners", "Joyce"}, {"Jazz", "Morrison"}, {"Sula", "Morrison"},
+	} {
+		b, err := s.Create(ctx, types.Book{Title: in.title, Author: in.author, Added: added})
+		if err != nil {
+			return err
+		}
+		created = append(created, b)
+	}
+
+	for _, sort := range []store.SortField{store.SortID, store.SortTitle, store.SortAuthor, store.SortAdded} {
+		for _, desc := range []bool{false, true} {
+			all, err := s.List(ctx, store.ListOptions{Sort: sort, Desc: desc})
+			if err != nil {
+				return err
+			}
+			if len(all) != len(created) {
+				return fmt.Errorf("sort=%s desc=%v: got %d books, want %d", sort, desc, len(all), len(created))
+			}
+			for i := 1; i < len(all); i++ {
+				if !ordered(sort, desc, all[i-1], all[i]) {
+					return fmt.Errorf("sort=%s desc=%v: %+v listed before %+v", sort, desc, all[i-1], all[i])
+				}
+			}
+
+			var paged []types.Book
+			opts := store.ListOptions{Sort: sort, Desc: desc, Limit: 3}
+			for {
+				page, err := s.List(ctx, opts)
+				if err != nil {
+					return err
+				}
+				if len(page) > opts.Limit {
+					return fmt.Errorf("sort=%s desc=%v: page of %d exceeds limit %d", sort, desc, len(page), opts.Limit)
+				}
+				paged = append(paged, page...)
+				if len(page) < opts.Limit {
+					break
+				}
+				c := store.CursorFor(page[len(page)-1], sort)
+				opts.After = &c
+			}
+			if len(paged) != len(all) {
+				return fmt.Errorf("sort=%s desc=%v: paging returned %d books, want %d", sort, desc, len(paged), len(all))
+			}
+			for i := range all {
+				if paged[i].ID != all[i].ID {
+					return fmt.Errorf("sort=%s desc=%v: page order differs at %d", sort, desc, i)
+				}
+			}
+		}
+	}
+
+	got, err := s.List(ctx, store.ListOptions{Filter: store.Filter{Author: "Morrison"}, Sort: store.SortTitle, Limit: 2})
+	if err != nil {
+		return err
+	}
+	if len(got) != 2 || got[0].Title != "Beloved" || got[1].Title != "Jazz" {
+		return fmt.Errorf("filtered page = %+v, want Beloved, Jazz", got)
+	}
+	return nil
+}
+
+func ordered(f store.SortField, desc bool, a, b types.Book) bool {
+	var c int
+	switch f {
+	case store.SortTitle:
+		c = strings.Compare(a.Title, b.Title)
+	case store.SortAuthor:
+		c = strings.Compare(a.Author, b.Author)
+	case store.SortAdded:
+		c = a.Added.Compare(b.Added)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.ID, b.ID)
+	}
+	if desc {
+		c = -c
+	}
+	return c < 0
+}
+
+func testUpdate(ctx context.Context, s store.BookStore) error {
+	b, err := s.Create(ctx, newBook("Draft", "Someone"))
+	if err != nil {
+		return err
+	}
+	updated, err := s.Update(ctx, b.ID, func(b *types.Book) error {
+		b.Title = "Final"
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if updated.ID != b.ID || updated.Title != "Final" || updated.Author != "Someone" {
+		return fmt.Errorf("Update returned %+v", updated)
+	}
+	if updated.Version != b.Version+1 || updated.Updated.Before(b.Updated) {
+		return fmt.Errorf("Update returned version %d updated %v, want %d and not before %v",
+			updated.Version, updated.Updated, b.Version+1, b.Updated)
+	}
+	got, err := s.Get(ctx, b.ID)
+	if err != nil {
+		return err
+	}
+	if !sameBook(got, updated) {
+		return fmt.Errorf("Get after Update = %+v, want %+v", got, updated)
+	}
+	return nil
+}
+
+func testUpdateAborted(ctx context.Context, s store.BookStore) error {
+	b, err := s.Create(ctx, newBook("Keep", "Me"))
+	if err != nil {
+		return err
+	}
+	abort := errors.New("abort")
+	_, err = s.Update(ctx, b.ID, func(b *types.Book) error {
+		b.Title = "Changed"
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		return fmt.Errorf("Update error = %v, want %v", err, abort)
+	}
+	got, err := s.Get(ctx, b.ID)
+	if err != nil {
+		return err
+	}
+	if got.Title != "Keep" || got.Version != b.Version {
+		return fmt.Errorf("aborted Update was persisted: %+v", got)
+	}
+	return nil
+}
+
+func testDelete(ctx context.Context, s store.BookStore) error {
+	b, err := s.Create(ctx, newBook("Gone", "Soon"))
+	if err != nil {
+		return err
+	}
+	if err := s.Delete(ctx, b.ID, nil); err != nil {
+		return err
+	}
+	if _, err := s.Get(ctx, b.ID); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func testDeleteVetoed(ctx context.Context, s store.BookStore) error {
+	b, err := s.Create(ctx, newBook("Stay", "Here"))
+	if err != nil {
+		return err
+	}
+	veto := errors.New("veto")
+	var seen types.Book
+	err = s.Delete(ctx, b.ID, func(cur types.Book) error {
+		seen = cur
+		return veto
+	})
+	if !errors.Is(err, veto) {
+		return fmt.Errorf("Delete error = %v, want %v", err, veto)
+	}
+	if !sameBook(seen, b) {
+		return fmt.Errorf("Delete check saw %+v, want %+v", seen, b)
+	}
+	if _, err := s.Get(ctx, b.ID); err != nil {
+		return fmt.Errorf("vetoed Delete removed the book: %v", err)
+	}
+	if err := s.Delete(ctx, b.ID, func(types.Book) error { return nil }); err != nil {
+		return err
+	}
+	if _, err := s.Get(ctx, b.ID); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func testNotFound(ctx context.Context, s store.BookStore) error {
+	const missing = 1 << 40
+	if _, err := s.Get(ctx, missing); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Get error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Update(ctx, missing, func(*types.Book) error { return nil }); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Update error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, missing, nil); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Delete error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, missing, func(types.Book) error { return nil }); !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("checked Delete error = %v, want ErrNotFound", err)
+	}
+	return nil
+}
+
+func testConcurrentCreates(ctx context.Context, s store.BookStore) error {
+	const n = 20
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		ids  = make(map[int64]bool)
+		errs []error
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := s.Create(ctx, newBook(fmt.Sprintf("Book %d", i), "Many"))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			ids[b.ID] = true
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	if len(ids) != n {
+		return fmt.Errorf("got %d distinct ids, want %d", len(ids), n)
+	}
+	return nil
+}
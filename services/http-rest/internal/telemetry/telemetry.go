@@ -0,0 +1,76 @@
+// Package telemetry wires OpenTelemetry tracing for http-rest: the global
+// tracer provider, W3C propagation and an instrumented outbound transport.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+)
+
+// InstrumentationName identifies spans created by this service's own code.
+const InstrumentationName = "http-rest"
+
+type Config struct {
+	ServiceName string
+	// Exporter is "none", "stdout" or "otlp".
+	Exporter string
+	// Endpoint overrides the OTLP/HTTP endpoint URL; empty uses the
+	// standard OTEL_EXPORTER_OTLP_* environment variables.
+	Endpoint string
+	// SpanExporter, when set, replaces Exporter. Tests pass a
+	// tracetest.InMemoryExporter here.
+	SpanExporter sdktrace.SpanExporter
+}
+
+// Setup installs the W3C trace-context propagator and, unless tracing is
+// disabled, a global tracer provider. The returned function flushes and
+// stops the provider.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	exp := cfg.SpanExporter
+	if exp == nil {
+		switch cfg.Exporter {
+		case "", "none":
+			// Incoming trace context is still forwarded; no spans are recorded.
+			return func(context.Context) error { return nil }, nil
+		case "stdout":
+			exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		case "otlp":
+			var opts []otlptracehttp.Option
+			if cfg.Endpoint != "" {
+				opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+			}
+			exp, err = otlptracehttp.New(ctx, opts...)
+		default:
+			return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
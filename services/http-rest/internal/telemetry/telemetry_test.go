@@ -0,0 +1,127 @@
+package telemetry_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"http-rest/internal/telemetry"
+)
+
+// record installs a tracer provider exporting to memory and returns a
+// func that flushes it and lists the spans ended so far.
+func record(t *testing.T) func() tracetest.SpanStubs {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	shutdown, err := telemetry.Setup(context.Background(), telemetry.Config{ServiceName: "test", SpanExporter: exp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+	return func() tracetest.SpanStubs {
+		t.Helper()
+		if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return exp.GetSpans()
+	}
+}
+
+func attr(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTransport(t *testing.T) {
+	spans := record(t)
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: telemetry.NewTransport(nil)}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		code   codes.Code
+	}{
+		{"/ok", http.StatusOK, codes.Unset},
+		{"/fail", http.StatusBadGateway, codes.Error},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+			req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+tt.path, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			parent.End()
+			if req.Header.Get("traceparent") != "" {
+				t.Error("the caller's request was modified")
+			}
+
+			// The downstream request carries the client span's context.
+			sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(got)))
+			if !sc.IsValid() {
+				t.Fatalf("no valid traceparent sent: %q", got.Get("traceparent"))
+			}
+			var span *tracetest.SpanStub
+			for _, s := range spans() {
+				if s.SpanContext.SpanID() == sc.SpanID() {
+					span = &s
+				}
+			}
+			if span == nil {
+				t.Fatalf("traceparent %q names no recorded span", got.Get("traceparent"))
+			}
+			if span.SpanContext.TraceID() != parent.SpanContext().TraceID() || span.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Error("the client span is not a child of the caller's span")
+			}
+			if span.Name != "GET" || span.SpanKind != trace.SpanKindClient {
+				t.Errorf("span %q of kind %v, want GET, client", span.Name, span.SpanKind)
+			}
+			if v := attr(*span, semconv.HTTPResponseStatusCodeKey); v.AsInt64() != int64(tt.status) {
+				t.Errorf("%s = %v, want %d", semconv.HTTPResponseStatusCodeKey, v.Emit(), tt.status)
+			}
+			if v := attr(*span, semconv.URLFullKey); v.AsString() != srv.URL+tt.path {
+				t.Errorf("%s = %q", semconv.URLFullKey, v.AsString())
+			}
+			if span.Status.Code != tt.code {
+				t.Errorf("span status %v, want %v", span.Status.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	spans := record(t)
+	srv := httptest.NewServer(nil)
+	srv.Close() // connections are refused
+	client := &http.Client{Transport: telemetry.NewTransport(nil)}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	got := spans()
+	if len(got) != 1 || got[0].Status.Code != codes.Error || len(got[0].Events) == 0 {
+		t.Errorf("spans %+v, want one erroring span with the error recorded", got)
+	}
+}
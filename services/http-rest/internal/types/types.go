@@ -3,8 +3,19 @@ package types
 import "time"
 
 type Book struct {
-	ID     int64     `json:"id"`
-	Title  string    `json:"title"`
-	Author string    `json:"author"`
-	Added  time.Time `json:"added"`
+	ID     int64  `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	// Authors lists every author in credit order; Author is the first.
+	Authors []string `json:"authors,omitempty"`
+	// ISBN is stored in its 13-digit form and is unique when set.
+	ISBN      string    `json:"isbn,omitempty"`
+	Year      int       `json:"year,omitempty"` // of publication
+	Publisher string    `json:"publisher,omitempty"`
+	Language  string    `json:"language,omitempty"` // BCP 47 tag, e.g. "en" or "pt-BR"
+	Tags      []string  `json:"tags,omitempty"`
+	Added     time.Time `json:"added"`
+	Updated   time.Time `json:"updated"`
+	// Version starts at 1 and grows by one on every update; it backs the ETag.
+	Version int64 `json:"version"`
 }
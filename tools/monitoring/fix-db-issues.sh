@@ -120,8 +120,10 @@ test_database_connections() {
         echo -e "${RED}✗ PostgreSQL connection failed${RESET}"
         echo -e "${YELLOW}Attempting to fix PostgreSQL...${RESET}"
         
-        # Reinitialize PostgreSQL
-        docker exec apm-postgres psql -U testuser -d testdb -f /docker-entrypoint-initdb.d/postgres-init.sql
+        # Reinitialize PostgreSQL: grants, then the schema, then sample rows
+        docker exec -i apm-postgres psql -U testuser -d testdb < scripts/database/postgres-init.sql
+        (cd services/db-sql-multi && go run ./cmd/migrate -store postgres up)
+        docker exec -i apm-postgres psql -U testuser -d testdb < scripts/database/postgres-seed.sql
         echo -e "${GREEN}✓ PostgreSQL reinitialized${RESET}"
     fi
     
@@ -132,8 +134,10 @@ test_database_connections() {
         echo -e "${RED}✗ MySQL connection failed${RESET}"
         echo -e "${YELLOW}Attempting to fix MySQL...${RESET}"
         
-        # Reinitialize MySQL
-        docker exec apm-mysql mysql -u testuser -pTest@1234 -D testdb < /docker-entrypoint-initdb.d/mysql-init.sql
+        # Reinitialize MySQL: grants (as root), then the schema, then sample rows
+        docker exec -i apm-mysql mysql -u root -prootpass -D testdb < scripts/database/mysql-init.sql
+        (cd services/db-sql-multi && go run ./cmd/migrate -store mysql up)
+        docker exec -i apm-mysql mysql -u testuser -pTest@1234 -D testdb < scripts/database/mysql-seed.sql
         echo -e "${GREEN}✓ MySQL reinitialized${RESET}"
     fi
 }